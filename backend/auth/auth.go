@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"study_grade/config"
+	"study_grade/models"
+)
+
+// Джерела облікових записів (users.auth_source)
+const (
+	SourceLocal = "local"
+	SourceLDAP  = "ldap"
+)
+
+// ErrInvalidCredentials - невідомий користувач або неправильний пароль.
+// Інші помилки означають, що бекенд недоступний і відповідь невідома.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Authenticator перевіряє ім'я користувача та пароль і повертає локальний запис users
+type Authenticator interface {
+	// Name - джерело облікових записів, яке обслуговує бекенд (users.auth_source)
+	Name() string
+	Authenticate(ctx context.Context, username, password string) (models.User, error)
+}
+
+// Chain опитує бекенди по черзі, доки один з них не прийме облікові дані
+type Chain []Authenticator
+
+// Default - ланцюжок, налаштований InitFromEnv (за замовчуванням лише локальні паролі)
+var Default Chain
+
+// Authenticate повертає першого успішно автентифікованого користувача.
+// Якщо жоден бекенд не прийняв дані, але якийсь був недоступний, повертається його помилка,
+// щоб збій каталогу не рахувався як невдала спроба входу.
+func (c Chain) Authenticate(ctx context.Context, username, password string) (models.User, error) {
+	var unavailable error
+	for _, a := range c {
+		user, err := a.Authenticate(ctx, username, password)
+		if err == nil {
+			log.Println("User", user.ID, "authenticated by", a.Name(), "backend")
+			return user, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			log.Println("Authentication backend", a.Name(), "failed:", err)
+			unavailable = err
+		}
+	}
+	if unavailable != nil {
+		return models.User{}, unavailable
+	}
+	return models.User{}, ErrInvalidCredentials
+}
+
+// Backend повертає бекенд для джерела облікових записів або nil, якщо його не увімкнено
+func (c Chain) Backend(source string) Authenticator {
+	for _, a := range c {
+		if a.Name() == source {
+			return a
+		}
+	}
+	return nil
+}
+
+// InitFromEnv будує ланцюжок з AUTH_BACKENDS (через кому, у порядку опитування: local, ldap)
+// і вмикає вхід через OpenID Connect, якщо його налаштовано
+func InitFromEnv(db *sql.DB) {
+	Default = nil
+	for _, name := range config.List("AUTH_BACKENDS", []string{SourceLocal}) {
+		switch name {
+		case SourceLocal:
+			Default = append(Default, NewLocal(db))
+		case SourceLDAP:
+			Default = append(Default, NewLDAP(db, LDAPConfigFromEnv(), nil))
+		default:
+			log.Printf("Unknown authentication backend %q in AUTH_BACKENDS, skipping", name)
+		}
+	}
+	if len(Default) == 0 {
+		log.Println("No authentication backends configured, falling back to local")
+		Default = Chain{NewLocal(db)}
+	}
+	names := make([]string, len(Default))
+	for i, a := range Default {
+		names[i] = a.Name()
+	}
+	log.Println("Authentication backends:", strings.Join(names, ", "))
+
+	initOIDCFromEnv(db)
+}
+
+const userColumns = "id, username, password, email, role, totp_enabled, token_version, disabled, must_change_password, auth_source, institution_id"
+
+// loadUser читає запис users разом із хешем пароля
+func loadUser(ctx context.Context, db *sql.DB, username string) (models.User, string, error) {
+	return scanUser(db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+// loadUserByID читає запис users за ID
+func loadUserByID(ctx context.Context, db *sql.DB, id int) (models.User, error) {
+	user, _, err := scanUser(db.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ?", id))
+	return user, err
+}
+
+func scanUser(row *sql.Row) (models.User, string, error) {
+	var user models.User
+	var hashedPassword string
+	var email sql.NullString
+	err := row.Scan(&user.ID, &user.Username, &hashedPassword, &email, &user.Role, &user.TOTPEnabled, &user.TokenVersion, &user.Disabled, &user.MustChangePassword, &user.AuthSource, &user.InstitutionID)
+	user.Email = email.String
+	return user, hashedPassword, err
+}
@@ -0,0 +1,219 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"study_grade/config"
+	"study_grade/models"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// LDAPConfig - параметри підключення до каталогу (LDAP або Active Directory)
+type LDAPConfig struct {
+	URL                string
+	StartTLS           bool
+	InsecureSkipVerify bool
+	// BindDN/BindPassword - службовий обліковий запис для пошуку користувача
+	BindDN       string
+	BindPassword string
+	BaseDN       string
+	// UserFilter - фільтр пошуку, %s замінюється екранованим ім'ям користувача
+	UserFilter string
+	// UserDNTemplate - якщо задано, DN будується напряму (%s - ім'я) і пошук не потрібен
+	UserDNTemplate    string
+	UsernameAttribute string
+	EmailAttribute    string
+	GroupAttribute    string
+	// AdminGroups - DN груп, члени яких отримують роль admin
+	AdminGroups []string
+	SyncRole    bool
+	Timeout     time.Duration
+	// InstitutionID - установа, до якої належать користувачі цього каталогу
+	InstitutionID int
+}
+
+// LDAPConfigFromEnv читає LDAP_* змінні оточення
+func LDAPConfigFromEnv() LDAPConfig {
+	return LDAPConfig{
+		URL:                config.String("LDAP_URL", "ldap://localhost:389"),
+		StartTLS:           config.Bool("LDAP_START_TLS", false),
+		InsecureSkipVerify: config.Bool("LDAP_INSECURE_SKIP_VERIFY", false),
+		BindDN:             config.String("LDAP_BIND_DN", ""),
+		BindPassword:       config.String("LDAP_BIND_PASSWORD", ""),
+		BaseDN:             config.String("LDAP_BASE_DN", ""),
+		UserFilter:         config.String("LDAP_USER_FILTER", "(uid=%s)"),
+		UserDNTemplate:     config.String("LDAP_USER_DN_TEMPLATE", ""),
+		UsernameAttribute:  config.String("LDAP_USERNAME_ATTRIBUTE", "uid"),
+		EmailAttribute:     config.String("LDAP_EMAIL_ATTRIBUTE", "mail"),
+		GroupAttribute:     config.String("LDAP_GROUP_ATTRIBUTE", "memberOf"),
+		AdminGroups:        config.List("LDAP_ADMIN_GROUPS", nil),
+		SyncRole:           config.Bool("LDAP_SYNC_ROLE", false),
+		Timeout:            config.Duration("LDAP_TIMEOUT", 5*time.Second),
+		InstitutionID:      config.Int("LDAP_INSTITUTION_ID", 1),
+	}
+}
+
+// Conn - операції з'єднання з каталогом, потрібні для входу (реалізується *ldap.Conn)
+type Conn interface {
+	Bind(username, password string) error
+	Search(req *ldap.SearchRequest) (*ldap.SearchResult, error)
+	Close() error
+}
+
+// Dialer відкриває з'єднання з каталогом; у тестах підміняється сервером у пам'яті
+type Dialer func(ctx context.Context) (Conn, error)
+
+// LDAP перевіряє пароль прив'язкою (bind) до каталогу і створює локальний запис при першому вході
+type LDAP struct {
+	db   *sql.DB
+	cfg  LDAPConfig
+	dial Dialer
+}
+
+// NewLDAP створює бекенд; dial=nil означає мережеве з'єднання за cfg.URL
+func NewLDAP(db *sql.DB, cfg LDAPConfig, dial Dialer) *LDAP {
+	a := &LDAP{db: db, cfg: cfg, dial: dial}
+	if a.dial == nil {
+		a.dial = a.dialNetwork
+	}
+	return a
+}
+
+func (a *LDAP) Name() string { return SourceLDAP }
+
+func (a *LDAP) dialNetwork(ctx context.Context) (Conn, error) {
+	dialer := &net.Dialer{Timeout: a.cfg.Timeout}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: a.cfg.InsecureSkipVerify}
+	if u, err := url.Parse(a.cfg.URL); err == nil {
+		tlsConfig.ServerName = u.Hostname()
+	}
+	conn, err := ldap.DialURL(a.cfg.URL, ldap.DialWithDialer(dialer), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(a.cfg.Timeout)
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (a *LDAP) Authenticate(ctx context.Context, username, password string) (models.User, error) {
+	// Порожній пароль дає "неавтентифіковану" прив'язку, яку багато серверів вважають успішною
+	if password == "" {
+		return models.User{}, ErrInvalidCredentials
+	}
+
+	conn, err := a.dial(ctx)
+	if err != nil {
+		return models.User{}, fmt.Errorf("ldap dial: %w", err)
+	}
+	defer conn.Close()
+
+	entry, err := a.findUser(conn, username)
+	if err != nil {
+		return models.User{}, err
+	}
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			log.Println("LDAP bind rejected for username:", username)
+			return models.User{}, ErrInvalidCredentials
+		}
+		return models.User{}, fmt.Errorf("ldap bind: %w", err)
+	}
+
+	// При прямій прив'язці атрибути читаємо вже від імені користувача
+	if a.cfg.UserDNTemplate != "" {
+		if entry, err = a.readEntry(conn, entry.DN); err != nil {
+			return models.User{}, err
+		}
+	}
+
+	localName := entry.GetAttributeValue(a.cfg.UsernameAttribute)
+	if localName == "" {
+		localName = username
+	}
+	return Provision(ctx, a.db, ExternalUser{
+		Source:        SourceLDAP,
+		Username:      localName,
+		Email:         entry.GetAttributeValue(a.cfg.EmailAttribute),
+		Role:          a.role(entry),
+		SyncRole:      a.cfg.SyncRole,
+		InstitutionID: a.cfg.InstitutionID,
+	})
+}
+
+// findUser визначає DN користувача: за шаблоном або пошуком від імені службового запису
+func (a *LDAP) findUser(conn Conn, username string) (*ldap.Entry, error) {
+	if a.cfg.UserDNTemplate != "" {
+		return &ldap.Entry{DN: fmt.Sprintf(a.cfg.UserDNTemplate, ldap.EscapeDN(username))}, nil
+	}
+
+	if a.cfg.BindDN != "" {
+		if err := conn.Bind(a.cfg.BindDN, a.cfg.BindPassword); err != nil {
+			return nil, fmt.Errorf("ldap service bind: %w", err)
+		}
+	}
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.cfg.BaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, int(a.cfg.Timeout.Seconds()), false,
+		fmt.Sprintf(a.cfg.UserFilter, ldap.EscapeFilter(username)),
+		a.attributes(), nil,
+	))
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	switch {
+	case result == nil || len(result.Entries) == 0:
+		log.Println("LDAP user not found:", username)
+		return nil, ErrInvalidCredentials
+	case len(result.Entries) > 1:
+		log.Println("LDAP filter matched several entries for username:", username)
+		return nil, ErrInvalidCredentials
+	}
+	return result.Entries[0], nil
+}
+
+func (a *LDAP) readEntry(conn Conn, dn string) (*ldap.Entry, error) {
+	result, err := conn.Search(ldap.NewSearchRequest(
+		dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(a.cfg.Timeout.Seconds()), false,
+		"(objectClass=*)", a.attributes(), nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap read entry: %w", err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, errors.New("ldap read entry: no entry for " + dn)
+	}
+	return result.Entries[0], nil
+}
+
+func (a *LDAP) attributes() []string {
+	return []string{a.cfg.UsernameAttribute, a.cfg.EmailAttribute, a.cfg.GroupAttribute}
+}
+
+// role повертає admin для членів LDAP_ADMIN_GROUPS, інакше роль за замовчуванням
+func (a *LDAP) role(entry *ldap.Entry) string {
+	for _, group := range entry.GetAttributeValues(a.cfg.GroupAttribute) {
+		for _, admin := range a.cfg.AdminGroups {
+			if strings.EqualFold(strings.TrimSpace(group), admin) {
+				return models.RoleAdmin
+			}
+		}
+	}
+	return models.RoleTeacher
+}
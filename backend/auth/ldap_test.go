@@ -0,0 +1,225 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"study_grade/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeEntry - запис каталогу в пам'яті
+type fakeEntry struct {
+	password string
+	attrs    map[string][]string
+}
+
+// fakeDirectory - LDAP-каталог у пам'яті, що підставляється через Dialer
+type fakeDirectory struct {
+	serviceDN, servicePassword string
+	entries                    map[string]fakeEntry
+	dials                      int
+}
+
+func (d *fakeDirectory) dial(ctx context.Context) (Conn, error) {
+	d.dials++
+	return &fakeConn{dir: d}, nil
+}
+
+type fakeConn struct {
+	dir   *fakeDirectory
+	bound string
+}
+
+func (c *fakeConn) Bind(username, password string) error {
+	if username == c.dir.serviceDN && password == c.dir.servicePassword {
+		c.bound = username
+		return nil
+	}
+	if e, ok := c.dir.entries[username]; ok && e.password == password {
+		c.bound = username
+		return nil
+	}
+	return ldap.NewError(ldap.LDAPResultInvalidCredentials, errors.New("invalid credentials"))
+}
+
+func (c *fakeConn) Search(req *ldap.SearchRequest) (*ldap.SearchResult, error) {
+	if c.bound == "" {
+		return nil, ldap.NewError(ldap.LDAPResultInsufficientAccessRights, errors.New("anonymous search"))
+	}
+	result := &ldap.SearchResult{}
+	for dn, e := range c.dir.entries {
+		match := req.Scope == ldap.ScopeBaseObject && dn == req.BaseDN
+		if req.Scope == ldap.ScopeWholeSubtree && strings.HasSuffix(dn, req.BaseDN) {
+			for _, uid := range e.attrs["uid"] {
+				match = match || req.Filter == fmt.Sprintf("(uid=%s)", ldap.EscapeFilter(uid))
+			}
+		}
+		if match {
+			result.Entries = append(result.Entries, ldap.NewEntry(dn, e.attrs))
+		}
+	}
+	return result, nil
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func newFakeDirectory() *fakeDirectory {
+	return &fakeDirectory{
+		serviceDN:       "cn=service,dc=example,dc=org",
+		servicePassword: "service-secret",
+		entries: map[string]fakeEntry{
+			"uid=olena,ou=people,dc=example,dc=org": {
+				password: "correct horse",
+				attrs: map[string][]string{
+					"uid":      {"olena"},
+					"mail":     {"olena@example.org"},
+					"memberOf": {"cn=staff,ou=groups,dc=example,dc=org"},
+				},
+			},
+			"uid=admin1,ou=people,dc=example,dc=org": {
+				password: "admin-pass",
+				attrs: map[string][]string{
+					"uid":      {"admin1"},
+					"memberOf": {"cn=Admins,ou=groups,dc=example,dc=org"},
+				},
+			},
+		},
+	}
+}
+
+func testLDAPConfig() LDAPConfig {
+	return LDAPConfig{
+		BindDN:            "cn=service,dc=example,dc=org",
+		BindPassword:      "service-secret",
+		BaseDN:            "dc=example,dc=org",
+		UserFilter:        "(uid=%s)",
+		UsernameAttribute: "uid",
+		EmailAttribute:    "mail",
+		GroupAttribute:    "memberOf",
+		AdminGroups:       []string{"cn=admins,ou=groups,dc=example,dc=org"},
+		Timeout:           time.Second,
+		InstitutionID:     1,
+	}
+}
+
+var userRowColumns = []string{"id", "username", "password", "email", "role", "totp_enabled", "token_version", "disabled", "must_change_password", "auth_source", "institution_id"}
+
+func newMockDB(t *testing.T) (*sql.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db, mock
+}
+
+func TestLDAPAuthenticateExistingUser(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("SELECT .+ FROM users WHERE username = \\?").WithArgs("olena").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(7, "olena", noPassword, "olena@example.org", models.RoleTeacher, false, 0, false, false, SourceLDAP, 1))
+
+	user, err := NewLDAP(db, testLDAPConfig(), newFakeDirectory().dial).Authenticate(context.Background(), "olena", "correct horse")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.ID != 7 || user.AuthSource != SourceLDAP {
+		t.Errorf("got user %+v, want existing ldap user 7", user)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLDAPAuthenticateWrongPassword(t *testing.T) {
+	db, mock := newMockDB(t)
+
+	_, err := NewLDAP(db, testLDAPConfig(), newFakeDirectory().dial).Authenticate(context.Background(), "olena", "wrong")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+	// Невдала прив'язка не повинна створювати чи читати локальний запис
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLDAPAuthenticateUnknownUser(t *testing.T) {
+	db, _ := newMockDB(t)
+
+	_, err := NewLDAP(db, testLDAPConfig(), newFakeDirectory().dial).Authenticate(context.Background(), "nobody", "whatever")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestLDAPAuthenticateEmptyPassword(t *testing.T) {
+	db, _ := newMockDB(t)
+	dir := newFakeDirectory()
+
+	_, err := NewLDAP(db, testLDAPConfig(), dir.dial).Authenticate(context.Background(), "olena", "")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials", err)
+	}
+	if dir.dials != 0 {
+		t.Errorf("empty password must be rejected before contacting the directory, got %d dials", dir.dials)
+	}
+}
+
+func TestLDAPAuthenticateDirectoryUnreachable(t *testing.T) {
+	db, _ := newMockDB(t)
+	unreachable := func(ctx context.Context) (Conn, error) {
+		return nil, errors.New("dial tcp 10.0.0.1:389: connect: connection refused")
+	}
+
+	_, err := NewLDAP(db, testLDAPConfig(), unreachable).Authenticate(context.Background(), "olena", "correct horse")
+	if err == nil || errors.Is(err, ErrInvalidCredentials) {
+		// Недоступний каталог не повинен рахуватися невдалою спробою входу
+		t.Fatalf("got %v, want a non-credentials error", err)
+	}
+}
+
+func TestLDAPAuthenticateProvisionsNewUser(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("SELECT .+ FROM users WHERE username = \\?").WithArgs("admin1").
+		WillReturnRows(sqlmock.NewRows(userRowColumns))
+	mock.ExpectExec("INSERT INTO users").
+		WithArgs("admin1", noPassword, sql.NullString{}, models.RoleAdmin, SourceLDAP, 1).
+		WillReturnResult(sqlmock.NewResult(12, 1))
+	mock.ExpectQuery("SELECT .+ FROM users WHERE username = \\?").WithArgs("admin1").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(12, "admin1", noPassword, nil, models.RoleAdmin, false, 0, false, false, SourceLDAP, 1))
+
+	user, err := NewLDAP(db, testLDAPConfig(), newFakeDirectory().dial).Authenticate(context.Background(), "admin1", "admin-pass")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	// Членство в LDAP_ADMIN_GROUPS порівнюється без урахування регістру
+	if user.ID != 12 || user.Role != models.RoleAdmin {
+		t.Errorf("got user %+v, want provisioned admin 12", user)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProvisionRefusesLocalAccount(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("SELECT .+ FROM users WHERE username = \\?").WithArgs("olena").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(3, "olena", "$2a$10$hash", "olena@example.org", models.RoleAdmin, false, 0, false, false, SourceLocal, 1))
+
+	_, err := Provision(context.Background(), db, ExternalUser{Source: SourceLDAP, Username: "olena", Email: "olena@example.org", InstitutionID: 1})
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Fatalf("got %v, want ErrInvalidCredentials for a local account", err)
+	}
+	// Жодних оновлень локального запису
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,43 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"study_grade/models"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Local перевіряє bcrypt-паролі з таблиці users
+type Local struct {
+	db *sql.DB
+}
+
+func NewLocal(db *sql.DB) *Local {
+	return &Local{db: db}
+}
+
+func (l *Local) Name() string { return SourceLocal }
+
+// Authenticate приймає лише локальні облікові записи: користувачі каталогу
+// не мають пароля в базі і не можуть увійти в обхід LDAP
+func (l *Local) Authenticate(ctx context.Context, username, password string) (models.User, error) {
+	user, hashedPassword, err := loadUser(ctx, l.db, username)
+	if err == sql.ErrNoRows {
+		log.Println("Local user not found:", username)
+		return models.User{}, ErrInvalidCredentials
+	}
+	if err != nil {
+		return models.User{}, err
+	}
+	if user.AuthSource != SourceLocal {
+		log.Println("User", user.ID, "is managed by", user.AuthSource, "backend, local password rejected")
+		return models.User{}, ErrInvalidCredentials
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password)); err != nil {
+		log.Println("Password mismatch for username:", username)
+		return models.User{}, ErrInvalidCredentials
+	}
+	return user, nil
+}
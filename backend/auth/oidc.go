@@ -0,0 +1,434 @@
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"study_grade/config"
+	"study_grade/models"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SourceOIDC - користувачі, що входять через провайдера OpenID Connect
+const SourceOIDC = "oidc"
+
+// OIDCConfig - параметри клієнта OpenID Connect
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	Scopes       []string
+	// UsernameClaim - claim з бажаним ім'ям нового локального користувача (якщо порожній - email).
+	// Існуючі користувачі знаходяться за iss+sub, тож зміна цього claim у провайдера
+	// не відкриває чужий обліковий запис.
+	UsernameClaim string
+	EmailClaim    string
+	// RoleClaim - claim (рядок або масив) зі значеннями, що відображаються в ролі через RoleMap
+	RoleClaim string
+	RoleMap   map[string]string
+	SyncRole  bool
+	// ClockSkew - допустиме розходження годинників при перевірці exp/iat/nbf
+	ClockSkew time.Duration
+	// InstitutionID - установа, до якої належать користувачі цього провайдера
+	InstitutionID int
+}
+
+// OIDCConfigFromEnv читає OIDC_* змінні оточення.
+// OIDC_ROLE_MAP має вигляд "значення=роль,значення=роль", наприклад "sg-admins=admin".
+func OIDCConfigFromEnv() OIDCConfig {
+	roleMap := map[string]string{}
+	for _, pair := range config.List("OIDC_ROLE_MAP", nil) {
+		value, role, ok := strings.Cut(pair, "=")
+		if !ok {
+			log.Printf("Ignoring malformed OIDC_ROLE_MAP entry %q", pair)
+			continue
+		}
+		role = strings.TrimSpace(role)
+		if !validRole(role) {
+			log.Printf("Ignoring OIDC_ROLE_MAP entry %q: unknown role %q", pair, role)
+			continue
+		}
+		roleMap[strings.TrimSpace(value)] = role
+	}
+	return OIDCConfig{
+		Issuer:        strings.TrimRight(config.String("OIDC_ISSUER", ""), "/"),
+		ClientID:      config.String("OIDC_CLIENT_ID", ""),
+		ClientSecret:  config.String("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:   config.String("OIDC_REDIRECT_URL", "http://localhost:8080/api/oidc/callback"),
+		Scopes:        config.List("OIDC_SCOPES", []string{"openid", "profile", "email"}),
+		UsernameClaim: config.String("OIDC_USERNAME_CLAIM", "preferred_username"),
+		EmailClaim:    config.String("OIDC_EMAIL_CLAIM", "email"),
+		RoleClaim:     config.String("OIDC_ROLE_CLAIM", ""),
+		RoleMap:       roleMap,
+		SyncRole:      config.Bool("OIDC_SYNC_ROLE", false),
+		ClockSkew:     config.Duration("OIDC_CLOCK_SKEW", time.Minute),
+		InstitutionID: config.Int("OIDC_INSTITUTION_ID", 1),
+	}
+}
+
+// OIDCProvider - налаштований провайдер; nil, якщо OIDC_ISSUER не задано
+var OIDCProvider *OIDC
+
+// OIDC реалізує потік authorization code з PKCE і перевірку ID-токенів за JWKS провайдера
+type OIDC struct {
+	db  *sql.DB
+	cfg OIDCConfig
+	// Client - HTTP-клієнт для discovery, токенів та JWKS (у тестах - клієнт локального мок-провайдера)
+	Client *http.Client
+
+	mu          sync.Mutex
+	discovery   *oidcDiscovery
+	keys        map[string]*rsa.PublicKey
+	keysFetched time.Time
+}
+
+type oidcDiscovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+func NewOIDC(db *sql.DB, cfg OIDCConfig) *OIDC {
+	return &OIDC{db: db, cfg: cfg, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// initOIDCFromEnv вмикає OIDC, якщо задано OIDC_ISSUER і OIDC_CLIENT_ID
+func initOIDCFromEnv(db *sql.DB) {
+	cfg := OIDCConfigFromEnv()
+	if cfg.Issuer == "" || cfg.ClientID == "" {
+		OIDCProvider = nil
+		return
+	}
+	OIDCProvider = NewOIDC(db, cfg)
+	log.Println("OpenID Connect login enabled for issuer", cfg.Issuer)
+}
+
+// PKCEChallenge обчислює code_challenge методом S256 (RFC 7636)
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL повертає адресу сторінки входу провайдера
+func (p *OIDC) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.cfg.ClientID},
+		"redirect_uri":          {p.cfg.RedirectURL},
+		"scope":                 {strings.Join(p.cfg.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {PKCEChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return d.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Login обмінює код авторизації на ID-токен, перевіряє його і повертає локального користувача
+func (p *OIDC) Login(ctx context.Context, code, verifier, nonce string) (models.User, error) {
+	rawIDToken, err := p.exchange(ctx, code, verifier)
+	if err != nil {
+		return models.User{}, err
+	}
+	claims, err := p.VerifyIDToken(ctx, rawIDToken, nonce)
+	if err != nil {
+		return models.User{}, err
+	}
+
+	email := claimString(claims, p.cfg.EmailClaim)
+	if verified, ok := claims["email_verified"].(bool); ok && !verified {
+		email = ""
+	}
+	issuer, _ := claims["iss"].(string)
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return models.User{}, errors.New("id token has no sub claim")
+	}
+	username := claimString(claims, p.cfg.UsernameClaim)
+	if username == "" {
+		username = email
+	}
+	if username == "" {
+		return models.User{}, fmt.Errorf("id token has no %q or email claim", p.cfg.UsernameClaim)
+	}
+	return Provision(ctx, p.db, ExternalUser{
+		Source:        SourceOIDC,
+		Username:      username,
+		Email:         email,
+		Issuer:        issuer,
+		Subject:       subject,
+		Role:          p.role(claims),
+		SyncRole:      p.cfg.SyncRole,
+		InstitutionID: p.cfg.InstitutionID,
+	})
+}
+
+func (p *OIDC) exchange(ctx context.Context, code, verifier string) (string, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.cfg.RedirectURL},
+		"client_id":     {p.cfg.ClientID},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.cfg.ClientSecret != "" {
+		// client_secret_basic: облікові дані кодуються як у формі (RFC 6749, 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(p.cfg.ClientID), url.QueryEscape(p.cfg.ClientSecret))
+	}
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("oidc token request: %w", err)
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&tokens); err != nil {
+		return "", fmt.Errorf("oidc token response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || tokens.Error != "" {
+		return "", fmt.Errorf("oidc token request rejected (status %d): %s %s", resp.StatusCode, tokens.Error, tokens.ErrorDescription)
+	}
+	if tokens.IDToken == "" {
+		return "", errors.New("oidc token response has no id_token")
+	}
+	return tokens.IDToken, nil
+}
+
+// VerifyIDToken перевіряє підпис RS256 за JWKS, issuer, audience, строк дії та nonce
+func (p *OIDC) VerifyIDToken(ctx context.Context, raw, nonce string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	parser := &jwt.Parser{ValidMethods: []string{"RS256"}, SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(raw, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("id token signature: %w", err)
+	}
+
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if iss, _ := claims["iss"].(string); iss != d.Issuer {
+		return nil, fmt.Errorf("id token issuer %q does not match %q", iss, d.Issuer)
+	}
+	if !audienceContains(claims["aud"], p.cfg.ClientID) {
+		return nil, errors.New("id token audience does not include client id")
+	}
+	if azp, ok := claims["azp"].(string); ok && azp != p.cfg.ClientID {
+		return nil, errors.New("id token authorized party does not match client id")
+	}
+	now := time.Now()
+	exp, ok := claims["exp"].(float64)
+	if !ok || now.After(time.Unix(int64(exp), 0).Add(p.cfg.ClockSkew)) {
+		return nil, errors.New("id token expired")
+	}
+	if iat, ok := claims["iat"].(float64); ok && now.Add(p.cfg.ClockSkew).Before(time.Unix(int64(iat), 0)) {
+		return nil, errors.New("id token issued in the future")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && now.Add(p.cfg.ClockSkew).Before(time.Unix(int64(nbf), 0)) {
+		return nil, errors.New("id token not valid yet")
+	}
+	if got, _ := claims["nonce"].(string); got == "" || got != nonce {
+		return nil, errors.New("id token nonce mismatch")
+	}
+	return claims, nil
+}
+
+func audienceContains(aud interface{}, clientID string) bool {
+	switch v := aud.(type) {
+	case string:
+		return v == clientID
+	case []interface{}:
+		for _, a := range v {
+			if s, ok := a.(string); ok && s == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func validRole(role string) bool {
+	for _, r := range models.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// role обирає найвищу роль серед значень RoleClaim, знайдених у RoleMap.
+// Ролі поза models.Roles (наприклад, superadmin) не видаються, навіть якщо
+// RoleMap задано напряму, а не через OIDC_ROLE_MAP.
+func (p *OIDC) role(claims jwt.MapClaims) string {
+	if p.cfg.RoleClaim == "" {
+		return ""
+	}
+	var values []string
+	switch v := claims[p.cfg.RoleClaim].(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	role := ""
+	for _, value := range values {
+		mapped, ok := p.cfg.RoleMap[value]
+		if !ok || !validRole(mapped) {
+			continue
+		}
+		if mapped == models.RoleAdmin {
+			return models.RoleAdmin
+		}
+		role = mapped
+	}
+	return role
+}
+
+func claimString(claims jwt.MapClaims, name string) string {
+	if name == "" {
+		return ""
+	}
+	s, _ := claims[name].(string)
+	return strings.TrimSpace(s)
+}
+
+// discover завантажує /.well-known/openid-configuration (один раз, повторює після невдачі)
+func (p *OIDC) discover(ctx context.Context) (*oidcDiscovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+	var d oidcDiscovery
+	if err := p.getJSON(ctx, p.cfg.Issuer+"/.well-known/openid-configuration", &d); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if strings.TrimRight(d.Issuer, "/") != p.cfg.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match configured %q", d.Issuer, p.cfg.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc discovery: missing endpoints")
+	}
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// key повертає відкритий ключ за kid; невідомий kid оновлює JWKS (не частіше разу на хвилину)
+func (p *OIDC) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	d, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < time.Minute {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := p.getJSON(ctx, d.JWKSURI, &jwks); err != nil {
+		return nil, fmt.Errorf("oidc jwks: %w", err)
+	}
+	p.keys = map[string]*rsa.PublicKey{}
+	p.keysFetched = time.Now()
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") {
+			continue
+		}
+		n, errN := base64.RawURLEncoding.DecodeString(k.N)
+		e, errE := base64.RawURLEncoding.DecodeString(k.E)
+		if errN != nil || errE != nil || len(e) > 4 {
+			log.Printf("Skipping malformed JWKS key %q", k.Kid)
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if key := p.lookupKey(kid); key != nil {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+// lookupKey шукає ключ за kid; без kid підходить лише єдиний ключ набору
+func (p *OIDC) lookupKey(kid string) *rsa.PublicKey {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key
+		}
+	}
+	return p.keys[kid]
+}
+
+func (p *OIDC) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(v)
+}
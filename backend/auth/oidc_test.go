@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"study_grade/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	testClientID = "study-grade"
+	testCode     = "auth-code"
+	testVerifier = "verifier-0123456789-0123456789-0123456789"
+	testNonce    = "nonce-123"
+)
+
+// mockProvider - провайдер OpenID Connect у пам'яті: discovery, JWKS і token endpoint
+type mockProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	// challenge - code_challenge, отриманий на сторінці авторизації
+	challenge string
+	// claims повертає claims ID-токена; signer - ключ підпису (за замовчуванням key)
+	claims func() jwt.MapClaims
+	signer *rsa.PrivateKey
+}
+
+func newMockProvider(t *testing.T) *mockProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockProvider{t: t, key: key, challenge: PKCEChallenge(testVerifier)}
+	m.claims = m.validClaims
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		if r.PostForm.Get("code") != testCode || r.PostForm.Get("client_id") != testClientID {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		// Провайдер перевіряє code_verifier за code_challenge (RFC 7636, 4.6)
+		if PKCEChallenge(r.PostForm.Get("code_verifier")) != m.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": m.sign(m.claims())})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+func (m *mockProvider) validClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":                m.server.URL,
+		"sub":                "subject-42",
+		"aud":                testClientID,
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"iat":                now.Unix(),
+		"nonce":              testNonce,
+		"preferred_username": "olena",
+		"email":              "olena@example.org",
+		"email_verified":     true,
+		"groups":             []string{"staff"},
+	}
+}
+
+func (m *mockProvider) sign(claims jwt.MapClaims) string {
+	signer := m.signer
+	if signer == nil {
+		signer = m.key
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = "k1"
+	raw, err := token.SignedString(signer)
+	if err != nil {
+		m.t.Fatal(err)
+	}
+	return raw
+}
+
+func (m *mockProvider) oidc(db *sql.DB) *OIDC {
+	p := NewOIDC(db, OIDCConfig{
+		Issuer:        m.server.URL,
+		ClientID:      testClientID,
+		RedirectURL:   "http://localhost:8080/api/oidc/callback",
+		Scopes:        []string{"openid"},
+		UsernameClaim: "preferred_username",
+		EmailClaim:    "email",
+		RoleClaim:     "groups",
+		RoleMap:       map[string]string{"staff": models.RoleTeacher, "sg-admins": models.RoleAdmin, "root": models.RoleSuperadmin},
+		ClockSkew:     time.Second,
+		InstitutionID: 1,
+	})
+	p.Client = m.server.Client()
+	return p
+}
+
+func TestOIDCLoginProvisionsByIssuerAndSubject(t *testing.T) {
+	m := newMockProvider(t)
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("SELECT user_id FROM user_identities WHERE issuer = \\? AND subject = \\?").
+		WithArgs(m.server.URL, "subject-42").WillReturnRows(sqlmock.NewRows([]string{"user_id"}))
+	// Локальний запис з тим самим ім'ям не переходить до ідентичності провайдера
+	mock.ExpectQuery("SELECT .+ FROM users WHERE username = \\?").WithArgs("olena").
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(3, "olena", "$2a$10$hash", "olena@example.org", models.RoleAdmin, false, 0, false, false, SourceLocal, 1))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE username = \\?\\)").WithArgs("olena").
+		WillReturnRows(sqlmock.NewRows([]string{"taken"}).AddRow(true))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE username = \\?\\)").WithArgs("olena-2").
+		WillReturnRows(sqlmock.NewRows([]string{"taken"}).AddRow(false))
+	mock.ExpectQuery("SELECT EXISTS\\(SELECT 1 FROM users WHERE email = \\?\\)").WithArgs("olena@example.org").
+		WillReturnRows(sqlmock.NewRows([]string{"taken"}).AddRow(true))
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users").
+		WithArgs("olena-2", noPassword, sql.NullString{}, models.RoleTeacher, SourceOIDC, 1).
+		WillReturnResult(sqlmock.NewResult(21, 1))
+	mock.ExpectExec("INSERT INTO user_identities").WithArgs(21, m.server.URL, "subject-42").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT .+ FROM users WHERE id = \\?").WithArgs(21).
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(21, "olena-2", noPassword, nil, models.RoleTeacher, false, 0, false, false, SourceOIDC, 1))
+
+	user, err := m.oidc(db).Login(context.Background(), testCode, testVerifier, testNonce)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if user.ID != 21 || user.Username != "olena-2" {
+		t.Errorf("got user %+v, want new user 21", user)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCLoginMatchesExistingIdentity(t *testing.T) {
+	m := newMockProvider(t)
+	m.claims = func() jwt.MapClaims {
+		c := m.validClaims()
+		// Ім'я у провайдера змінилося, а sub - ні
+		c["preferred_username"] = "olena.renamed"
+		c["groups"] = []string{"root", "staff"}
+		return c
+	}
+	db, mock := newMockDB(t)
+	mock.ExpectQuery("SELECT user_id FROM user_identities WHERE issuer = \\? AND subject = \\?").
+		WithArgs(m.server.URL, "subject-42").WillReturnRows(sqlmock.NewRows([]string{"user_id"}).AddRow(21))
+	mock.ExpectQuery("SELECT .+ FROM users WHERE id = \\?").WithArgs(21).
+		WillReturnRows(sqlmock.NewRows(userRowColumns).AddRow(21, "olena-2", noPassword, "olena@example.org", models.RoleTeacher, false, 0, false, false, SourceOIDC, 1))
+
+	p := m.oidc(db)
+	p.cfg.SyncRole = true
+	user, err := p.Login(context.Background(), testCode, testVerifier, testNonce)
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	// Значення з RoleMap поза models.Roles не видається
+	if user.ID != 21 || user.Role != models.RoleTeacher {
+		t.Errorf("got user %+v, want existing teacher 21", user)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCLoginRejectsInvalidTokens(t *testing.T) {
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		modify   func(m *mockProvider, c jwt.MapClaims)
+		verifier string
+		nonce    string
+		want     string
+	}{
+		{name: "bad signature", modify: func(m *mockProvider, c jwt.MapClaims) { m.signer = otherKey }, want: "signature"},
+		{name: "wrong audience", modify: func(m *mockProvider, c jwt.MapClaims) { c["aud"] = "another-client" }, want: "audience"},
+		{name: "wrong issuer", modify: func(m *mockProvider, c jwt.MapClaims) { c["iss"] = "https://evil.example.org" }, want: "issuer"},
+		{name: "nonce mismatch", nonce: "other-nonce", want: "nonce"},
+		{name: "expired", modify: func(m *mockProvider, c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, want: "expired"},
+		{name: "PKCE verifier mismatch", verifier: "forged-verifier-0123456789-0123456789", want: "PKCE"},
+		{name: "missing subject", modify: func(m *mockProvider, c jwt.MapClaims) { delete(c, "sub") }, want: "sub"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newMockProvider(t)
+			m.claims = func() jwt.MapClaims {
+				c := m.validClaims()
+				if tt.modify != nil {
+					tt.modify(m, c)
+				}
+				return c
+			}
+			verifier, nonce := testVerifier, testNonce
+			if tt.verifier != "" {
+				verifier = tt.verifier
+			}
+			if tt.nonce != "" {
+				nonce = tt.nonce
+			}
+			db, mock := newMockDB(t)
+
+			_, err := m.oidc(db).Login(context.Background(), testCode, verifier, nonce)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("got %v, want error mentioning %q", err, tt.want)
+			}
+			// Відхилений токен не доходить до бази
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"study_grade/models"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// noPassword зберігається замість хешу для зовнішніх користувачів: bcrypt його ніколи не прийме
+const noPassword = "!"
+
+// maxUsernameLength - розмір колонки users.username
+const maxUsernameLength = 50
+
+// ExternalUser - користувач, підтверджений зовнішнім джерелом (каталогом, провайдером ідентичності)
+type ExternalUser struct {
+	Source   string
+	Username string
+	Email    string
+	// Issuer/Subject - незмінний ідентифікатор користувача у провайдера (OIDC iss+sub).
+	// Якщо задано, локальний запис шукається за ним, а Username - лише бажане ім'я нового запису.
+	Issuer  string
+	Subject string
+	// Role - роль із групи каталогу; порожня - роль за замовчуванням (teacher)
+	Role string
+	// SyncRole перезаписує роль існуючого користувача при кожному вході
+	SyncRole bool
+	// InstitutionID - установа, до якої потрапляє новий користувач
+	InstitutionID int
+}
+
+// Provision повертає локальний запис для зовнішнього користувача, створюючи його при першому вході.
+// Локальний обліковий запис з тим самим ім'ям не переходить під зовнішнє джерело автоматично.
+func Provision(ctx context.Context, db *sql.DB, ext ExternalUser) (models.User, error) {
+	if ext.Subject != "" {
+		return provisionIdentity(ctx, db, ext)
+	}
+	if len(ext.Username) == 0 || len(ext.Username) > maxUsernameLength {
+		return models.User{}, fmt.Errorf("external username %q must be 1-50 characters", ext.Username)
+	}
+	role := ext.Role
+	if role == "" {
+		role = models.RoleTeacher
+	}
+	email := sql.NullString{String: ext.Email, Valid: ext.Email != ""}
+
+	user, _, err := loadUser(ctx, db, ext.Username)
+	if err == sql.ErrNoRows {
+		_, err = db.ExecContext(ctx,
+			"INSERT INTO users (username, password, email, role, auth_source, institution_id) VALUES (?, ?, ?, ?, ?, ?)",
+			ext.Username, noPassword, email, role, ext.Source, ext.InstitutionID)
+		if isDuplicateKey(err) {
+			// Паралельний перший вхід уже створив запис, або адреса зайнята іншим користувачем
+			if user, _, err = loadUser(ctx, db, ext.Username); err == sql.ErrNoRows {
+				log.Println("Email of", ext.Source, "user", ext.Username, "belongs to another account, provisioning without it")
+				_, err = db.ExecContext(ctx,
+					"INSERT INTO users (username, password, role, auth_source, institution_id) VALUES (?, ?, ?, ?, ?)",
+					ext.Username, noPassword, role, ext.Source, ext.InstitutionID)
+			}
+		}
+		if err != nil {
+			return models.User{}, err
+		}
+		log.Println("Provisioned", ext.Source, "user:", ext.Username, "with role", role)
+		user, _, err = loadUser(ctx, db, ext.Username)
+		if err != nil {
+			return models.User{}, err
+		}
+	} else if err != nil {
+		return models.User{}, err
+	}
+
+	if user.AuthSource != ext.Source {
+		log.Println("Refusing", ext.Source, "login for user", user.ID, "managed by", user.AuthSource, "backend")
+		return models.User{}, ErrInvalidCredentials
+	}
+	return syncExternalUser(ctx, db, user, ext)
+}
+
+// provisionIdentity знаходить або створює локальний запис за iss+sub. Ім'я користувача
+// провайдера не дає доступу до чужого запису: якщо воно зайняте, новий запис отримує
+// вільне ім'я з числовим суфіксом.
+func provisionIdentity(ctx context.Context, db *sql.DB, ext ExternalUser) (models.User, error) {
+	var userID int
+	err := db.QueryRowContext(ctx, "SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ?", ext.Issuer, ext.Subject).Scan(&userID)
+	if err == nil {
+		user, err := loadUserByID(ctx, db, userID)
+		if err != nil {
+			return models.User{}, err
+		}
+		return syncExternalUser(ctx, db, user, ext)
+	}
+	if err != sql.ErrNoRows {
+		return models.User{}, err
+	}
+
+	// Запис, створений до прив'язки за iss+sub, переходить до ідентичності лише за
+	// підтвердженою провайдером адресою, яка збігається з адресою запису
+	if user, _, err := loadUser(ctx, db, ext.Username); err == nil && user.AuthSource == ext.Source &&
+		ext.Email != "" && strings.EqualFold(user.Email, ext.Email) {
+		var linked bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM user_identities WHERE user_id = ?)", user.ID).Scan(&linked); err != nil {
+			return models.User{}, err
+		}
+		if !linked {
+			if err := linkIdentity(ctx, db, user.ID, ext); err != nil {
+				return models.User{}, err
+			}
+			log.Println("Linked existing", ext.Source, "user", user.ID, "to its provider identity")
+			return syncExternalUser(ctx, db, user, ext)
+		}
+	} else if err != nil && err != sql.ErrNoRows {
+		return models.User{}, err
+	}
+
+	username, err := freeUsername(ctx, db, ext.Username)
+	if err != nil {
+		return models.User{}, err
+	}
+	role := ext.Role
+	if role == "" {
+		role = models.RoleTeacher
+	}
+	email := sql.NullString{String: ext.Email, Valid: ext.Email != ""}
+	if email.Valid {
+		var taken bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", ext.Email).Scan(&taken); err != nil {
+			return models.User{}, err
+		}
+		if taken {
+			log.Println("Email of", ext.Source, "user", username, "belongs to another account, provisioning without it")
+			email = sql.NullString{}
+		}
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO users (username, password, email, role, auth_source, institution_id) VALUES (?, ?, ?, ?, ?, ?)",
+		username, noPassword, email, role, ext.Source, ext.InstitutionID)
+	if err != nil {
+		return models.User{}, err
+	}
+	id, _ := result.LastInsertId()
+	if err := linkIdentity(ctx, tx, int(id), ext); err != nil {
+		return models.User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return models.User{}, err
+	}
+	log.Println("Provisioned", ext.Source, "user:", username, "with role", role)
+	return loadUserByID(ctx, db, int(id))
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func linkIdentity(ctx context.Context, ex execer, userID int, ext ExternalUser) error {
+	_, err := ex.ExecContext(ctx, "INSERT INTO user_identities (user_id, issuer, subject) VALUES (?, ?, ?)", userID, ext.Issuer, ext.Subject)
+	return err
+}
+
+// freeUsername повертає бажане ім'я або перше вільне з суфіксом -2, -3, ...
+func freeUsername(ctx context.Context, db *sql.DB, preferred string) (string, error) {
+	if preferred == "" {
+		preferred = "user"
+	}
+	for n := 1; n <= 100; n++ {
+		candidate := preferred
+		if n > 1 {
+			suffix := "-" + strconv.Itoa(n)
+			candidate = truncate(preferred, maxUsernameLength-len(suffix)) + suffix
+		} else {
+			candidate = truncate(candidate, maxUsernameLength)
+		}
+		var taken bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", candidate).Scan(&taken); err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no free username for %q", preferred)
+}
+
+// truncate обрізає рядок до n байтів, не розриваючи символ UTF-8
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+func utf8RuneStart(b byte) bool { return b&0xC0 != 0x80 }
+
+// syncExternalUser тримає пошту і (за потреби) роль в актуальному стані з джерела
+func syncExternalUser(ctx context.Context, db *sql.DB, user models.User, ext ExternalUser) (models.User, error) {
+	role := ext.Role
+	if role == "" {
+		role = models.RoleTeacher
+	}
+	email := sql.NullString{String: ext.Email, Valid: ext.Email != ""}
+	if (ext.Email != "" && ext.Email != user.Email) || (ext.SyncRole && role != user.Role) {
+		if !ext.SyncRole {
+			role = user.Role
+		}
+		if ext.Email == "" {
+			email = sql.NullString{String: user.Email, Valid: user.Email != ""}
+		}
+		_, err := db.ExecContext(ctx, "UPDATE users SET email = ?, role = ? WHERE id = ?", email, role, user.ID)
+		if isDuplicateKey(err) {
+			log.Println("Email of", ext.Source, "user", user.ID, "belongs to another account, keeping the old one")
+			email = sql.NullString{String: user.Email, Valid: user.Email != ""}
+			_, err = db.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ?", role, user.ID)
+		}
+		if err != nil {
+			return models.User{}, err
+		}
+		if role != user.Role {
+			log.Println("Role of", ext.Source, "user", user.ID, "synced from", user.Role, "to", role)
+		}
+		user.Email, user.Role = email.String, role
+	}
+	return user, nil
+}
+
+func isDuplicateKey(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1062
+}
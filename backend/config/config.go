@@ -0,0 +1,91 @@
+package config
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Допоміжні функції для читання налаштувань зі змінних оточення (.env завантажує db.InitDB).
+// Некоректні значення логуються, і використовується значення за замовчуванням.
+
+// String повертає значення змінної або def, якщо вона не задана
+func String(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
+
+// Int повертає ціле значення змінної або def
+func Int(key string, def int) int {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid integer value for %s=%q, using default %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// Float повертає дійсне значення змінної або def
+func Float(key string, def float64) float64 {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		log.Printf("Invalid float value for %s=%q, using default %v", key, v, def)
+		return def
+	}
+	return f
+}
+
+// Bool повертає логічне значення змінної або def
+func Bool(key string, def bool) bool {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("Invalid boolean value for %s=%q, using default %v", key, v, def)
+		return def
+	}
+	return b
+}
+
+// Duration повертає тривалість (формат time.ParseDuration, наприклад "15s") або def
+func Duration(key string, def time.Duration) time.Duration {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("Invalid duration value for %s=%q, using default %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// List повертає список значень, розділених комами, без порожніх елементів
+func List(key string, def []string) []string {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def
+	}
+	var out []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
@@ -0,0 +1,63 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"study_grade/config"
+	"time"
+)
+
+// AcademicYearStart повертає місяць і день початку навчального року з ACADEMIC_YEAR_START
+// у форматі MM-DD (за замовчуванням 09-01). Використовується, якщо установа не задала
+// навчальний рік явно в таблиці academic_years.
+func AcademicYearStart() (time.Month, int) {
+	v := config.String("ACADEMIC_YEAR_START", "09-01")
+	t, err := time.Parse("01-02", v)
+	if err != nil {
+		log.Printf("Invalid ACADEMIC_YEAR_START=%q, using 09-01", v)
+		return time.September, 1
+	}
+	return t.Month(), t.Day()
+}
+
+// AcademicYearName повертає назву навчального року, що починається у startYear, наприклад 2025/2026
+func AcademicYearName(startYear int) string {
+	return fmt.Sprintf("%d/%d", startYear, startYear+1)
+}
+
+// DefaultAcademicYear визначає навчальний рік дати за ACADEMIC_YEAR_START
+func DefaultAcademicYear(date time.Time) string {
+	month, day := AcademicYearStart()
+	year := date.Year()
+	if date.Before(time.Date(year, month, day, 0, 0, 0, 0, date.Location())) {
+		year--
+	}
+	return AcademicYearName(year)
+}
+
+// backfillGradesAcademicYear заповнює grades.academic_year для записів, створених до появи
+// навчальних років: за явно заданими роками установи, а для решти - за ACADEMIC_YEAR_START
+func backfillGradesAcademicYear(ctx context.Context) error {
+	if _, err := DB.ExecContext(ctx, `
+		UPDATE grades g JOIN academic_years y
+			ON y.institution_id = g.institution_id AND g.date BETWEEN y.start_date AND y.end_date
+		SET g.academic_year = y.name
+		WHERE g.academic_year = ''`); err != nil {
+		return fmt.Errorf("failed to backfill grades.academic_year: %w", err)
+	}
+	month, day := AcademicYearStart()
+	result, err := DB.ExecContext(ctx, `
+		UPDATE grades SET academic_year = CONCAT(
+			YEAR(date) - IF(DATE_FORMAT(date, '%m-%d') < ?, 1, 0), '/',
+			YEAR(date) - IF(DATE_FORMAT(date, '%m-%d') < ?, 1, 0) + 1)
+		WHERE academic_year = ''`,
+		fmt.Sprintf("%02d-%02d", month, day), fmt.Sprintf("%02d-%02d", month, day))
+	if err != nil {
+		return fmt.Errorf("failed to backfill grades.academic_year: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Backfilled academic year for %d grade records", n)
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"os"
+	"study_grade/config"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/joho/godotenv"
@@ -12,6 +15,15 @@ import (
 
 var DB *sql.DB
 
+// QueryTimeout - максимальний час виконання одного запиту до бази (DB_QUERY_TIMEOUT)
+var QueryTimeout = 5 * time.Second
+
+// WithTimeout повертає контекст запиту, обмежений QueryTimeout.
+// Якщо клієнт від'єднався, запит до бази також скасовується.
+func WithTimeout(parent context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(parent, QueryTimeout)
+}
+
 func InitDB() {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
@@ -38,45 +50,59 @@ func InitDB() {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
+	// Налаштування пулу з'єднань
+	DB.SetMaxOpenConns(config.Int("DB_MAX_OPEN_CONNS", 25))
+	DB.SetMaxIdleConns(config.Int("DB_MAX_IDLE_CONNS", 10))
+	DB.SetConnMaxLifetime(config.Duration("DB_CONN_MAX_LIFETIME", 5*time.Minute))
+	DB.SetConnMaxIdleTime(config.Duration("DB_CONN_MAX_IDLE_TIME", 2*time.Minute))
+	QueryTimeout = config.Duration("DB_QUERY_TIMEOUT", QueryTimeout)
+	log.Printf("Database pool configured: max open %d, query timeout %s", DB.Stats().MaxOpenConnections, QueryTimeout)
+
 	// Test the connection
-	if err = DB.Ping(); err != nil {
+	ctx, cancel := context.WithTimeout(context.Background(), config.Duration("DB_CONNECT_TIMEOUT", 10*time.Second))
+	defer cancel()
+	if err = DB.PingContext(ctx); err != nil {
 		log.Fatal("Database ping failed:", err)
 	}
 
-	// Create users table
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS users (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			username VARCHAR(50) UNIQUE NOT NULL,
-			password VARCHAR(255) NOT NULL
-		)
-	`)
-	if err != nil {
-		log.Fatal("Failed to create users table:", err)
+	// Створення та оновлення схеми
+	migrateCtx, cancelMigrate := context.WithTimeout(context.Background(), config.Duration("DB_MIGRATE_TIMEOUT", time.Minute))
+	defer cancelMigrate()
+	if err = migrate(migrateCtx); err != nil {
+		log.Fatal("Database migration failed:", err)
 	}
 
-	// Create grades table
-	_, err = DB.Exec(`
-		CREATE TABLE IF NOT EXISTS grades (
-			id INT AUTO_INCREMENT PRIMARY KEY,
-			date DATE NOT NULL,
-			semester INT NOT NULL,
-			subject VARCHAR(100) NOT NULL,
-			group_name VARCHAR(50) NOT NULL,
-			total_students INT NOT NULL,
-			grade_5 INT NOT NULL,
-			grade_4 INT NOT NULL,
-			grade_3 INT NOT NULL,
-			grade_2 INT NOT NULL,
-			not_passed INT NOT NULL,
-			average_score FLOAT NOT NULL,
-			success_rate FLOAT NOT NULL,
-			quality_rate FLOAT NOT NULL,
-			user_id INT NOT NULL,
-			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-		)
-	`)
-	if err != nil {
-		log.Fatal("Failed to create grades table:", err)
+	// Призначення першого адміністратора (BOOTSTRAP_ADMIN=<username>)
+	if admin := config.String("BOOTSTRAP_ADMIN", ""); admin != "" {
+		result, err := DB.ExecContext(migrateCtx, "UPDATE users SET role = 'admin' WHERE username = ? AND role <> 'admin'", admin)
+		if err != nil {
+			log.Fatal("Failed to bootstrap admin user:", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Println("Granted admin role to bootstrap user:", admin)
+		}
+	}
+
+	// Адміністратор платформи, що створює установи (BOOTSTRAP_SUPERADMIN=<username>)
+	if superadmin := config.String("BOOTSTRAP_SUPERADMIN", ""); superadmin != "" {
+		result, err := DB.ExecContext(migrateCtx, "UPDATE users SET role = 'superadmin' WHERE username = ? AND role <> 'superadmin'", superadmin)
+		if err != nil {
+			log.Fatal("Failed to bootstrap superadmin user:", err)
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			log.Println("Granted superadmin role to bootstrap user:", superadmin)
+		}
+	}
+}
+
+// Close закриває пул з'єднань з базою даних
+func Close() {
+	if DB == nil {
+		return
+	}
+	if err := DB.Close(); err != nil {
+		log.Println("Failed to close database:", err)
+		return
 	}
+	log.Println("Database connection pool closed")
 }
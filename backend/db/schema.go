@@ -0,0 +1,555 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// tables - таблиці у порядку створення (таблиці з зовнішніми ключами йдуть після тих, на які посилаються)
+var tables = []struct {
+	name string
+	ddl  string
+}{
+	{"institutions", `
+		CREATE TABLE IF NOT EXISTS institutions (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			name VARCHAR(150) NOT NULL UNIQUE,
+			slug VARCHAR(50) NOT NULL UNIQUE,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`},
+	{"users", `
+		CREATE TABLE IF NOT EXISTS users (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			username VARCHAR(50) UNIQUE NOT NULL,
+			password VARCHAR(255) NOT NULL
+		)
+	`},
+	{"grades", `
+		CREATE TABLE IF NOT EXISTS grades (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			date DATE NOT NULL,
+			semester INT NOT NULL,
+			subject VARCHAR(100) NOT NULL,
+			group_name VARCHAR(50) NOT NULL,
+			total_students INT NOT NULL,
+			grade_5 INT NOT NULL,
+			grade_4 INT NOT NULL,
+			grade_3 INT NOT NULL,
+			grade_2 INT NOT NULL,
+			not_passed INT NOT NULL,
+			average_score FLOAT NOT NULL,
+			success_rate FLOAT NOT NULL,
+			quality_rate FLOAT NOT NULL,
+			user_id INT NULL,
+			CONSTRAINT fk_grades_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE RESTRICT
+		)
+	`},
+	{"rate_limits", `
+		CREATE TABLE IF NOT EXISTS rate_limits (
+			bucket_key VARCHAR(191) PRIMARY KEY,
+			hits INT NOT NULL DEFAULT 0,
+			window_start DATETIME(6) NULL,
+			failures INT NOT NULL DEFAULT 0,
+			last_failure DATETIME(6) NULL,
+			blocked_until DATETIME(6) NULL,
+			updated_at DATETIME(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+			INDEX idx_rate_limits_updated_at (updated_at)
+		)
+	`},
+	{"user_recovery_codes", `
+		CREATE TABLE IF NOT EXISTS user_recovery_codes (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			code_hash CHAR(64) NOT NULL,
+			used_at DATETIME NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_recovery_code (user_id, code_hash),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`},
+	{"password_reset_tokens", `
+		CREATE TABLE IF NOT EXISTS password_reset_tokens (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			expires_at DATETIME NOT NULL,
+			used_at DATETIME NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`},
+	{"faculties", `
+		CREATE TABLE IF NOT EXISTS faculties (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			institution_id INT NOT NULL,
+			name VARCHAR(150) NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_faculties_name (institution_id, name),
+			FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE RESTRICT
+		)
+	`},
+	{"departments", `
+		CREATE TABLE IF NOT EXISTS departments (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			institution_id INT NOT NULL DEFAULT 1,
+			name VARCHAR(150) NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_departments_name (institution_id, name)
+		)
+	`},
+	{"study_groups", `
+		CREATE TABLE IF NOT EXISTS study_groups (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			institution_id INT NOT NULL,
+			name VARCHAR(50) NOT NULL,
+			department_id INT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_study_groups_name (institution_id, name),
+			FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE RESTRICT,
+			FOREIGN KEY (department_id) REFERENCES departments(id) ON DELETE SET NULL
+		)
+	`},
+	{"invitations", `
+		CREATE TABLE IF NOT EXISTS invitations (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			code_hash CHAR(64) NOT NULL UNIQUE,
+			role VARCHAR(20) NOT NULL,
+			department_id INT NULL,
+			expires_at DATETIME NOT NULL,
+			created_by INT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			used_by INT NULL,
+			used_at DATETIME NULL,
+			revoked_at DATETIME NULL,
+			FOREIGN KEY (department_id) REFERENCES departments(id) ON DELETE SET NULL,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+			FOREIGN KEY (used_by) REFERENCES users(id) ON DELETE SET NULL
+		)
+	`},
+	{"settings", `
+		CREATE TABLE IF NOT EXISTS settings (
+			institution_id INT NOT NULL DEFAULT 1,
+			name VARCHAR(100) NOT NULL,
+			value TEXT NOT NULL,
+			updated_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+			PRIMARY KEY (institution_id, name)
+		)
+	`},
+	{"api_tokens", `
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			token_hash CHAR(64) NOT NULL UNIQUE,
+			prefix VARCHAR(16) NOT NULL,
+			scope VARCHAR(20) NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NULL,
+			last_used_at DATETIME NULL,
+			revoked_at DATETIME NULL,
+			INDEX idx_api_tokens_user (user_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`},
+	// Зовнішні ідентичності: користувач провайдера OIDC визначається незмінною парою iss+sub,
+	// а не preferred_username, який можна змінити і який не унікальний між провайдерами
+	{"user_identities", `
+		CREATE TABLE IF NOT EXISTS user_identities (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			user_id INT NOT NULL,
+			issuer VARCHAR(255) NOT NULL,
+			subject VARCHAR(255) NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_user_identities_subject (issuer, subject),
+			INDEX idx_user_identities_user (user_id),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`},
+	// Використані токени обміну SSO (jti): кожен обмінюється на сесію лише один раз
+	{"sso_token_uses", `
+		CREATE TABLE IF NOT EXISTS sso_token_uses (
+			jti CHAR(43) PRIMARY KEY,
+			expires_at DATETIME NOT NULL,
+			INDEX idx_sso_token_uses_expires (expires_at)
+		)
+	`},
+	{"academic_years", `
+		CREATE TABLE IF NOT EXISTS academic_years (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			institution_id INT NOT NULL,
+			name VARCHAR(9) NOT NULL,
+			start_date DATE NOT NULL,
+			end_date DATE NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_academic_years_name (institution_id, name),
+			FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE CASCADE
+		)
+	`},
+	{"exams", `
+		CREATE TABLE IF NOT EXISTS exams (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			institution_id INT NOT NULL,
+			subject VARCHAR(100) NOT NULL,
+			group_name VARCHAR(50) NOT NULL,
+			starts_at DATETIME NOT NULL,
+			duration_minutes INT NOT NULL,
+			room VARCHAR(50) NOT NULL DEFAULT '',
+			examiner_id INT NULL,
+			created_by INT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_exams_starts_at (institution_id, starts_at),
+			FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE RESTRICT,
+			FOREIGN KEY (examiner_id) REFERENCES users(id) ON DELETE SET NULL,
+			FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+		)
+	`},
+	{"idempotency_keys", `
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+			user_id INT NOT NULL,
+			idempotency_key VARCHAR(255) NOT NULL,
+			request_hash CHAR(64) NOT NULL,
+			status_code INT NULL,
+			content_type VARCHAR(100) NOT NULL DEFAULT '',
+			etag VARCHAR(100) NOT NULL DEFAULT '',
+			location VARCHAR(1000) NOT NULL DEFAULT '',
+			response_body MEDIUMBLOB NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			expires_at DATETIME NOT NULL,
+			PRIMARY KEY (user_id, idempotency_key),
+			INDEX idx_idempotency_keys_expires (expires_at),
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)
+	`},
+	{"grade_revisions", `
+		CREATE TABLE IF NOT EXISTS grade_revisions (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			grade_id INT NOT NULL,
+			institution_id INT NOT NULL,
+			version INT NOT NULL,
+			action VARCHAR(20) NOT NULL,
+			restored_from INT NULL,
+			data TEXT NOT NULL,
+			changed_by INT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE KEY uq_grade_revisions_version (grade_id, version),
+			FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE RESTRICT,
+			FOREIGN KEY (changed_by) REFERENCES users(id) ON DELETE SET NULL
+		)
+	`},
+	{"grade_thresholds", `
+		CREATE TABLE IF NOT EXISTS grade_thresholds (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			institution_id INT NOT NULL,
+			department_id INT NULL,
+			subject VARCHAR(100) NULL,
+			metric VARCHAR(20) NOT NULL,
+			min_value FLOAT NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			INDEX idx_grade_thresholds_institution (institution_id),
+			FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE CASCADE,
+			FOREIGN KEY (department_id) REFERENCES departments(id) ON DELETE CASCADE
+		)
+	`},
+	{"grade_alerts", `
+		CREATE TABLE IF NOT EXISTS grade_alerts (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			grade_id INT NOT NULL,
+			institution_id INT NOT NULL,
+			threshold_id INT NULL,
+			metric VARCHAR(20) NOT NULL,
+			target FLOAT NOT NULL,
+			value FLOAT NOT NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			acknowledged_by INT NULL,
+			acknowledged_at DATETIME NULL,
+			INDEX idx_grade_alerts_institution (institution_id, acknowledged_at),
+			FOREIGN KEY (grade_id) REFERENCES grades(id) ON DELETE CASCADE,
+			FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE RESTRICT,
+			FOREIGN KEY (threshold_id) REFERENCES grade_thresholds(id) ON DELETE SET NULL,
+			FOREIGN KEY (acknowledged_by) REFERENCES users(id) ON DELETE SET NULL
+		)
+	`},
+	{"grade_anomalies", `
+		CREATE TABLE IF NOT EXISTS grade_anomalies (
+			id INT AUTO_INCREMENT PRIMARY KEY,
+			grade_id INT NOT NULL UNIQUE,
+			institution_id INT NOT NULL,
+			reasons TEXT NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			note VARCHAR(500) NULL,
+			created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			reviewed_by INT NULL,
+			reviewed_at DATETIME NULL,
+			INDEX idx_grade_anomalies_status (institution_id, status),
+			FOREIGN KEY (grade_id) REFERENCES grades(id) ON DELETE CASCADE,
+			FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE RESTRICT,
+			FOREIGN KEY (reviewed_by) REFERENCES users(id) ON DELETE SET NULL
+		)
+	`},
+}
+
+// columns - колонки, додані до вже існуючих таблиць.
+// MySQL не підтримує ADD COLUMN IF NOT EXISTS, тому наявність перевіряється через information_schema.
+var columns = []struct {
+	table      string
+	column     string
+	definition string
+}{
+	{"users", "role", "VARCHAR(20) NOT NULL DEFAULT 'teacher'"},
+	{"users", "totp_secret", "VARCHAR(64) NULL"},
+	{"users", "totp_enabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"users", "totp_last_step", "BIGINT NOT NULL DEFAULT 0"},
+	{"users", "email", "VARCHAR(255) NULL"},
+	{"users", "token_version", "INT NOT NULL DEFAULT 0"},
+	{"users", "disabled", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"users", "must_change_password", "BOOLEAN NOT NULL DEFAULT FALSE"},
+	{"users", "created_at", "DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP"},
+	{"users", "department_id", "INT NULL"},
+	{"users", "auth_source", "VARCHAR(20) NOT NULL DEFAULT 'local'"},
+	// Існуючі дані належать установі за замовчуванням (DefaultInstitutionID)
+	{"users", "institution_id", "INT NOT NULL DEFAULT 1"},
+	{"grades", "institution_id", "INT NOT NULL DEFAULT 1"},
+	{"departments", "institution_id", "INT NOT NULL DEFAULT 1"},
+	{"invitations", "institution_id", "INT NOT NULL DEFAULT 1"},
+	{"departments", "faculty_id", "INT NULL"},
+	// Навчальний рік запису, наприклад 2025/2026; старі записи заповнює backfillGradesAcademicYear
+	{"grades", "academic_year", "VARCHAR(9) NOT NULL DEFAULT ''"},
+	// Запланований іспит, результатом якого є запис; один іспит - один запис
+	{"grades", "exam_id", "INT NULL UNIQUE"},
+	// Версія запису для оптимістичного блокування (ETag / If-Match)
+	{"grades", "version", "INT NOT NULL DEFAULT 1"},
+	// Заголовки збереженої відповіді, які відтворюються разом з тілом
+	{"idempotency_keys", "etag", "VARCHAR(100) NOT NULL DEFAULT ''"},
+	{"idempotency_keys", "location", "VARCHAR(1000) NOT NULL DEFAULT ''"},
+}
+
+// foreignKeys - зовнішні ключі для колонок з columns
+var foreignKeys = []struct {
+	table      string
+	name       string
+	definition string
+}{
+	{"users", "fk_users_department", "FOREIGN KEY (department_id) REFERENCES departments(id) ON DELETE SET NULL"},
+	{"users", "fk_users_institution", "FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE RESTRICT"},
+	{"grades", "fk_grades_institution", "FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE RESTRICT"},
+	{"departments", "fk_departments_institution", "FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE RESTRICT"},
+	{"invitations", "fk_invitations_institution", "FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE RESTRICT"},
+	{"settings", "fk_settings_institution", "FOREIGN KEY (institution_id) REFERENCES institutions(id) ON DELETE CASCADE"},
+	{"departments", "fk_departments_faculty", "FOREIGN KEY (faculty_id) REFERENCES faculties(id) ON DELETE SET NULL"},
+	{"grades", "fk_grades_exam", "FOREIGN KEY (exam_id) REFERENCES exams(id) ON DELETE SET NULL"},
+}
+
+// DefaultInstitutionID - установа, якій належать дані, створені до появи кількох установ
+const DefaultInstitutionID = 1
+
+func migrate(ctx context.Context) error {
+	for _, t := range tables {
+		if _, err := DB.ExecContext(ctx, t.ddl); err != nil {
+			return fmt.Errorf("failed to create %s table: %w", t.name, err)
+		}
+	}
+	if _, err := DB.ExecContext(ctx,
+		"INSERT IGNORE INTO institutions (id, name, slug) VALUES (?, 'Default institution', 'default')",
+		DefaultInstitutionID); err != nil {
+		return fmt.Errorf("failed to create default institution: %w", err)
+	}
+	if err := migrateSettingsPerInstitution(ctx); err != nil {
+		return err
+	}
+	for _, c := range columns {
+		if err := ensureColumn(ctx, c.table, c.column, c.definition); err != nil {
+			return err
+		}
+	}
+	if err := migrateDepartmentNamesPerInstitution(ctx); err != nil {
+		return err
+	}
+	if err := migrateUsersEmailUnique(ctx); err != nil {
+		return err
+	}
+	for _, fk := range foreignKeys {
+		if err := ensureForeignKey(ctx, fk.table, fk.name, fk.definition); err != nil {
+			return err
+		}
+	}
+	if err := migrateGradesUserForeignKey(ctx); err != nil {
+		return err
+	}
+	if err := migrateGradeRevisionsForeignKey(ctx); err != nil {
+		return err
+	}
+	return backfillGradesAcademicYear(ctx)
+}
+
+func ensureForeignKey(ctx context.Context, table, name, definition string) error {
+	var exists bool
+	err := DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM information_schema.TABLE_CONSTRAINTS WHERE CONSTRAINT_SCHEMA = DATABASE() AND TABLE_NAME = ? AND CONSTRAINT_NAME = ? AND CONSTRAINT_TYPE = 'FOREIGN KEY')",
+		table, name,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check foreign key %s: %w", name, err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := DB.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", table, name, definition)); err != nil {
+		return fmt.Errorf("failed to add foreign key %s: %w", name, err)
+	}
+	log.Printf("Added foreign key %s on %s", name, table)
+	return nil
+}
+
+// migrateGradesUserForeignKey замінює ON DELETE CASCADE на grades.user_id, з яким
+// видалення користувача мовчки видаляло всі його результати. Тепер обробник
+// видалення явно передає записи іншому користувачу або зберігає їх без власника.
+func migrateGradesUserForeignKey(ctx context.Context) error {
+	var name, rule string
+	err := DB.QueryRowContext(ctx, `
+		SELECT rc.CONSTRAINT_NAME, rc.DELETE_RULE
+		FROM information_schema.REFERENTIAL_CONSTRAINTS rc
+		JOIN information_schema.KEY_COLUMN_USAGE kcu
+			ON kcu.CONSTRAINT_SCHEMA = rc.CONSTRAINT_SCHEMA AND kcu.CONSTRAINT_NAME = rc.CONSTRAINT_NAME
+		WHERE rc.CONSTRAINT_SCHEMA = DATABASE() AND rc.TABLE_NAME = 'grades' AND kcu.COLUMN_NAME = 'user_id'
+		LIMIT 1`,
+	).Scan(&name, &rule)
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("failed to inspect grades.user_id foreign key: %w", err)
+	}
+	if err == nil && rule != "CASCADE" {
+		return nil
+	}
+
+	stmts := []string{"ALTER TABLE grades MODIFY user_id INT NULL"}
+	if err == nil {
+		stmts = append([]string{fmt.Sprintf("ALTER TABLE grades DROP FOREIGN KEY %s", name)}, stmts...)
+	}
+	stmts = append(stmts, "ALTER TABLE grades ADD CONSTRAINT fk_grades_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE RESTRICT")
+	for _, stmt := range stmts {
+		if _, err := DB.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("failed to migrate grades.user_id foreign key: %w", err)
+		}
+	}
+	log.Println("Migrated grades.user_id foreign key to ON DELETE RESTRICT")
+	return nil
+}
+
+// migrateGradeRevisionsForeignKey знімає зовнішній ключ grade_revisions.grade_id з ON DELETE CASCADE:
+// історія видаленого запису залишається (остання ревізія - delete), і запис можна відновити.
+func migrateGradeRevisionsForeignKey(ctx context.Context) error {
+	var name string
+	err := DB.QueryRowContext(ctx, `
+		SELECT CONSTRAINT_NAME FROM information_schema.KEY_COLUMN_USAGE
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'grade_revisions' AND COLUMN_NAME = 'grade_id'
+			AND REFERENCED_TABLE_NAME = 'grades'
+		LIMIT 1`,
+	).Scan(&name)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to inspect grade_revisions.grade_id foreign key: %w", err)
+	}
+	if _, err := DB.ExecContext(ctx, fmt.Sprintf("ALTER TABLE grade_revisions DROP FOREIGN KEY %s", name)); err != nil {
+		return fmt.Errorf("failed to drop grade_revisions.grade_id foreign key: %w", err)
+	}
+	log.Println("Dropped grade_revisions.grade_id foreign key, history now outlives deleted grades")
+	return nil
+}
+
+func columnExists(ctx context.Context, table, column string) (bool, error) {
+	var exists bool
+	err := DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?)",
+		table, column,
+	).Scan(&exists)
+	if err != nil {
+		return false, fmt.Errorf("failed to check column %s.%s: %w", table, column, err)
+	}
+	return exists, nil
+}
+
+func ensureColumn(ctx context.Context, table, column, definition string) error {
+	exists, err := columnExists(ctx, table, column)
+	if err != nil || exists {
+		return err
+	}
+	if _, err := DB.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		return fmt.Errorf("failed to add column %s.%s: %w", table, column, err)
+	}
+	log.Printf("Added column %s.%s", table, column)
+	return nil
+}
+
+// migrateSettingsPerInstitution переводить settings з ключа name на (institution_id, name)
+func migrateSettingsPerInstitution(ctx context.Context) error {
+	exists, err := columnExists(ctx, "settings", "institution_id")
+	if err != nil || exists {
+		return err
+	}
+	if _, err := DB.ExecContext(ctx, fmt.Sprintf(
+		"ALTER TABLE settings ADD COLUMN institution_id INT NOT NULL DEFAULT %d FIRST, DROP PRIMARY KEY, ADD PRIMARY KEY (institution_id, name)",
+		DefaultInstitutionID)); err != nil {
+		return fmt.Errorf("failed to migrate settings to per-institution keys: %w", err)
+	}
+	log.Println("Migrated settings to per-institution keys")
+	return nil
+}
+
+// migrateDepartmentNamesPerInstitution замінює глобальну унікальність назви підрозділу
+// на унікальність у межах установи
+func migrateDepartmentNamesPerInstitution(ctx context.Context) error {
+	var exists bool
+	err := DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'departments' AND INDEX_NAME = 'name')",
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to inspect departments indexes: %w", err)
+	}
+	if !exists {
+		return nil
+	}
+	if _, err := DB.ExecContext(ctx, "ALTER TABLE departments DROP INDEX name, ADD UNIQUE KEY uq_departments_name (institution_id, name)"); err != nil {
+		return fmt.Errorf("failed to migrate departments unique key: %w", err)
+	}
+	log.Println("Migrated departments.name uniqueness to per-institution")
+	return nil
+}
+
+// migrateUsersEmailUnique робить email унікальним: за спільною адресою скидання пароля
+// могло потрапити до чужого облікового запису. Якщо адресу вже мають кілька користувачів,
+// вона лишається за найстарішим, а в інших очищується (їм потрібно вказати власну).
+func migrateUsersEmailUnique(ctx context.Context) error {
+	var exists bool
+	err := DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'users' AND INDEX_NAME = 'uq_users_email')",
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to inspect users indexes: %w", err)
+	}
+	if exists {
+		return nil
+	}
+	if _, err := DB.ExecContext(ctx, "UPDATE users SET email = NULL WHERE email = ''"); err != nil {
+		return fmt.Errorf("failed to clear empty emails: %w", err)
+	}
+	result, err := DB.ExecContext(ctx, `
+		UPDATE users u
+		JOIN (SELECT email, MIN(id) AS keep_id FROM users WHERE email IS NOT NULL GROUP BY email HAVING COUNT(*) > 1) d
+			ON u.email = d.email AND u.id <> d.keep_id
+		SET u.email = NULL`)
+	if err != nil {
+		return fmt.Errorf("failed to clear duplicate emails: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		log.Printf("Cleared duplicate email on %d users before adding unique index", n)
+	}
+	if _, err := DB.ExecContext(ctx, "ALTER TABLE users ADD UNIQUE KEY uq_users_email (email)"); err != nil {
+		return fmt.Errorf("failed to add users email unique key: %w", err)
+	}
+	log.Println("Added unique index on users.email")
+	return nil
+}
@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+	"time"
+)
+
+const dateLayout = "2006-01-02"
+
+var academicYearPattern = regexp.MustCompile(`^(\d{4})/(\d{4})$`)
+
+// validAcademicYear перевіряє назву навчального року виду 2025/2026
+func validAcademicYear(name string) bool {
+	m := academicYearPattern.FindStringSubmatch(name)
+	if m == nil {
+		return false
+	}
+	start, _ := strconv.Atoi(m[1])
+	end, _ := strconv.Atoi(m[2])
+	return end == start+1
+}
+
+// resolveAcademicYear визначає навчальний рік дати: за роками, заданими установою,
+// або, якщо дата поза ними, за ACADEMIC_YEAR_START
+func resolveAcademicYear(ctx context.Context, institutionID int, date time.Time) (string, error) {
+	var name string
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT name FROM academic_years WHERE institution_id = ? AND ? BETWEEN start_date AND end_date",
+		institutionID, date.Format(dateLayout)).Scan(&name)
+	if err == sql.ErrNoRows {
+		return db.DefaultAcademicYear(date), nil
+	}
+	return name, err
+}
+
+// periodFilter додає до умов запиту фільтри academic_year (2025/2026) і semester з параметрів.
+// Повертає текст помилки для відповіді 400, якщо параметри некоректні.
+func periodFilter(q url.Values, where []string, args []any) ([]string, []any, string) {
+	if year := q.Get("academic_year"); year != "" {
+		if !validAcademicYear(year) {
+			return where, args, "academic_year must look like 2025/2026"
+		}
+		where = append(where, "g.academic_year = ?")
+		args = append(args, year)
+	}
+	if s := q.Get("semester"); s != "" {
+		semester, err := strconv.Atoi(s)
+		if err != nil || semester < 1 {
+			return where, args, "Invalid semester"
+		}
+		where = append(where, "g.semester = ?")
+		args = append(args, semester)
+	}
+	return where, args, ""
+}
+
+// ListAcademicYears повертає навчальні роки, задані установою, і роки, що лише зустрічаються в записах (з id = 0)
+func ListAcademicYears(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT y.id, y.name, y.start_date, y.end_date FROM academic_years y WHERE y.institution_id = ?
+		UNION ALL
+		SELECT DISTINCT 0, g.academic_year, NULL, NULL FROM grades g
+		WHERE g.institution_id = ? AND g.academic_year NOT IN (SELECT name FROM academic_years WHERE institution_id = ?)
+		ORDER BY 2 DESC`,
+		institutionID(r), institutionID(r), institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("academic_year_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	years := []models.AcademicYear{}
+	for rows.Next() {
+		var y models.AcademicYear
+		var start, end sql.NullTime
+		if err := rows.Scan(&y.ID, &y.Name, &start, &end); err != nil {
+			metrics.DBErrorsTotal.Inc("academic_year_scan")
+			http.Error(w, "Failed to scan academic years", http.StatusInternalServerError)
+			return
+		}
+		if start.Valid {
+			y.StartDate, y.EndDate = start.Time.Format(dateLayout), end.Time.Format(dateLayout)
+		}
+		years = append(years, y)
+	}
+	json.NewEncoder(w).Encode(years)
+}
+
+// CreateAcademicYear задає дати навчального року установи і переносить до нього існуючі записи цього періоду
+func CreateAcademicYear(w http.ResponseWriter, r *http.Request) {
+	var req models.AcademicYear
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	start, err1 := time.Parse(dateLayout, req.StartDate)
+	end, err2 := time.Parse(dateLayout, req.EndDate)
+	if err1 != nil || err2 != nil {
+		http.Error(w, "start_date and end_date must be dates in YYYY-MM-DD format", http.StatusBadRequest)
+		return
+	}
+	if !end.After(start) || end.Sub(start) > 366*24*time.Hour {
+		http.Error(w, "Academic year must end after it starts and last at most a year", http.StatusBadRequest)
+		return
+	}
+	if req.Name == "" {
+		req.Name = db.AcademicYearName(start.Year())
+	}
+	if !validAcademicYear(req.Name) {
+		http.Error(w, "name must look like 2025/2026", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("academic_year_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var overlaps int
+	if err := tx.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM academic_years WHERE institution_id = ? AND start_date <= ? AND end_date >= ? FOR UPDATE",
+		institutionID(r), req.EndDate, req.StartDate).Scan(&overlaps); err != nil {
+		metrics.DBErrorsTotal.Inc("academic_year_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if overlaps > 0 {
+		http.Error(w, "Academic year overlaps an existing one", http.StatusConflict)
+		return
+	}
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO academic_years (institution_id, name, start_date, end_date) VALUES (?, ?, ?, ?)",
+		institutionID(r), req.Name, req.StartDate, req.EndDate)
+	if isDuplicateKey(err) {
+		http.Error(w, "Academic year already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("academic_year_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	response := models.CreateAcademicYearResponse{AcademicYear: req}
+	response.ID = int(id)
+
+	result, err = tx.ExecContext(ctx,
+		"UPDATE grades SET academic_year = ? WHERE institution_id = ? AND date BETWEEN ? AND ?",
+		req.Name, institutionID(r), req.StartDate, req.EndDate)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("academic_year_update_grades")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	response.GradesUpdated, _ = result.RowsAffected()
+	if err := tx.Commit(); err != nil {
+		metrics.DBErrorsTotal.Inc("academic_year_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Academic year %s (%s - %s) created, %d grade records updated", req.Name, req.StartDate, req.EndDate, response.GradesUpdated)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
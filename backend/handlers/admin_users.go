@@ -0,0 +1,655 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const userColumns = "id, username, email, role, totp_enabled, disabled, must_change_password, created_at, department_id, auth_source, institution_id"
+
+func scanUser(row interface{ Scan(...any) error }) (models.User, error) {
+	var u models.User
+	var email sql.NullString
+	var createdAt time.Time
+	var departmentID sql.NullInt64
+	if err := row.Scan(&u.ID, &u.Username, &email, &u.Role, &u.TOTPEnabled, &u.Disabled, &u.MustChangePassword, &createdAt, &departmentID, &u.AuthSource, &u.InstitutionID); err != nil {
+		return u, err
+	}
+	u.Email = email.String
+	u.CreatedAt = &createdAt
+	u.DepartmentID = nullIntPtr(departmentID)
+	return u, nil
+}
+
+func nullIntPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
+
+// loadUser читає користувача установи; користувачі інших установ для адміністратора не існують
+func loadUser(ctx context.Context, institutionID, userID int) (models.User, error) {
+	return scanUser(db.DB.QueryRowContext(ctx, "SELECT "+userColumns+" FROM users WHERE id = ? AND institution_id = ?", userID, institutionID))
+}
+
+// managedUser перевіряє, що адміністратор може змінювати користувача: той належить до його
+// установи, а обліковий запис адміністратора платформи змінює лише інший superadmin.
+// У разі відмови відповідь уже записано.
+func managedUser(ctx context.Context, w http.ResponseWriter, r *http.Request, id int) bool {
+	var role string
+	err := db.DB.QueryRowContext(ctx, "SELECT role FROM users WHERE id = ? AND institution_id = ?", id, institutionID(r)).Scan(&role)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if callerRole, _ := r.Context().Value("userRole").(string); role == models.RoleSuperadmin && callerRole != models.RoleSuperadmin {
+		log.Println("Admin denied access to superadmin user ID:", id)
+		http.Error(w, "Only platform administrators can manage this account", http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// pathID читає числовий параметр маршруту
+func pathID(r *http.Request, name string) (int, bool) {
+	id, err := strconv.Atoi(mux.Vars(r)[name])
+	return id, err == nil && id > 0
+}
+
+// ListUsers повертає користувачів з пошуком за ім'ям/email і фільтрами за роллю та станом
+func ListUsers(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where := []string{"institution_id = ?"}
+	args := []any{institutionID(r)}
+
+	if search := strings.TrimSpace(q.Get("q")); search != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search) + "%"
+		where = append(where, "(username LIKE ? OR email LIKE ?)")
+		args = append(args, pattern, pattern)
+	}
+	if role := q.Get("role"); role != "" {
+		where = append(where, "role = ?")
+		args = append(args, role)
+	}
+	if disabled := q.Get("disabled"); disabled != "" {
+		value, err := strconv.ParseBool(disabled)
+		if err != nil {
+			http.Error(w, "Invalid disabled filter", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "disabled = ?")
+		args = append(args, value)
+	}
+	limit, offset, ok := pagination(q.Get("limit"), q.Get("offset"))
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	cond := strings.Join(where, " AND ")
+	var list models.UserList
+	if err := db.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM users WHERE "+cond, args...).Scan(&list.Total); err != nil {
+		metrics.DBErrorsTotal.Inc("admin_count_users")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT "+userColumns+" FROM users WHERE "+cond+" ORDER BY username LIMIT ? OFFSET ?",
+		append(args, limit, offset)...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_select_users")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	list.Users = []models.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("admin_scan_users")
+			http.Error(w, "Failed to scan users", http.StatusInternalServerError)
+			return
+		}
+		list.Users = append(list.Users, u)
+	}
+	json.NewEncoder(w).Encode(list)
+}
+
+// pagination розбирає limit/offset (limit за замовчуванням 50, максимум 500)
+func pagination(limitStr, offsetStr string) (int, int, bool) {
+	limit, offset := 50, 0
+	var err error
+	if limitStr != "" {
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit < 1 || limit > 500 {
+			return 0, 0, false
+		}
+	}
+	if offsetStr != "" {
+		if offset, err = strconv.Atoi(offsetStr); err != nil || offset < 0 {
+			return 0, 0, false
+		}
+	}
+	return limit, offset, true
+}
+
+// CreateUser створює користувача установи з тимчасовим паролем, який треба змінити після входу
+func CreateUser(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if msg := createUserError(&req); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	id, tempPassword, err := insertTemporaryUser(ctx, db.DB, institutionID(r), req)
+	if isDuplicateKey(err) {
+		http.Error(w, "Username or email already taken", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to create user:", err)
+		metrics.DBErrorsTotal.Inc("admin_insert_user")
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+	user, err := loadUser(ctx, institutionID(r), id)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Admin created user ID:", id, "role:", req.Role, "institution:", user.InstitutionID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreateUserResponse{User: user, TemporaryPassword: tempPassword})
+}
+
+// createUserError нормалізує запит на створення користувача і повертає опис помилки або ""
+func createUserError(req *models.CreateUserRequest) string {
+	req.Username = strings.TrimSpace(req.Username)
+	req.Email = strings.TrimSpace(req.Email)
+	if len(req.Username) < 3 || len(req.Username) > 50 {
+		return "Username must be 3-50 characters"
+	}
+	if req.Email != "" {
+		if err := validate.Var(req.Email, "email,max=255"); err != nil {
+			return "Invalid email address"
+		}
+	}
+	if req.Role == "" {
+		req.Role = models.RoleTeacher
+	}
+	if !validRole(req.Role) {
+		return "Unknown role: " + req.Role
+	}
+	return ""
+}
+
+// execer - *sql.DB або *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+// insertTemporaryUser додає користувача з тимчасовим паролем і повертає його ID та пароль
+func insertTemporaryUser(ctx context.Context, ex execer, institutionID int, req models.CreateUserRequest) (int, string, error) {
+	tempPassword, err := temporaryPassword()
+	if err != nil {
+		return 0, "", err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(tempPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return 0, "", err
+	}
+	result, err := ex.ExecContext(ctx,
+		"INSERT INTO users (username, password, email, role, must_change_password, institution_id) VALUES (?, ?, ?, ?, TRUE, ?)",
+		req.Username, hashedPassword, sql.NullString{String: req.Email, Valid: req.Email != ""}, req.Role, institutionID)
+	if err != nil {
+		return 0, "", err
+	}
+	id, _ := result.LastInsertId()
+	return int(id), tempPassword, nil
+}
+
+// temporaryPassword генерує випадковий пароль з 16 символів
+func temporaryPassword() (string, error) {
+	const alphabet = "ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnpqrstuvwxyz23456789"
+	out := make([]byte, 0, 16)
+	buf := make([]byte, 32)
+	for len(out) < cap(out) {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			// Відкидаємо значення, що дали б нерівномірний розподіл символів
+			if int(b) < 256-256%len(alphabet) && len(out) < cap(out) {
+				out = append(out, alphabet[int(b)%len(alphabet)])
+			}
+		}
+	}
+	return string(out), nil
+}
+
+func isDuplicateKey(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
+
+func isForeignKeyViolation(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1452
+}
+
+// UpdateUser перейменовує користувача або змінює його email
+func UpdateUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var req models.UpdateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	var set []string
+	var args []any
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if len(username) < 3 || len(username) > 50 {
+			http.Error(w, "Username must be 3-50 characters", http.StatusBadRequest)
+			return
+		}
+		set = append(set, "username = ?")
+		args = append(args, username)
+	}
+	if req.Email != nil {
+		email := strings.TrimSpace(*req.Email)
+		if email != "" {
+			if err := validate.Var(email, "email,max=255"); err != nil {
+				http.Error(w, "Invalid email address", http.StatusBadRequest)
+				return
+			}
+		}
+		set = append(set, "email = ?")
+		args = append(args, sql.NullString{String: email, Valid: email != ""})
+	}
+	if req.DepartmentID != nil {
+		set = append(set, "department_id = ?")
+		args = append(args, sql.NullInt64{Int64: int64(*req.DepartmentID), Valid: *req.DepartmentID > 0})
+	}
+	if len(set) == 0 {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	if !managedUser(ctx, w, r, id) {
+		return
+	}
+	// Підрозділ має належати тій самій установі
+	if req.DepartmentID != nil && *req.DepartmentID > 0 {
+		var exists bool
+		if err := db.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM departments WHERE id = ? AND institution_id = ?)", *req.DepartmentID, institutionID(r)).Scan(&exists); err != nil || !exists {
+			http.Error(w, "Department not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	_, err := db.DB.ExecContext(ctx, "UPDATE users SET "+strings.Join(set, ", ")+" WHERE id = ? AND institution_id = ?", append(args, id, institutionID(r))...)
+	if isDuplicateKey(err) {
+		http.Error(w, "Username or email already taken", http.StatusConflict)
+		return
+	}
+	if isForeignKeyViolation(err) {
+		http.Error(w, "Department not found", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_update_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	respondUser(ctx, w, r, id)
+}
+
+// UpdateUserRole змінює роль користувача
+func UpdateUserRole(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	var req models.UpdateRoleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !validRole(req.Role) {
+		http.Error(w, "Unknown role: "+req.Role, http.StatusBadRequest)
+		return
+	}
+	// Адміністратор не може випадково позбавити доступу сам себе
+	if adminID, _ := r.Context().Value("userID").(int); adminID == id && req.Role != r.Context().Value("userRole") {
+		http.Error(w, "You cannot remove your own admin role", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	if !managedUser(ctx, w, r, id) {
+		return
+	}
+	if _, err := db.DB.ExecContext(ctx, "UPDATE users SET role = ? WHERE id = ? AND institution_id = ?", req.Role, id, institutionID(r)); err != nil {
+		metrics.DBErrorsTotal.Inc("admin_update_role")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	log.Println("Role of user ID:", id, "changed to", req.Role)
+	respondUser(ctx, w, r, id)
+}
+
+// DisableUser блокує обліковий запис (перевіряється в JWTAuthMiddleware і при вході)
+func DisableUser(w http.ResponseWriter, r *http.Request) {
+	setUserDisabled(w, r, true)
+}
+
+// EnableUser розблоковує обліковий запис
+func EnableUser(w http.ResponseWriter, r *http.Request) {
+	setUserDisabled(w, r, false)
+}
+
+func setUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if adminID, _ := r.Context().Value("userID").(int); adminID == id && disabled {
+		http.Error(w, "You cannot disable your own account", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	if !managedUser(ctx, w, r, id) {
+		return
+	}
+	if _, err := db.DB.ExecContext(ctx, "UPDATE users SET disabled = ? WHERE id = ? AND institution_id = ?", disabled, id, institutionID(r)); err != nil {
+		metrics.DBErrorsTotal.Inc("admin_update_disabled")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	log.Println("User ID:", id, "disabled:", disabled)
+	respondUser(ctx, w, r, id)
+}
+
+func respondUser(ctx context.Context, w http.ResponseWriter, r *http.Request, id int) {
+	user, err := loadUser(ctx, institutionID(r), id)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(user)
+}
+
+// DeleteUser видаляє користувача. Його записи grades передаються користувачу
+// ?reassign_to=<id> або зберігаються без власника (user_id = NULL); такі записи
+// видно в /api/admin/grades/orphaned, звідки їх можна передати іншому користувачу.
+func DeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid user ID", http.StatusBadRequest)
+		return
+	}
+	if adminID, _ := r.Context().Value("userID").(int); adminID == id {
+		http.Error(w, "You cannot delete your own account", http.StatusBadRequest)
+		return
+	}
+	var reassignTo *int
+	if v := r.URL.Query().Get("reassign_to"); v != "" {
+		target, err := strconv.Atoi(v)
+		if err != nil || target <= 0 || target == id {
+			http.Error(w, "Invalid reassign_to user ID", http.StatusBadRequest)
+			return
+		}
+		reassignTo = &target
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_delete_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRowContext(ctx, "SELECT role FROM users WHERE id = ? AND institution_id = ? FOR UPDATE", id, institutionID(r)).Scan(&role)
+	if err == sql.ErrNoRows {
+		http.Error(w, "User not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_delete_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if callerRole, _ := r.Context().Value("userRole").(string); role == models.RoleSuperadmin && callerRole != models.RoleSuperadmin {
+		http.Error(w, "Only platform administrators can manage this account", http.StatusForbidden)
+		return
+	}
+
+	response := models.DeleteUserResponse{DeletedUserID: id, ReassignedTo: reassignTo}
+	var owner sql.NullInt64
+	if reassignTo != nil {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND institution_id = ?)", *reassignTo, institutionID(r)).Scan(&exists); err != nil || !exists {
+			http.Error(w, "Reassign target user not found", http.StatusBadRequest)
+			return
+		}
+		owner = sql.NullInt64{Int64: int64(*reassignTo), Valid: true}
+	}
+	adminID, _ := r.Context().Value("userID").(int)
+	n, err := changeGradeOwner(ctx, tx, institutionID(r), "g.user_id = ?", []any{id}, owner, adminID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_delete_user_grades")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if reassignTo != nil {
+		response.GradesReassigned = int(n)
+	} else {
+		response.GradesPreserved = int(n)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM users WHERE id = ?", id); err != nil {
+		log.Println("Failed to delete user:", err)
+		metrics.DBErrorsTotal.Inc("admin_delete_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		metrics.DBErrorsTotal.Inc("admin_delete_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Deleted user ID %d, grades reassigned: %d, preserved: %d", id, response.GradesReassigned, response.GradesPreserved)
+	json.NewEncoder(w).Encode(response)
+}
+
+// changeGradeOwner передає записи установи, що відповідають умові where, власнику owner
+// (NULL - без власника). Кожна зміна отримує нову версію і ревізію в історії.
+func changeGradeOwner(ctx context.Context, tx *sql.Tx, institutionID int, where string, args []any, owner sql.NullInt64, changedBy int) (int, error) {
+	if err := lockGradeWrites(ctx, tx, institutionID); err != nil {
+		return 0, err
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT "+gradeColumns+" FROM grades g WHERE g.institution_id = ? AND "+where+" ORDER BY g.id FOR UPDATE",
+		append([]any{institutionID}, args...)...)
+	if err != nil {
+		return 0, err
+	}
+	var grades []models.Grade
+	for rows.Next() {
+		g, err := scanGrade(rows)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		grades = append(grades, g)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, g := range grades {
+		if err := recordBaseline(ctx, tx, institutionID, g); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE grades SET user_id = ?, version = version + 1 WHERE id = ?", owner, g.ID); err != nil {
+			return 0, err
+		}
+		g.UserID, g.Version = int(owner.Int64), g.Version+1
+		if err := recordRevision(ctx, tx, institutionID, g, models.RevisionReassign, nil, changedBy); err != nil {
+			return 0, err
+		}
+	}
+	return len(grades), nil
+}
+
+// ListOrphanedGrades повертає записи установи без власника (залишені після видалення користувачів)
+func ListOrphanedGrades(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT "+gradeColumns+" FROM grades g WHERE g.institution_id = ? AND g.user_id IS NULL ORDER BY g.date, g.id",
+		institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_orphaned_grades_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	grades := []models.Grade{}
+	for rows.Next() {
+		g, err := scanGrade(rows)
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("admin_orphaned_grades_scan")
+			http.Error(w, "Failed to scan grades", http.StatusInternalServerError)
+			return
+		}
+		grades = append(grades, g)
+	}
+	json.NewEncoder(w).Encode(grades)
+}
+
+// AssignOrphanedGrades передає записи без власника користувачу установи, після чого вони
+// знову видні йому в загальних переглядах
+func AssignOrphanedGrades(w http.ResponseWriter, r *http.Request) {
+	var req models.AssignGradesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.UserID <= 0 {
+		http.Error(w, "user_id is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_assign_grades")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND institution_id = ?)", req.UserID, institutionID(r)).Scan(&exists); err != nil {
+		metrics.DBErrorsTotal.Inc("admin_assign_grades")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "User not found", http.StatusBadRequest)
+		return
+	}
+
+	where, args := "g.user_id IS NULL", []any{}
+	if len(req.GradeIDs) > 0 {
+		where += " AND g.id IN (?" + strings.Repeat(", ?", len(req.GradeIDs)-1) + ")"
+		for _, id := range req.GradeIDs {
+			args = append(args, id)
+		}
+	}
+	adminID, _ := r.Context().Value("userID").(int)
+	n, err := changeGradeOwner(ctx, tx, institutionID(r), where, args, sql.NullInt64{Int64: int64(req.UserID), Valid: true}, adminID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_assign_grades")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if len(req.GradeIDs) > 0 && n != len(req.GradeIDs) {
+		http.Error(w, "Some grade records were not found or already have an owner", http.StatusConflict)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		metrics.DBErrorsTotal.Inc("admin_assign_grades")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Assigned %d orphaned grade records to user ID %d", n, req.UserID)
+	json.NewEncoder(w).Encode(models.AssignGradesResponse{UserID: req.UserID, Assigned: n})
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"study_grade/config"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+	"study_grade/stats"
+)
+
+// anomalyHistoryLimit - скільки останніх записів групи з предмета береться за історію
+const anomalyHistoryLimit = 50
+
+// minAllTopGradesStudents - з якої кількості студентів "усі на 5" вважається підозрілим
+const minAllTopGradesStudents = 5
+
+// detectAnomalies порівнює запис з історією тієї ж групи з того ж предмета (z-оцінки
+// середнього балу, успішності та якості) і перевіряє очевидно підозрілі розподіли.
+// ANOMALY_Z_THRESHOLD (3) - поріг |z|, ANOMALY_MIN_HISTORY (3) - мінімум записів історії.
+func detectAnomalies(ctx context.Context, institutionID int, grade models.Grade) ([]models.AnomalyReason, error) {
+	var reasons []models.AnomalyReason
+	if grade.TotalStudents >= minAllTopGradesStudents && grade.Grade5 == grade.TotalStudents {
+		reasons = append(reasons, models.AnomalyReason{Check: models.AnomalyCheckAllTopGrades, Metric: "grade_5", Value: float64(grade.Grade5)})
+	}
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT average_score, success_rate, quality_rate FROM grades
+		WHERE institution_id = ? AND subject = ? AND group_name = ? AND id <> ?
+		ORDER BY date DESC LIMIT ?`,
+		institutionID, grade.Subject, grade.Group, grade.ID, anomalyHistoryLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var average, success, quality []float64
+	for rows.Next() {
+		var a, s, q float64
+		if err := rows.Scan(&a, &s, &q); err != nil {
+			return nil, err
+		}
+		average, success, quality = append(average, a), append(success, s), append(quality, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(average) < config.Int("ANOMALY_MIN_HISTORY", 3) {
+		return reasons, nil
+	}
+
+	threshold := config.Float("ANOMALY_Z_THRESHOLD", 3)
+	for _, m := range []struct {
+		name    string
+		value   float64
+		history []float64
+	}{
+		{"average_score", grade.AverageScore, average},
+		{"success_rate", grade.SuccessRate, success},
+		{"quality_rate", grade.QualityRate, quality},
+	} {
+		mean, stdDev := stats.Mean(m.history), stats.StdDev(m.history)
+		// При нульовому розкиді історії z-оцінка не визначена - перевірку пропускаємо
+		z, ok := stats.ZScore(m.value, mean, stdDev)
+		if !ok || math.Abs(z) < threshold {
+			continue
+		}
+		reasons = append(reasons, models.AnomalyReason{
+			Check:   models.AnomalyCheckZScore,
+			Metric:  m.name,
+			Value:   m.value,
+			Mean:    mean,
+			StdDev:  stdDev,
+			ZScore:  z,
+			History: len(m.history),
+		})
+	}
+	return reasons, nil
+}
+
+// flagAnomalies позначає підозрілий запис для перевірки адміністратором. Після зміни запису
+// позначка оновлюється і знову чекає на перевірку, а непереглянута зникає, якщо запис став звичайним.
+// Запис не блокується: помилки перевірки лише логуються.
+func flagAnomalies(ctx context.Context, institutionID int, grade models.Grade) []models.AnomalyReason {
+	reasons, err := detectAnomalies(ctx, institutionID, grade)
+	if err != nil {
+		log.Println("Anomaly check failed for grade", grade.ID, ":", err)
+		metrics.DBErrorsTotal.Inc("anomaly_detect")
+		return nil
+	}
+	if len(reasons) == 0 {
+		if _, err := db.DB.ExecContext(ctx,
+			"DELETE FROM grade_anomalies WHERE grade_id = ? AND institution_id = ? AND status = ?",
+			grade.ID, institutionID, models.AnomalyPending); err != nil {
+			log.Println("Failed to clear anomaly flag of grade", grade.ID, ":", err)
+			metrics.DBErrorsTotal.Inc("anomaly_delete")
+		}
+		return nil
+	}
+	data, _ := json.Marshal(reasons)
+	if _, err := db.DB.ExecContext(ctx,
+		`INSERT INTO grade_anomalies (grade_id, institution_id, reasons) VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE reasons = VALUES(reasons), status = ?, note = NULL, reviewed_by = NULL, reviewed_at = NULL, created_at = CURRENT_TIMESTAMP`,
+		grade.ID, institutionID, data, models.AnomalyPending); err != nil {
+		log.Println("Failed to flag anomalous grade", grade.ID, ":", err)
+		metrics.DBErrorsTotal.Inc("anomaly_insert")
+		return reasons
+	}
+	log.Printf("Grade %d flagged for review: %d anomaly reason(s)", grade.ID, len(reasons))
+	return reasons
+}
+
+// ListAnomalies повертає позначені записи для перевірки; status: pending (за замовчуванням),
+// confirmed, dismissed або all
+func ListAnomalies(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where := []string{"a.institution_id = ?"}
+	args := []any{institutionID(r)}
+	switch status := q.Get("status"); status {
+	case "":
+		where = append(where, "a.status = ?")
+		args = append(args, models.AnomalyPending)
+	case "all":
+	case models.AnomalyPending, models.AnomalyConfirmed, models.AnomalyDismissed:
+		where = append(where, "a.status = ?")
+		args = append(args, status)
+	default:
+		http.Error(w, "Invalid status filter", http.StatusBadRequest)
+		return
+	}
+	limit, offset, ok := pagination(q.Get("limit"), q.Get("offset"))
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT a.id, a.reasons, a.status, a.note, a.created_at, a.reviewed_by, a.reviewed_at, `+gradeColumns+`
+		FROM grade_anomalies a JOIN grades g ON g.id = a.grade_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY a.created_at DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("anomaly_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	anomalies := []models.GradeAnomaly{}
+	for rows.Next() {
+		a, err := scanAnomaly(rows)
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("anomaly_scan")
+			http.Error(w, "Failed to scan anomalies", http.StatusInternalServerError)
+			return
+		}
+		anomalies = append(anomalies, a)
+	}
+	json.NewEncoder(w).Encode(anomalies)
+}
+
+func scanAnomaly(row interface{ Scan(...any) error }) (models.GradeAnomaly, error) {
+	var a models.GradeAnomaly
+	var reasons []byte
+	var note sql.NullString
+	var reviewedBy sql.NullInt64
+	var reviewedAt sql.NullTime
+	g, err := scanGrade(row, &a.ID, &reasons, &a.Status, &note, &a.CreatedAt, &reviewedBy, &reviewedAt)
+	if err != nil {
+		return a, err
+	}
+	if err := json.Unmarshal(reasons, &a.Reasons); err != nil {
+		return a, err
+	}
+	a.Grade = g
+	a.Note = note.String
+	a.ReviewedBy = nullIntPtr(reviewedBy)
+	a.ReviewedAt = nullTimePtr(reviewedAt)
+	return a, nil
+}
+
+// ReviewAnomaly фіксує рішення адміністратора: помилку підтверджено (confirmed) чи запис коректний (dismissed)
+func ReviewAnomaly(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid anomaly ID", http.StatusBadRequest)
+		return
+	}
+	var req models.ReviewAnomalyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Status != models.AnomalyConfirmed && req.Status != models.AnomalyDismissed {
+		http.Error(w, "Status must be confirmed or dismissed", http.StatusBadRequest)
+		return
+	}
+	req.Note = strings.TrimSpace(req.Note)
+	if len(req.Note) > 500 {
+		http.Error(w, "Note must be at most 500 characters", http.StatusBadRequest)
+		return
+	}
+	adminID, _ := r.Context().Value("userID").(int)
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	exists, err := inInstitution(ctx, "grade_anomalies", id, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("anomaly_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Anomaly not found", http.StatusNotFound)
+		return
+	}
+	if _, err := db.DB.ExecContext(ctx,
+		"UPDATE grade_anomalies SET status = ?, note = ?, reviewed_by = ?, reviewed_at = NOW() WHERE id = ?",
+		req.Status, sql.NullString{String: req.Note, Valid: req.Note != ""}, adminID, id); err != nil {
+		metrics.DBErrorsTotal.Inc("anomaly_review")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	a, err := scanAnomaly(db.DB.QueryRowContext(ctx, `
+		SELECT a.id, a.reasons, a.status, a.note, a.created_at, a.reviewed_by, a.reviewed_at, `+gradeColumns+`
+		FROM grade_anomalies a JOIN grades g ON g.id = a.grade_id
+		WHERE a.id = ?`, id))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("anomaly_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	log.Println("Anomaly", id, "reviewed by admin", adminID, "as", req.Status)
+	json.NewEncoder(w).Encode(a)
+}
@@ -0,0 +1,171 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/middleware"
+	"study_grade/models"
+	"time"
+)
+
+const maxAPITokensPerUser = 50
+
+// ListAPITokens повертає персональні токени поточного користувача (без самих токенів)
+func ListAPITokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT id, name, prefix, scope, created_at, expires_at, last_used_at, revoked_at FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC",
+		userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("api_token_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	tokens := []models.APIToken{}
+	for rows.Next() {
+		var t models.APIToken
+		var expiresAt, lastUsedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, &t.Scope, &t.CreatedAt, &expiresAt, &lastUsedAt, &revokedAt); err != nil {
+			metrics.DBErrorsTotal.Inc("api_token_scan")
+			http.Error(w, "Failed to scan tokens", http.StatusInternalServerError)
+			return
+		}
+		t.ExpiresAt = nullTimePtr(expiresAt)
+		t.LastUsedAt = nullTimePtr(lastUsedAt)
+		t.RevokedAt = nullTimePtr(revokedAt)
+		tokens = append(tokens, t)
+	}
+	json.NewEncoder(w).Encode(tokens)
+}
+
+// CreateAPIToken видає персональний токен; сам токен повертається лише в цій відповіді
+func CreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	role, _ := r.Context().Value("userRole").(string)
+
+	var req models.CreateAPITokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 100 {
+		http.Error(w, "Token name must be 1-100 characters", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == "" {
+		req.Scope = middleware.APIScopeRead
+	}
+	if !middleware.ValidAPIScope(req.Scope) {
+		http.Error(w, "Scope must be read, write, admin or calendar", http.StatusBadRequest)
+		return
+	}
+	if req.Scope == middleware.APIScopeAdmin && role != models.RoleAdmin && role != models.RoleSuperadmin {
+		http.Error(w, "Only administrators can create admin tokens", http.StatusForbidden)
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > 3650 {
+		http.Error(w, "expires_in_days must be between 0 and 3650", http.StatusBadRequest)
+		return
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	token := middleware.APITokenPrefix + hex.EncodeToString(buf)
+
+	now := time.Now().UTC().Truncate(time.Second)
+	apiToken := models.APIToken{
+		Name:      req.Name,
+		Prefix:    token[:len(middleware.APITokenPrefix)+6],
+		Scope:     req.Scope,
+		CreatedAt: now,
+	}
+	if req.ExpiresInDays > 0 {
+		expiresAt := now.AddDate(0, 0, req.ExpiresInDays)
+		apiToken.ExpiresAt = &expiresAt
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	var count int
+	if err := db.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM api_tokens WHERE user_id = ? AND revoked_at IS NULL", userID).Scan(&count); err != nil {
+		metrics.DBErrorsTotal.Inc("api_token_count")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if count >= maxAPITokensPerUser {
+		http.Error(w, "Too many active tokens, revoke unused ones first", http.StatusConflict)
+		return
+	}
+
+	result, err := db.DB.ExecContext(ctx,
+		"INSERT INTO api_tokens (user_id, name, token_hash, prefix, scope, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		userID, apiToken.Name, middleware.HashAPIToken(token), apiToken.Prefix, apiToken.Scope, now, apiToken.ExpiresAt)
+	if err != nil {
+		log.Println("Failed to create API token:", err)
+		metrics.DBErrorsTotal.Inc("api_token_insert")
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	apiToken.ID = int(id)
+
+	log.Println("User", userID, "created API token", apiToken.ID, "with scope", apiToken.Scope)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreateAPITokenResponse{APIToken: apiToken, Token: token})
+}
+
+// RevokeAPIToken відкликає власний токен користувача
+func RevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid token ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx, "UPDATE api_tokens SET revoked_at = NOW() WHERE id = ? AND user_id = ? AND revoked_at IS NULL", id, userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("api_token_revoke")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Token not found or already revoked", http.StatusNotFound)
+		return
+	}
+	log.Println("User", userID, "revoked API token", id)
+	w.WriteHeader(http.StatusNoContent)
+}
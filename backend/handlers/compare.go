@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+	"study_grade/stats"
+)
+
+// defaultAlpha - рівень значущості для критерію хі-квадрат
+const defaultAlpha = 0.05
+
+// gradeDistribution - кількості 5/4/3/2/не склали як рядок таблиці спряженості
+func gradeDistribution(g models.GroupComparison) []float64 {
+	return []float64{float64(g.Grade5), float64(g.Grade4), float64(g.Grade3), float64(g.Grade2), float64(g.NotPassed)}
+}
+
+func chiSquareTest(table [][]float64, alpha float64) *models.ChiSquareTest {
+	result, ok := stats.ChiSquare(table)
+	if !ok {
+		return nil
+	}
+	return &models.ChiSquareTest{
+		ChiSquare:   result.Statistic,
+		DF:          result.DF,
+		PValue:      result.PValue,
+		Significant: result.PValue < alpha,
+		LowExpected: result.LowExpected,
+	}
+}
+
+// rankMetric заповнює місце, перцентиль і різницю з підрозділом для одного показника всіх груп
+func rankMetric(groups []models.GroupComparison, metric func(*models.GroupComparison) *models.RankedMetric, departmentMean func(id int) float64) {
+	values := make([]float64, len(groups))
+	for i := range groups {
+		values[i] = metric(&groups[i]).Value
+	}
+	for i := range groups {
+		m := metric(&groups[i])
+		m.Rank = 1
+		for _, v := range values {
+			if v > m.Value {
+				m.Rank++
+			}
+		}
+		m.Percentile = stats.PercentileRank(values, m.Value)
+		if groups[i].DepartmentID != nil {
+			m.DepartmentDiff = floatPtr(m.Value - departmentMean(*groups[i].DepartmentID))
+		}
+	}
+}
+
+// GetGroupComparison порівнює групи, що складали один предмет в одному семестрі:
+// місця і перцентилі за кожним показником, різниця із середнім по підрозділу
+// та критерій хі-квадрат для розподілів оцінок (усі групи разом і кожна група проти решти).
+// Параметри: subject і semester (обов'язкові), academic_year (2025/2026), alpha.
+// Викладач порівнює лише групи за власними записами, і середні по підрозділах рахуються з них же.
+func GetGroupComparison(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	response := models.GroupComparisonResponse{Subject: q.Get("subject"), Alpha: defaultAlpha, Groups: []models.GroupComparison{}}
+	if response.Subject == "" {
+		http.Error(w, "subject is required", http.StatusBadRequest)
+		return
+	}
+	semester, err := strconv.Atoi(q.Get("semester"))
+	if err != nil || semester < 1 {
+		http.Error(w, "semester is required", http.StatusBadRequest)
+		return
+	}
+	response.Semester = semester
+	if s := q.Get("alpha"); s != "" {
+		alpha, err := strconv.ParseFloat(s, 64)
+		if err != nil || alpha <= 0 || alpha >= 1 {
+			http.Error(w, "alpha must be between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		response.Alpha = alpha
+	}
+
+	where, args := ownRecordsOnly(r, []string{"g.institution_id = ?"}, []any{institutionID(r)})
+	where = append(where, "g.subject = ?", "g.semester = ?")
+	args = append(args, response.Subject, semester)
+	if year := q.Get("academic_year"); year != "" {
+		if !validAcademicYear(year) {
+			http.Error(w, "academic_year must look like 2025/2026", http.StatusBadRequest)
+			return
+		}
+		response.AcademicYear = year
+		where = append(where, "g.academic_year = ?")
+		args = append(args, year)
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT g.group_name, d.id, d.name,
+			COUNT(*), SUM(g.total_students), SUM(g.grade_5), SUM(g.grade_4), SUM(g.grade_3), SUM(g.grade_2), SUM(g.not_passed)
+		FROM grades g`+gradeHierarchyJoins+`
+		WHERE `+strings.Join(where, " AND ")+`
+		GROUP BY g.group_name, d.id, d.name
+		ORDER BY g.group_name, d.id`,
+		args...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("compare_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	// Записи групи можуть належати різним підрозділам (через викладачів) - групу відносимо до першого
+	departments := map[int]*models.StatsNode{}
+	for rows.Next() {
+		var g models.GroupComparison
+		var departmentID sql.NullInt64
+		var departmentName sql.NullString
+		if err := rows.Scan(&g.Group, &departmentID, &departmentName,
+			&g.Records, &g.TotalStudents, &g.Grade5, &g.Grade4, &g.Grade3, &g.Grade2, &g.NotPassed); err != nil {
+			metrics.DBErrorsTotal.Inc("compare_scan")
+			http.Error(w, "Failed to scan groups", http.StatusInternalServerError)
+			return
+		}
+		g.DepartmentID, g.DepartmentName = nullIntPtr(departmentID), departmentName.String
+
+		if n := len(response.Groups); n > 0 && response.Groups[n-1].Group == g.Group {
+			prev := &response.Groups[n-1]
+			prev.Records += g.Records
+			prev.TotalStudents += g.TotalStudents
+			prev.Grade5 += g.Grade5
+			prev.Grade4 += g.Grade4
+			prev.Grade3 += g.Grade3
+			prev.Grade2 += g.Grade2
+			prev.NotPassed += g.NotPassed
+			if prev.DepartmentID == nil {
+				prev.DepartmentID, prev.DepartmentName = g.DepartmentID, g.DepartmentName
+			}
+			continue
+		}
+		response.Groups = append(response.Groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		metrics.DBErrorsTotal.Inc("compare_scan")
+		http.Error(w, "Failed to scan groups", http.StatusInternalServerError)
+		return
+	}
+
+	table := make([][]float64, len(response.Groups))
+	var total models.StatsNode
+	for i := range response.Groups {
+		g := &response.Groups[i]
+		g.AverageScore.Value, g.SuccessRate.Value, g.QualityRate.Value = calculateAverages(g.TotalStudents, g.Grade5, g.Grade4, g.Grade3, g.Grade2)
+		counts := models.StatsNode{Records: g.Records, TotalStudents: g.TotalStudents, Grade5: g.Grade5, Grade4: g.Grade4, Grade3: g.Grade3, Grade2: g.Grade2, NotPassed: g.NotPassed}
+		addCounts(&total, counts)
+		if g.DepartmentID != nil {
+			dep, ok := departments[*g.DepartmentID]
+			if !ok {
+				dep = &models.StatsNode{}
+				departments[*g.DepartmentID] = dep
+			}
+			addCounts(dep, counts)
+		}
+		table[i] = gradeDistribution(*g)
+	}
+	for _, dep := range departments {
+		finishNode(dep)
+	}
+
+	rankMetric(response.Groups, func(g *models.GroupComparison) *models.RankedMetric { return &g.AverageScore },
+		func(id int) float64 { return departments[id].AverageScore })
+	rankMetric(response.Groups, func(g *models.GroupComparison) *models.RankedMetric { return &g.SuccessRate },
+		func(id int) float64 { return departments[id].SuccessRate })
+	rankMetric(response.Groups, func(g *models.GroupComparison) *models.RankedMetric { return &g.QualityRate },
+		func(id int) float64 { return departments[id].QualityRate })
+
+	response.ChiSquare = chiSquareTest(table, response.Alpha)
+	if len(response.Groups) > 1 {
+		for i := range response.Groups {
+			g := &response.Groups[i]
+			rest := []float64{
+				float64(total.Grade5 - g.Grade5), float64(total.Grade4 - g.Grade4), float64(total.Grade3 - g.Grade3),
+				float64(total.Grade2 - g.Grade2), float64(total.NotPassed - g.NotPassed),
+			}
+			g.VersusRest = chiSquareTest([][]float64{table[i], rest}, response.Alpha)
+		}
+	}
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,455 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"study_grade/config"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+)
+
+const settingGradeNaturalKey = "grade_natural_key"
+
+// defaultGradeKey - природний ключ за замовчуванням: той самий предмет у тієї ж групи в той самий день семестру
+var defaultGradeKey = []string{models.GradeKeySubject, models.GradeKeyGroup, models.GradeKeySemester, models.GradeKeyDate}
+
+// gradeKeyColumns - колонки grades для полів природного ключа
+var gradeKeyColumns = map[string]string{
+	models.GradeKeySubject:      "subject",
+	models.GradeKeyGroup:        "group_name",
+	models.GradeKeySemester:     "semester",
+	models.GradeKeyDate:         "date",
+	models.GradeKeyAcademicYear: "academic_year",
+	models.GradeKeyUser:         "user_id",
+}
+
+// gradeKeyValue - значення поля природного ключа запису (user_id порівнюється через <=>, тому 0 означає NULL)
+func gradeKeyValue(field string, g models.Grade) any {
+	switch field {
+	case models.GradeKeySubject:
+		return g.Subject
+	case models.GradeKeyGroup:
+		return g.Group
+	case models.GradeKeySemester:
+		return g.Semester
+	case models.GradeKeyDate:
+		return g.Date.Format(dateLayout)
+	case models.GradeKeyAcademicYear:
+		return g.AcademicYear
+	case models.GradeKeyUser:
+		if g.UserID == 0 {
+			return nil
+		}
+		return g.UserID
+	}
+	return nil
+}
+
+// parseGradeKey розбирає список полів ключа; false - є невідоме поле
+func parseGradeKey(value string) ([]string, bool) {
+	fields := []string{}
+	seen := map[string]bool{}
+	for _, f := range strings.Split(value, ",") {
+		f = strings.TrimSpace(f)
+		if f == "" || seen[f] {
+			continue
+		}
+		if _, ok := gradeKeyColumns[f]; !ok {
+			return nil, false
+		}
+		seen[f] = true
+		fields = append(fields, f)
+	}
+	return fields, true
+}
+
+// loadDuplicatePolicy повертає природний ключ установи; якщо його не задано - GRADE_NATURAL_KEY
+// (за замовчуванням subject,group,semester,date). Порожній список означає, що перевірку вимкнено.
+func loadDuplicatePolicy(ctx context.Context, institutionID int) (models.DuplicatePolicy, error) {
+	value, ok, err := getSetting(ctx, institutionID, settingGradeNaturalKey)
+	if err != nil {
+		return models.DuplicatePolicy{}, err
+	}
+	if !ok {
+		value = strings.Join(config.List("GRADE_NATURAL_KEY", defaultGradeKey), ",")
+	}
+	fields, valid := parseGradeKey(value)
+	if !valid {
+		log.Printf("Invalid grade natural key %q for institution %d, using default", value, institutionID)
+		fields = defaultGradeKey
+	}
+	return models.DuplicatePolicy{KeyFields: fields}, nil
+}
+
+// lockGradeWrites блокує рядок установи до кінця транзакції. Його беруть першим усі транзакції,
+// що змінюють записи установи: так перевірка природного ключа і запис не перетинаються
+// з паралельними змінами, а замки на grades завжди беруться в одному порядку.
+func lockGradeWrites(ctx context.Context, tx *sql.Tx, institutionID int) error {
+	var id int
+	return tx.QueryRowContext(ctx, "SELECT id FROM institutions WHERE id = ? FOR UPDATE", institutionID).Scan(&id)
+}
+
+// findDuplicate шукає інший запис установи з тим самим природним ключем і повертає його id
+// та власника; id 0 - дубліката немає.
+// Читання блокуюче, тому бачить останні зафіксовані записи незалежно від рівня ізоляції.
+func findDuplicate(ctx context.Context, tx *sql.Tx, institutionID int, fields []string, g models.Grade) (int, sql.NullInt64, error) {
+	where := []string{"institution_id = ?", "id <> ?"}
+	args := []any{institutionID, g.ID}
+	for _, f := range fields {
+		where = append(where, gradeKeyColumns[f]+" <=> ?")
+		args = append(args, gradeKeyValue(f, g))
+	}
+	var id int
+	var ownerID sql.NullInt64
+	err := tx.QueryRowContext(ctx, "SELECT id, user_id FROM grades WHERE "+strings.Join(where, " AND ")+" ORDER BY id LIMIT 1 FOR UPDATE", args...).Scan(&id, &ownerID)
+	if err == sql.ErrNoRows {
+		return 0, ownerID, nil
+	}
+	return id, ownerID, err
+}
+
+// rejectDuplicate у транзакції запису блокує записи установи і перевіряє, чи немає іншого
+// запису з тим самим природним ключем (крім самого grade.ID). Паралельний запит з тим самим
+// ключем чекає на замок і після фіксації цієї транзакції отримує 409.
+// Повертає false, якщо відповідь уже надіслано.
+func rejectDuplicate(ctx context.Context, w http.ResponseWriter, r *http.Request, tx *sql.Tx, grade models.Grade) bool {
+	// Дублікат за природним ключем установи не зберігається - клієнт отримує id існуючого запису,
+	// якщо може його прочитати (як у checkGradeAccess)
+	policy, err := loadDuplicatePolicy(ctx, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("duplicate_policy")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if err := lockGradeWrites(ctx, tx, institutionID(r)); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_lock")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if len(policy.KeyFields) == 0 {
+		return true
+	}
+	existingID, ownerID, err := findDuplicate(ctx, tx, institutionID(r), policy.KeyFields, grade)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_duplicates")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	if existingID > 0 {
+		// id чужого запису викладачу не показуємо: прочитати його він однаково не може
+		userID, _ := r.Context().Value("userID").(int)
+		if !isInstitutionAdmin(r) && (!ownerID.Valid || int(ownerID.Int64) != userID) {
+			http.Error(w, "Grade record already exists", http.StatusConflict)
+			return false
+		}
+		writeDuplicate(w, existingID, policy.KeyFields)
+		return false
+	}
+	return true
+}
+
+// writeDuplicate відповідає 409 з id існуючого запису, доступного користувачу
+func writeDuplicate(w http.ResponseWriter, existingID int, fields []string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(models.DuplicateGradeError{
+		Error:      "Grade record already exists",
+		ExistingID: existingID,
+		KeyFields:  fields,
+	})
+}
+
+// GetDuplicatePolicy повертає поля природного ключа записів установи
+func GetDuplicatePolicy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	policy, err := loadDuplicatePolicy(ctx, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("duplicate_policy")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdateDuplicatePolicy задає поля природного ключа; порожній список вимикає перевірку дублікатів
+func UpdateDuplicatePolicy(w http.ResponseWriter, r *http.Request) {
+	var policy models.DuplicatePolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	fields, ok := parseGradeKey(strings.Join(policy.KeyFields, ","))
+	if !ok {
+		http.Error(w, "key_fields must be from: "+strings.Join(models.GradeKeyFields, ", "), http.StatusBadRequest)
+		return
+	}
+	policy.KeyFields = fields
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	if err := putSetting(ctx, institutionID(r), settingGradeNaturalKey, strings.Join(fields, ",")); err != nil {
+		metrics.DBErrorsTotal.Inc("duplicate_policy")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	log.Println("Duplicate policy of institution", institutionID(r), "updated, natural key:", fields)
+	json.NewEncoder(w).Encode(policy)
+}
+
+// FindDuplicates повертає групи записів установи з однаковим природним ключем.
+// Параметр key (наприклад, subject,group,semester) замінює ключ установи для пошуку.
+func FindDuplicates(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	var fields []string
+	if key := r.URL.Query().Get("key"); key != "" {
+		var ok bool
+		if fields, ok = parseGradeKey(key); !ok {
+			http.Error(w, "key must be from: "+strings.Join(models.GradeKeyFields, ", "), http.StatusBadRequest)
+			return
+		}
+	} else {
+		policy, err := loadDuplicatePolicy(ctx, institutionID(r))
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("duplicate_policy")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		fields = policy.KeyFields
+	}
+	if len(fields) == 0 {
+		fields = defaultGradeKey
+	}
+
+	// Набір дублікатів ідентифікується найменшим id; порівняння - за правилами колацій бази
+	var keys, aliases, join []string
+	for i, f := range fields {
+		k := "k" + strconv.Itoa(i)
+		keys = append(keys, "x."+gradeKeyColumns[f]+" AS "+k)
+		aliases = append(aliases, k)
+		join = append(join, "g."+gradeKeyColumns[f]+" <=> d."+k)
+	}
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT d.set_id, `+gradeColumns+` FROM grades g
+		JOIN (
+			SELECT MIN(x.id) AS set_id, `+strings.Join(keys, ", ")+` FROM grades x WHERE x.institution_id = ?
+			GROUP BY `+strings.Join(aliases, ", ")+` HAVING COUNT(*) > 1
+		) d ON `+strings.Join(join, " AND ")+`
+		WHERE g.institution_id = ?
+		ORDER BY d.set_id, g.id`,
+		institutionID(r), institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_duplicates")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	sets := []models.DuplicateSet{}
+	lastSet := 0
+	for rows.Next() {
+		var setID int
+		g, err := scanGrade(rows, &setID)
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("grade_duplicates")
+			http.Error(w, "Failed to scan grades", http.StatusInternalServerError)
+			return
+		}
+		if setID != lastSet {
+			key := map[string]string{}
+			for _, f := range fields {
+				if value := gradeKeyValue(f, g); value != nil {
+					key[f] = fmt.Sprint(value)
+				} else {
+					key[f] = ""
+				}
+			}
+			sets = append(sets, models.DuplicateSet{Key: key})
+			lastSet = setID
+		}
+		set := &sets[len(sets)-1]
+		set.Grades = append(set.Grades, g)
+	}
+	json.NewEncoder(w).Encode(sets)
+}
+
+// MergeDuplicates зливає дублікати в запис keep_id: strategy=keep залишає його без змін,
+// strategy=sum додає до нього кількості злитих записів. Злиті записи видаляються; посилання
+// на запланований іспит переноситься до keep_id, якщо в нього свого немає.
+// Усі записи мають збігатися з keep_id за природним ключем установи.
+func MergeDuplicates(w http.ResponseWriter, r *http.Request) {
+	var req models.MergeDuplicatesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Strategy == "" {
+		req.Strategy = models.MergeKeep
+	}
+	if req.Strategy != models.MergeKeep && req.Strategy != models.MergeSum {
+		http.Error(w, "strategy must be keep or sum", http.StatusBadRequest)
+		return
+	}
+	if req.KeepID <= 0 || len(req.MergeIDs) == 0 {
+		http.Error(w, "keep_id and merge_ids are required", http.StatusBadRequest)
+		return
+	}
+	ids := []any{req.KeepID}
+	seen := map[int]bool{req.KeepID: true}
+	for _, id := range req.MergeIDs {
+		if id <= 0 || seen[id] {
+			http.Error(w, "merge_ids must be distinct and must not contain keep_id", http.StatusBadRequest)
+			return
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	in := strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ")
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	policy, err := loadDuplicatePolicy(ctx, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("duplicate_policy")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	fields := policy.KeyFields
+	if len(fields) == 0 {
+		fields = defaultGradeKey
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_merge")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockGradeWrites(ctx, tx, institutionID(r)); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_merge")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	rows, err := tx.QueryContext(ctx,
+		"SELECT "+gradeColumns+" FROM grades g WHERE g.institution_id = ? AND g.id IN ("+in+") ORDER BY g.id FOR UPDATE",
+		append([]any{institutionID(r)}, ids...)...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_merge")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var keep models.Grade
+	var merged []models.Grade
+	for rows.Next() {
+		g, err := scanGrade(rows)
+		if err != nil {
+			rows.Close()
+			metrics.DBErrorsTotal.Inc("grade_merge")
+			http.Error(w, "Failed to scan grades", http.StatusInternalServerError)
+			return
+		}
+		if g.ID == req.KeepID {
+			keep = g
+		} else {
+			merged = append(merged, g)
+		}
+	}
+	rows.Close()
+	if keep.ID == 0 || len(merged) != len(req.MergeIDs) {
+		http.Error(w, "Grade not found", http.StatusNotFound)
+		return
+	}
+
+	// Збіг ключа перевіряє база, щоб порівняння було таким самим, як при пошуку дублікатів
+	where := []string{"institution_id = ?", "id IN (" + in + ")"}
+	args := append([]any{institutionID(r)}, ids...)
+	for _, f := range fields {
+		where = append(where, gradeKeyColumns[f]+" <=> ?")
+		args = append(args, gradeKeyValue(f, keep))
+	}
+	var matching int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM grades WHERE "+strings.Join(where, " AND "), args...).Scan(&matching); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_merge")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if matching != len(ids) {
+		http.Error(w, "Records do not share the natural key ("+strings.Join(fields, ", ")+")", http.StatusBadRequest)
+		return
+	}
+
+	response := models.MergeDuplicatesResponse{Merged: []int{}}
+	for _, g := range merged {
+		if req.Strategy == models.MergeSum {
+			keep.TotalStudents += g.TotalStudents
+			keep.Grade5 += g.Grade5
+			keep.Grade4 += g.Grade4
+			keep.Grade3 += g.Grade3
+			keep.Grade2 += g.Grade2
+			keep.NotPassed += g.NotPassed
+		}
+		if keep.ExamID == nil && g.ExamID != nil {
+			keep.ExamID = g.ExamID
+		}
+		response.Merged = append(response.Merged, g.ID)
+	}
+	keep.AverageScore, keep.SuccessRate, keep.QualityRate = calculateAverages(keep.TotalStudents, keep.Grade5, keep.Grade4, keep.Grade3, keep.Grade2)
+
+	if err := recordBaseline(ctx, tx, institutionID(r), keep); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_revision_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Видалення спершу звільняє унікальний exam_id злитого запису
+	if _, err := tx.ExecContext(ctx, "DELETE FROM grades WHERE institution_id = ? AND id IN ("+in+") AND id <> ?",
+		append(append([]any{institutionID(r)}, ids...), req.KeepID)...); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_merge")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE grades SET total_students = ?, grade_5 = ?, grade_4 = ?, grade_3 = ?, grade_2 = ?, not_passed = ?, average_score = ?, success_rate = ?, quality_rate = ?, exam_id = ?, version = version + 1 WHERE id = ?",
+		keep.TotalStudents, keep.Grade5, keep.Grade4, keep.Grade3, keep.Grade2, keep.NotPassed, keep.AverageScore, keep.SuccessRate, keep.QualityRate, keep.ExamID, keep.ID); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_merge")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	userID, _ := r.Context().Value("userID").(int)
+	for _, g := range merged {
+		if err := recordDeletion(ctx, tx, institutionID(r), g, userID); err != nil {
+			metrics.DBErrorsTotal.Inc("grade_revision_insert")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	}
+	keep.Version++
+	if err := recordRevision(ctx, tx, institutionID(r), keep, models.RevisionMerge, nil, userID); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_revision_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_merge")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Merged grade records %v into %d (strategy %s)", response.Merged, keep.ID, req.Strategy)
+	response.Grade = keep
+	json.NewEncoder(w).Encode(response)
+}
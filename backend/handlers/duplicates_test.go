@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"study_grade/models"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Перевірка природного ключа і вставка виконуються в одній транзакції під замком установи:
+// другий із паралельних запитів чекає на замок і бачить запис першого
+func TestCreateGradeChecksDuplicateInsideTransaction(t *testing.T) {
+	mock := mockTenantDB(t)
+	mock.ExpectQuery(`SELECT name FROM academic_years WHERE institution_id = \?`).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT value FROM settings WHERE institution_id = \? AND name = \?`).
+		WithArgs(ownInstitution, settingGradeNaturalKey).WillReturnRows(sqlmock.NewRows([]string{"value"}))
+	mock.ExpectQuery(`SELECT id FROM institutions WHERE id = \? FOR UPDATE`).
+		WithArgs(ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(ownInstitution))
+	mock.ExpectQuery(`SELECT id, user_id FROM grades WHERE institution_id = \? AND id <> \? .+ FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(7, foreignUserID))
+	mock.ExpectRollback()
+
+	w := httptest.NewRecorder()
+	CreateGrade(w, tenantRequest("POST", "/api/grades", validGradeBody, nil))
+
+	if w.Code != http.StatusConflict {
+		t.Fatalf("got status %d, want 409: %s", w.Code, w.Body.String())
+	}
+	var resp models.DuplicateGradeError
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.ExistingID != 7 {
+		t.Errorf("got %+v (%v), want existing_id 7", resp, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Викладач не дізнається id чужого запису-дубліката: лише адміністратор або власник
+func TestCreateGradeHidesForeignDuplicateFromTeacher(t *testing.T) {
+	tests := []struct {
+		name   string
+		owner  any
+		wantID int
+	}{
+		{name: "own record", owner: callerID, wantID: 7},
+		{name: "colleague's record", owner: foreignUserID},
+		{name: "orphaned record", owner: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockTenantDB(t)
+			mock.ExpectQuery(`SELECT name FROM academic_years WHERE institution_id = \?`).
+				WillReturnRows(sqlmock.NewRows([]string{"name"}))
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT value FROM settings WHERE institution_id = \? AND name = \?`).
+				WithArgs(ownInstitution, settingGradeNaturalKey).WillReturnRows(sqlmock.NewRows([]string{"value"}))
+			mock.ExpectQuery(`SELECT id FROM institutions WHERE id = \? FOR UPDATE`).
+				WithArgs(ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(ownInstitution))
+			mock.ExpectQuery(`SELECT id, user_id FROM grades WHERE institution_id = \? AND id <> \? .+ FOR UPDATE`).
+				WillReturnRows(sqlmock.NewRows([]string{"id", "user_id"}).AddRow(7, tt.owner))
+			mock.ExpectRollback()
+
+			r := tenantRequest("POST", "/api/grades", validGradeBody, nil)
+			r = r.WithContext(context.WithValue(r.Context(), "userRole", models.RoleTeacher))
+			w := httptest.NewRecorder()
+			CreateGrade(w, r)
+
+			if w.Code != http.StatusConflict {
+				t.Fatalf("got status %d, want 409: %s", w.Code, w.Body.String())
+			}
+			if tt.wantID == 0 {
+				if strings.Contains(w.Body.String(), "existing_id") {
+					t.Errorf("response reveals the existing record: %s", w.Body.String())
+				}
+			} else {
+				var resp models.DuplicateGradeError
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil || resp.ExistingID != tt.wantID {
+					t.Errorf("got %+v (%v), want existing_id %d", resp, err, tt.wantID)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -0,0 +1,493 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultExamDuration = 90
+	maxExamDuration     = 600
+	// calendarHistory - наскільки давні іспити ще потрапляють у стрічку .ics
+	calendarHistory = 180 * 24 * time.Hour
+)
+
+// examSelect - вибірка іспиту з іменем екзаменатора і пов'язаним записом оцінок (порядок scanExam)
+const examSelect = `
+	SELECT e.id, e.subject, e.group_name, e.starts_at, e.duration_minutes, e.room, e.examiner_id, COALESCE(u.username, ''), gr.id
+	FROM exams e
+	LEFT JOIN users u ON u.id = e.examiner_id
+	LEFT JOIN grades gr ON gr.exam_id = e.id`
+
+func scanExam(row interface{ Scan(...any) error }) (models.Exam, error) {
+	var e models.Exam
+	var examinerID, gradeID sql.NullInt64
+	err := row.Scan(&e.ID, &e.Subject, &e.Group, &e.StartsAt, &e.DurationMinutes, &e.Room, &examinerID, &e.ExaminerName, &gradeID)
+	e.ExaminerID, e.GradeID = nullIntPtr(examinerID), nullIntPtr(gradeID)
+	return e, err
+}
+
+// isInstitutionAdmin - адміністратор може планувати іспити для будь-якого екзаменатора установи
+func isInstitutionAdmin(r *http.Request) bool {
+	role, _ := r.Context().Value("userRole").(string)
+	return role == models.RoleAdmin || role == models.RoleSuperadmin
+}
+
+// examSchedule перевіряє час, тривалість і аудиторію іспиту
+func examSchedule(e *models.Exam) string {
+	e.Room = strings.TrimSpace(e.Room)
+	if e.StartsAt.IsZero() {
+		return "starts_at is required"
+	}
+	if e.DurationMinutes == 0 {
+		e.DurationMinutes = defaultExamDuration
+	}
+	if e.DurationMinutes < 1 || e.DurationMinutes > maxExamDuration {
+		return fmt.Sprintf("duration_minutes must be between 1 and %d", maxExamDuration)
+	}
+	if len(e.Room) > 50 {
+		return "Room must be at most 50 characters"
+	}
+	e.StartsAt = e.StartsAt.UTC().Truncate(time.Minute)
+	return ""
+}
+
+// scheduleConflict шукає іспит, що перетинається в часі з тією ж групою, аудиторією або екзаменатором.
+// Повертає текст для відповіді 409 або порожній рядок.
+func scheduleConflict(ctx context.Context, institutionID int, e models.Exam) (string, error) {
+	var group, room, examiner bool
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT COALESCE(MAX(group_name = ?), 0), COALESCE(MAX(room <> '' AND room = ?), 0), COALESCE(MAX(examiner_id <=> ? AND examiner_id IS NOT NULL), 0)
+		FROM exams
+		WHERE institution_id = ? AND id <> ? AND starts_at < ? AND DATE_ADD(starts_at, INTERVAL duration_minutes MINUTE) > ?`,
+		e.Group, e.Room, e.ExaminerID, institutionID, e.ID,
+		e.StartsAt.Add(time.Duration(e.DurationMinutes)*time.Minute), e.StartsAt,
+	).Scan(&group, &room, &examiner)
+	switch {
+	case err != nil:
+		return "", err
+	case group:
+		return "Group already has an exam at this time", nil
+	case room:
+		return "Room is already booked at this time", nil
+	case examiner:
+		return "Examiner already has an exam at this time", nil
+	}
+	return "", nil
+}
+
+// loadExam читає іспит установи; false - відповідь уже надіслано
+func loadExam(ctx context.Context, w http.ResponseWriter, r *http.Request, id int) (models.Exam, *int, bool) {
+	var createdBy sql.NullInt64
+	var e models.Exam
+	var examinerID, gradeID sql.NullInt64
+	err := db.DB.QueryRowContext(ctx, `
+		SELECT e.id, e.subject, e.group_name, e.starts_at, e.duration_minutes, e.room, e.examiner_id, COALESCE(u.username, ''), gr.id, e.created_by
+		FROM exams e
+		LEFT JOIN users u ON u.id = e.examiner_id
+		LEFT JOIN grades gr ON gr.exam_id = e.id
+		WHERE e.id = ? AND e.institution_id = ?`, id, institutionID(r),
+	).Scan(&e.ID, &e.Subject, &e.Group, &e.StartsAt, &e.DurationMinutes, &e.Room, &examinerID, &e.ExaminerName, &gradeID, &createdBy)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Exam not found", http.StatusNotFound)
+		return e, nil, false
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("exam_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return e, nil, false
+	}
+	e.ExaminerID, e.GradeID = nullIntPtr(examinerID), nullIntPtr(gradeID)
+	return e, nullIntPtr(createdBy), true
+}
+
+// canManageExam - змінювати іспит можуть адміністратори, його екзаменатор і той, хто його запланував
+func canManageExam(r *http.Request, e models.Exam, createdBy *int) bool {
+	if isInstitutionAdmin(r) {
+		return true
+	}
+	userID, _ := r.Context().Value("userID").(int)
+	return (e.ExaminerID != nil && *e.ExaminerID == userID) || (createdBy != nil && *createdBy == userID)
+}
+
+// ListExams повертає розклад іспитів установи.
+// Фільтри: group, examiner (id), from і to (YYYY-MM-DD), pending=true - лише іспити без результатів.
+func ListExams(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where := []string{"e.institution_id = ?"}
+	args := []any{institutionID(r)}
+	if group := q.Get("group"); group != "" {
+		where = append(where, "e.group_name = ?")
+		args = append(args, group)
+	}
+	if s := q.Get("examiner"); s != "" {
+		examinerID, err := strconv.Atoi(s)
+		if err != nil {
+			http.Error(w, "Invalid examiner", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "e.examiner_id = ?")
+		args = append(args, examinerID)
+	}
+	if s := q.Get("from"); s != "" {
+		from, err := time.Parse(dateLayout, s)
+		if err != nil {
+			http.Error(w, "from must be a date in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "e.starts_at >= ?")
+		args = append(args, from)
+	}
+	if s := q.Get("to"); s != "" {
+		to, err := time.Parse(dateLayout, s)
+		if err != nil {
+			http.Error(w, "to must be a date in YYYY-MM-DD format", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "e.starts_at < ?")
+		args = append(args, to.AddDate(0, 0, 1))
+	}
+	if s := q.Get("pending"); s != "" {
+		pending, err := strconv.ParseBool(s)
+		if err != nil {
+			http.Error(w, "Invalid pending filter", http.StatusBadRequest)
+			return
+		}
+		if pending {
+			where = append(where, "gr.id IS NULL")
+		} else {
+			where = append(where, "gr.id IS NOT NULL")
+		}
+	}
+	limit, offset, ok := pagination(q.Get("limit"), q.Get("offset"))
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx,
+		examSelect+" WHERE "+strings.Join(where, " AND ")+" ORDER BY e.starts_at, e.id LIMIT ? OFFSET ?",
+		append(args, limit, offset)...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("exam_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	exams := []models.Exam{}
+	for rows.Next() {
+		e, err := scanExam(rows)
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("exam_scan")
+			http.Error(w, "Failed to scan exams", http.StatusInternalServerError)
+			return
+		}
+		exams = append(exams, e)
+	}
+	json.NewEncoder(w).Encode(exams)
+}
+
+// CreateExam планує іспит. Викладач планує лише власні іспити, адміністратор - для будь-якого екзаменатора установи.
+func CreateExam(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var e models.Exam
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	e.ID, e.GradeID, e.ExaminerName = 0, nil, ""
+	e.Subject, e.Group = strings.TrimSpace(e.Subject), strings.TrimSpace(e.Group)
+	if e.Subject == "" || len(e.Subject) > 100 || e.Group == "" || len(e.Group) > 50 {
+		http.Error(w, "Subject (1-100 characters) and group (1-50 characters) are required", http.StatusBadRequest)
+		return
+	}
+	if msg := examSchedule(&e); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if e.ExaminerID == nil || *e.ExaminerID <= 0 {
+		e.ExaminerID = &userID
+	}
+	if *e.ExaminerID != userID && !isInstitutionAdmin(r) {
+		http.Error(w, "Teachers can only schedule their own exams", http.StatusForbidden)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	examinerID, ok := optionalParent(ctx, w, r, "users", e.ExaminerID, "Examiner not found")
+	if !ok {
+		return
+	}
+	conflict, err := scheduleConflict(ctx, institutionID(r), e)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("exam_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if conflict != "" {
+		http.Error(w, conflict, http.StatusConflict)
+		return
+	}
+	result, err := db.DB.ExecContext(ctx,
+		"INSERT INTO exams (institution_id, subject, group_name, starts_at, duration_minutes, room, examiner_id, created_by) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		institutionID(r), e.Subject, e.Group, e.StartsAt, e.DurationMinutes, e.Room, examinerID, userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("exam_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	created, err := scanExam(db.DB.QueryRowContext(ctx, examSelect+" WHERE e.id = ?", id))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("exam_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// UpdateExam переносить іспит: час, тривалість, аудиторія або екзаменатор
+func UpdateExam(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid exam ID", http.StatusBadRequest)
+		return
+	}
+	var req models.UpdateExamRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.StartsAt == nil && req.DurationMinutes == nil && req.Room == nil && req.ExaminerID == nil {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	e, createdBy, ok := loadExam(ctx, w, r, id)
+	if !ok {
+		return
+	}
+	if !canManageExam(r, e, createdBy) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if req.StartsAt != nil {
+		e.StartsAt = *req.StartsAt
+	}
+	if req.DurationMinutes != nil {
+		if *req.DurationMinutes == 0 {
+			http.Error(w, fmt.Sprintf("duration_minutes must be between 1 and %d", maxExamDuration), http.StatusBadRequest)
+			return
+		}
+		e.DurationMinutes = *req.DurationMinutes
+	}
+	if req.Room != nil {
+		e.Room = *req.Room
+	}
+	if msg := examSchedule(&e); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	if req.ExaminerID != nil {
+		userID, _ := r.Context().Value("userID").(int)
+		if *req.ExaminerID != userID && !isInstitutionAdmin(r) {
+			http.Error(w, "Teachers can only schedule their own exams", http.StatusForbidden)
+			return
+		}
+		e.ExaminerID = req.ExaminerID
+	}
+	examinerID, ok := optionalParent(ctx, w, r, "users", e.ExaminerID, "Examiner not found")
+	if !ok {
+		return
+	}
+	e.ExaminerID = nullIntPtr(examinerID)
+
+	conflict, err := scheduleConflict(ctx, institutionID(r), e)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("exam_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if conflict != "" {
+		http.Error(w, conflict, http.StatusConflict)
+		return
+	}
+	if _, err := db.DB.ExecContext(ctx,
+		"UPDATE exams SET starts_at = ?, duration_minutes = ?, room = ?, examiner_id = ? WHERE id = ?",
+		e.StartsAt, e.DurationMinutes, e.Room, examinerID, id); err != nil {
+		metrics.DBErrorsTotal.Inc("exam_update")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	updated, err := scanExam(db.DB.QueryRowContext(ctx, examSelect+" WHERE e.id = ?", id))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("exam_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(updated)
+}
+
+// DeleteExam скасовує іспит; пов'язаний запис оцінок залишається без посилання
+func DeleteExam(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid exam ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	e, createdBy, ok := loadExam(ctx, w, r, id)
+	if !ok {
+		return
+	}
+	if !canManageExam(r, e, createdBy) {
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM exams WHERE id = ?", id); err != nil {
+		metrics.DBErrorsTotal.Inc("exam_delete")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExamCalendar віддає розклад групи (group) або екзаменатора (examiner) у форматі iCalendar (RFC 5545)
+// Календарні застосунки підписуються за URL з ?token= - персональним токеном з областю calendar.
+func ExamCalendar(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	group, examiner := q.Get("group"), q.Get("examiner")
+	if (group == "") == (examiner == "") {
+		http.Error(w, "Exactly one of group or examiner is required", http.StatusBadRequest)
+		return
+	}
+	where := []string{"e.institution_id = ?", "e.starts_at >= ?"}
+	args := []any{institutionID(r), time.Now().Add(-calendarHistory)}
+	name := "Exams: " + group
+	if group != "" {
+		where = append(where, "e.group_name = ?")
+		args = append(args, group)
+	} else {
+		examinerID, err := strconv.Atoi(examiner)
+		if err != nil {
+			http.Error(w, "Invalid examiner", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "e.examiner_id = ?")
+		args = append(args, examinerID)
+		name = ""
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, examSelect+" WHERE "+strings.Join(where, " AND ")+" ORDER BY e.starts_at, e.id", args...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("exam_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	var exams []models.Exam
+	for rows.Next() {
+		e, err := scanExam(rows)
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("exam_scan")
+			http.Error(w, "Failed to scan exams", http.StatusInternalServerError)
+			return
+		}
+		if name == "" && e.ExaminerName != "" {
+			name = "Exams: " + e.ExaminerName
+		}
+		exams = append(exams, e)
+	}
+	if name == "" {
+		name = "Exams"
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="exams.ics"`)
+	w.Write([]byte(examCalendar(name, institutionID(r), exams, time.Now())))
+}
+
+// examCalendar формує VCALENDAR з подією на кожен іспит
+func examCalendar(name string, institutionID int, exams []models.Exam, now time.Time) string {
+	const stamp = "20060102T150405Z"
+	var b strings.Builder
+	line := func(l string) { b.WriteString(icsFold(l)) }
+	line("BEGIN:VCALENDAR")
+	line("VERSION:2.0")
+	line("PRODID:-//study_grade//Exam schedule//EN")
+	line("CALSCALE:GREGORIAN")
+	line("METHOD:PUBLISH")
+	line("X-WR-CALNAME:" + icsEscape(name))
+	for _, e := range exams {
+		line("BEGIN:VEVENT")
+		line(fmt.Sprintf("UID:exam-%d-%d@study_grade", institutionID, e.ID))
+		line("DTSTAMP:" + now.UTC().Format(stamp))
+		line("DTSTART:" + e.StartsAt.UTC().Format(stamp))
+		line("DTEND:" + e.StartsAt.Add(time.Duration(e.DurationMinutes)*time.Minute).UTC().Format(stamp))
+		line("SUMMARY:" + icsEscape(e.Subject+" ("+e.Group+")"))
+		if e.Room != "" {
+			line("LOCATION:" + icsEscape(e.Room))
+		}
+		if e.ExaminerName != "" {
+			line("DESCRIPTION:" + icsEscape("Examiner: "+e.ExaminerName))
+		}
+		line("END:VEVENT")
+	}
+	line("END:VCALENDAR")
+	return b.String()
+}
+
+var icsEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func icsEscape(s string) string {
+	return icsEscaper.Replace(s)
+}
+
+// icsFold розбиває рядок на частини до 75 байт (без розриву символів UTF-8) і завершує його CRLF
+func icsFold(l string) string {
+	var b strings.Builder
+	limit := 75
+	for len(l) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(l[cut]) {
+			cut--
+		}
+		b.WriteString(l[:cut] + "\r\n ")
+		l = l[cut:]
+		// Пробіл на початку продовження теж рахується
+		limit = 74
+	}
+	b.WriteString(l + "\r\n")
+	return b.String()
+}
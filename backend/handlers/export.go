@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"net/http"
+	"strconv"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+)
+
+var exportHeader = []string{
+	"id", "academic_year", "semester", "date", "subject", "group", "total_students",
+	"grade_5", "grade_4", "grade_3", "grade_2", "not_passed", "average_score", "success_rate", "quality_rate",
+}
+
+// ExportGrades віддає записи користувача у CSV з тими ж фільтрами academic_year і semester, що й GetGrades
+func ExportGrades(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	where, args, msg := periodFilter(r.URL.Query(), []string{"g.user_id = ?", "g.institution_id = ?"}, []any{userID, institutionID(r)})
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT "+gradeColumns+" FROM grades g WHERE "+strings.Join(where, " AND ")+" ORDER BY g.academic_year, g.semester, g.date, g.id",
+		args...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grades_export")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", `attachment; filename="grades.csv"`)
+	out := csv.NewWriter(w)
+	out.Write(exportHeader)
+	for rows.Next() {
+		g, err := scanGrade(rows)
+		if err != nil {
+			// Заголовки вже надіслано - відповідь лише обривається
+			metrics.DBErrorsTotal.Inc("grades_export")
+			break
+		}
+		out.Write([]string{
+			strconv.Itoa(g.ID), g.AcademicYear, strconv.Itoa(g.Semester), g.Date.Format(dateLayout), g.Subject, g.Group,
+			strconv.Itoa(g.TotalStudents), strconv.Itoa(g.Grade5), strconv.Itoa(g.Grade4), strconv.Itoa(g.Grade3),
+			strconv.Itoa(g.Grade2), strconv.Itoa(g.NotPassed),
+			strconv.FormatFloat(g.AverageScore, 'f', 2, 64), strconv.FormatFloat(g.SuccessRate, 'f', 2, 64),
+			strconv.FormatFloat(g.QualityRate, 'f', 2, 64),
+		})
+	}
+	out.Flush()
+}
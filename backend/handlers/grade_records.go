@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+)
+
+// gradeETag - сильний ETag запису: змінюється з кожною версією
+func gradeETag(g models.Grade) string {
+	return fmt.Sprintf(`"%d-%d"`, g.ID, g.Version)
+}
+
+// etagMatches перевіряє, чи є etag у списку з If-Match / If-None-Match ("*" відповідає будь-якому).
+// weak=true порівнює без урахування префікса W/ (як вимагає RFC 9110 для If-None-Match).
+func etagMatches(header, etag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate, etag = strings.TrimPrefix(candidate, "W/"), strings.TrimPrefix(etag, "W/")
+		} else if strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// notModified відповідає 304, якщо клієнт уже має поточну версію
+func notModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "private, no-cache")
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return true
+	}
+	return false
+}
+
+// writeJSONWithETag віддає JSON зі слабким ETag від вмісту відповіді або 304
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, v any) {
+	var buf bytes.Buffer
+	json.NewEncoder(&buf).Encode(v)
+	sum := sha256.Sum256(buf.Bytes())
+	if notModified(w, r, `W/"`+hex.EncodeToString(sum[:16])+`"`) {
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// loadGrade читає запис установи, доступний користувачу: власний або будь-який для адміністратора.
+// false - відповідь уже надіслано.
+func loadGrade(ctx context.Context, w http.ResponseWriter, r *http.Request, id int) (models.Grade, bool) {
+	g, err := scanGrade(db.DB.QueryRowContext(ctx, "SELECT "+gradeColumns+" FROM grades g WHERE g.id = ? AND g.institution_id = ?", id, institutionID(r)))
+	return g, checkGradeAccess(w, r, g, err)
+}
+
+// loadGradeOrDeleted - як loadGrade, але для видаленого запису повертає знімок з його ревізії delete
+// (deleted = true, Version - версія цієї ревізії), щоб історію і відновлення було видно після видалення
+func loadGradeOrDeleted(ctx context.Context, w http.ResponseWriter, r *http.Request, id int) (g models.Grade, deleted bool, ok bool) {
+	g, err := scanGrade(db.DB.QueryRowContext(ctx, "SELECT "+gradeColumns+" FROM grades g WHERE g.id = ? AND g.institution_id = ?", id, institutionID(r)))
+	if err == sql.ErrNoRows {
+		g, err = loadDeletedGrade(ctx, institutionID(r), id)
+		deleted = err == nil
+	}
+	return g, deleted, checkGradeAccess(w, r, g, err)
+}
+
+// checkGradeAccess відповідає 404 на відсутній або чужий (для викладача) запис
+func checkGradeAccess(w http.ResponseWriter, r *http.Request, g models.Grade, err error) bool {
+	userID, _ := r.Context().Value("userID").(int)
+	if err == sql.ErrNoRows || (err == nil && g.UserID != userID && !isInstitutionAdmin(r)) {
+		http.Error(w, "Grade not found", http.StatusNotFound)
+		return false
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return false
+	}
+	return true
+}
+
+// requireIfMatch перевіряє If-Match для зміни запису: без заголовка - 428, застаріла версія - 412
+func requireIfMatch(w http.ResponseWriter, r *http.Request, g models.Grade) bool {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		http.Error(w, "If-Match header with the record ETag is required", http.StatusPreconditionRequired)
+		return false
+	}
+	if !etagMatches(ifMatch, gradeETag(g), false) {
+		w.Header().Set("ETag", gradeETag(g))
+		http.Error(w, "Grade record was modified by someone else", http.StatusPreconditionFailed)
+		return false
+	}
+	return true
+}
+
+// GetGrade повертає запис з ETag; If-None-Match з поточною версією - 304
+func GetGrade(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid grade ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	g, ok := loadGrade(ctx, w, r, id)
+	if !ok {
+		return
+	}
+	if notModified(w, r, gradeETag(g)) {
+		return
+	}
+	json.NewEncoder(w).Encode(g)
+}
+
+// UpdateGrade замінює дані запису. Потрібен If-Match з ETag версії, яку редагував клієнт:
+// якщо запис тим часом змінили - 412, і клієнт має перечитати його.
+func UpdateGrade(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid grade ID", http.StatusBadRequest)
+		return
+	}
+	var grade models.Grade
+	if err := json.NewDecoder(r.Body).Decode(&grade); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if msg := validateGrade(&grade); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	current, ok := loadGrade(ctx, w, r, id)
+	if !ok || !requireIfMatch(w, r, current) {
+		return
+	}
+	grade.ID, grade.UserID = current.ID, current.UserID
+	if grade.AcademicYear == current.AcademicYear && !grade.Date.Equal(current.Date) {
+		// Рік попередньої дати не заважає перенести запис на іншу дату
+		grade.AcademicYear = ""
+	}
+	examID, ok := prepareGrade(ctx, w, r, &grade)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_update")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if !rejectDuplicate(ctx, w, r, tx, grade) {
+		return
+	}
+	if err := recordBaseline(ctx, tx, institutionID(r), current); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_revision_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	result, err := updateGradeRow(ctx, tx, grade, examID, current.Version)
+	if isDuplicateKey(err) {
+		http.Error(w, "Exam already has a grade record", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_update")
+		http.Error(w, "Failed to save grade", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Grade record was modified by someone else", http.StatusPreconditionFailed)
+		return
+	}
+	grade.Version = current.Version + 1
+	userID, _ := r.Context().Value("userID").(int)
+	if err := recordRevision(ctx, tx, institutionID(r), grade, models.RevisionUpdate, nil, userID); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_revision_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_update")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Grade %d updated to version %d", id, grade.Version)
+
+	// Змінений запис перевіряється так само, як новий
+	grade.Anomalies = flagAnomalies(ctx, institutionID(r), grade)
+	grade.BelowTarget = checkThresholds(ctx, institutionID(r), grade)
+
+	w.Header().Set("ETag", gradeETag(grade))
+	json.NewEncoder(w).Encode(grade)
+}
+
+// updateGradeRow записує дані наступної версії запису; умова на версію робить перевірку
+// і запис атомарними (0 змінених рядків - запис тим часом змінили)
+func updateGradeRow(ctx context.Context, ex execer, grade models.Grade, examID sql.NullInt64, version int) (sql.Result, error) {
+	return ex.ExecContext(ctx,
+		"UPDATE grades SET date = ?, semester = ?, subject = ?, group_name = ?, total_students = ?, grade_5 = ?, grade_4 = ?, grade_3 = ?, grade_2 = ?, not_passed = ?, average_score = ?, success_rate = ?, quality_rate = ?, academic_year = ?, exam_id = ?, version = version + 1 WHERE id = ? AND version = ?",
+		grade.Date, grade.Semester, grade.Subject, grade.Group, grade.TotalStudents, grade.Grade5, grade.Grade4, grade.Grade3, grade.Grade2, grade.NotPassed, grade.AverageScore, grade.SuccessRate, grade.QualityRate, grade.AcademicYear, examID, grade.ID, version)
+}
+
+// DeleteGrade видаляє запис; як і для оновлення, потрібен If-Match з поточним ETag.
+// Останній стан зберігається в історії як ревізія delete, з якої запис можна відновити.
+func DeleteGrade(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid grade ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	current, ok := loadGrade(ctx, w, r, id)
+	if !ok || !requireIfMatch(w, r, current) {
+		return
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_delete")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := lockGradeWrites(ctx, tx, institutionID(r)); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_delete")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM grades WHERE id = ? AND institution_id = ? AND version = ?", id, institutionID(r), current.Version)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_delete")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Grade record was modified by someone else", http.StatusPreconditionFailed)
+		return
+	}
+	userID, _ := r.Context().Value("userID").(int)
+	if err := recordDeletion(ctx, tx, institutionID(r), current, userID); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_revision_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_delete")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Grade %d deleted at version %d", id, current.Version+1)
+	w.WriteHeader(http.StatusNoContent)
+}
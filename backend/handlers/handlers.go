@@ -1,16 +1,20 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
-	"io"
+	"errors"
 	"log"
 	"net/http"
 	"strings"
+	"study_grade/auth"
 	"study_grade/db"
+	"study_grade/metrics"
 	"study_grade/models"
+	"study_grade/ratelimit"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/go-playground/validator/v10"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -28,6 +32,19 @@ func Register(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Println("Received register request with username:", req.Username)
 
+	mode := registrationMode()
+	invited := strings.TrimSpace(req.InviteCode) != ""
+	if mode == models.RegistrationClosed {
+		log.Println("Registration rejected: registration is closed")
+		http.Error(w, "Registration is closed", http.StatusForbidden)
+		return
+	}
+	if mode == models.RegistrationInvite && !invited {
+		log.Println("Registration rejected: invitation code required")
+		http.Error(w, "Invitation code required", http.StatusForbidden)
+		return
+	}
+
 	// Валідація даних
 	req.Username = strings.TrimSpace(req.Username)
 	req.Password = strings.TrimSpace(req.Password)
@@ -36,16 +53,97 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Username must be 3-50 characters", http.StatusBadRequest)
 		return
 	}
-	if len(req.Password) < 8 {
-		log.Println("Validation failed: Password too short")
-		http.Error(w, "Password must be at least 8 characters", http.StatusBadRequest)
+	if msg := passwordError(req.Password); msg != "" {
+		log.Println("Validation failed:", msg)
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	req.Email = strings.TrimSpace(req.Email)
+	if req.Email != "" {
+		if err := validate.Var(req.Email, "email,max=255"); err != nil {
+			log.Println("Validation failed: Invalid email")
+			http.Error(w, "Invalid email address", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Хешування пароля
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		log.Println("Failed to hash password:", err)
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Failed to begin registration transaction:", err)
+		metrics.DBErrorsTotal.Inc("register_insert_user")
+		http.Error(w, "Failed to register user", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	// Запрошення і режим установи перевіряються до будь-яких запитів про користувачів, щоб без
+	// права на реєстрацію не можна було дізнатися, які імена зайняті. Запрошення блокується до
+	// кінця транзакції. Установа з кодом запрошення береться з нього, інакше - з запиту
+	// (за замовчуванням основна), і приєднатися до неї можна лише в режимі open.
+	user := models.User{Username: req.Username, Email: req.Email, Role: models.RoleTeacher, InstitutionID: db.DefaultInstitutionID}
+	var inv claimedInvitation
+	if invited {
+		inv, err = lockInvitation(ctx, tx, req.InviteCode)
+		if err == errInvalidInvitation {
+			log.Println("Registration rejected: invalid invitation code")
+			http.Error(w, "Invalid, used or expired invitation code", http.StatusForbidden)
+			return
+		}
+		if err != nil {
+			log.Println("Failed to check invitation:", err)
+			metrics.DBErrorsTotal.Inc("register_claim_invitation")
+			http.Error(w, "Failed to register user", http.StatusInternalServerError)
+			return
+		}
+		user.Role, user.DepartmentID, user.InstitutionID = inv.role, inv.departmentID, inv.institutionID
+	} else {
+		id, err := resolveInstitution(ctx, req.Institution)
+		if err == sql.ErrNoRows {
+			log.Println("Registration rejected: unknown institution", req.Institution)
+			http.Error(w, "Unknown institution", http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			log.Println("Database error during institution lookup:", err)
+			metrics.DBErrorsTotal.Inc("register_select_institution")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		user.InstitutionID = id
+	}
+	instMode, err := institutionRegistrationMode(ctx, user.InstitutionID)
+	if err != nil {
+		log.Println("Database error during registration mode lookup:", err)
+		metrics.DBErrorsTotal.Inc("register_select_institution")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if instMode == models.RegistrationClosed {
+		log.Println("Registration rejected: registration is closed for institution", user.InstitutionID)
+		http.Error(w, "Registration is closed", http.StatusForbidden)
+		return
+	}
+	if instMode == models.RegistrationInvite && !invited {
+		log.Println("Registration rejected: institution", user.InstitutionID, "requires an invitation")
+		http.Error(w, "Invitation code required", http.StatusForbidden)
 		return
 	}
 
 	// Перевірка унікальності
 	var exists bool
-	if err := db.DB.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", req.Username).Scan(&exists); err != nil {
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", req.Username).Scan(&exists); err != nil {
 		log.Println("Database error during username check:", err)
+		metrics.DBErrorsTotal.Inc("register_check_username")
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
@@ -55,26 +153,38 @@ func Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Хешування пароля
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		log.Println("Failed to hash password:", err)
-		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+	// Збереження користувача (разом із використанням запрошення, якщо воно потрібне)
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO users (username, password, email, role, department_id, institution_id) VALUES (?, ?, ?, ?, ?, ?)",
+		req.Username, hashedPassword, sql.NullString{String: req.Email, Valid: req.Email != ""}, user.Role, user.DepartmentID, user.InstitutionID)
+	if isDuplicateKey(err) {
+		log.Println("Registration rejected: username or email already taken")
+		http.Error(w, "Username or email already taken", http.StatusBadRequest)
 		return
 	}
-
-	// Збереження користувача
-	result, err := db.DB.Exec("INSERT INTO users (username, password) VALUES (?, ?)", req.Username, hashedPassword)
 	if err != nil {
 		log.Println("Failed to insert user:", err)
+		metrics.DBErrorsTotal.Inc("register_insert_user")
 		http.Error(w, "Failed to register user", http.StatusInternalServerError)
 		return
 	}
-
 	userID, _ := result.LastInsertId()
-	user := models.User{
-		ID:       int(userID),
-		Username: req.Username,
+	user.ID = int(userID)
+
+	if invited {
+		if err := markInvitationUsed(ctx, tx, inv, user.ID); err != nil {
+			log.Println("Failed to apply invitation:", err)
+			metrics.DBErrorsTotal.Inc("register_claim_invitation")
+			http.Error(w, "Failed to register user", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println("Failed to commit registration:", err)
+		metrics.DBErrorsTotal.Inc("register_insert_user")
+		http.Error(w, "Failed to register user", http.StatusInternalServerError)
+		return
 	}
 
 	w.WriteHeader(http.StatusCreated)
@@ -85,25 +195,12 @@ func Register(w http.ResponseWriter, r *http.Request) {
 func Login(w http.ResponseWriter, r *http.Request) {
 	log.Println("Login handler called for", r.Method, r.URL.Path, "from", r.RemoteAddr)
 
-	// Read raw body for debugging
-	bodyBytes, err := io.ReadAll(r.Body)
-	if err != nil {
-		log.Println("Failed to read login request body:", err)
-		http.Error(w, "Failed to read request body", http.StatusBadRequest)
-		return
-	}
-	log.Println("Raw login request body:", string(bodyBytes))
-
-	// Recreate body for decoding
-	r.Body = io.NopCloser(strings.NewReader(string(bodyBytes)))
-
 	var input models.User
 	if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
 		log.Println("Failed to decode login request body:", err)
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
-	log.Println("Parsed login request:", input)
 
 	// Валідація
 	input.Username = strings.TrimSpace(input.Username)
@@ -114,65 +211,108 @@ func Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Перевірка користувача
-	var user models.User
-	var hashedPassword string
-	err = db.DB.QueryRow("SELECT id, username, password FROM users WHERE username = ?", input.Username).
-		Scan(&user.ID, &user.Username, &hashedPassword)
+	// Захист від підбору пароля: обліковий запис може бути тимчасово заблоковано
+	usernameKey := "login:user:" + strings.ToLower(input.Username)
+	ipKey := "login:ip:" + ratelimit.ClientIP(r)
+	if retryAfter, err := ratelimit.LoginUsername.Blocked(r.Context(), usernameKey); err != nil {
+		log.Println("Rate limit store error during login:", err)
+		metrics.DBErrorsTotal.Inc("rate_limit_login")
+	} else if retryAfter > 0 {
+		log.Println("Login blocked for username:", input.Username, "retry after", retryAfter)
+		metrics.RateLimitedTotal.Inc("login", "username")
+		w.Header().Set("Retry-After", ratelimit.RetryAfterSeconds(retryAfter))
+		http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	// Перевірка користувача налаштованими бекендами (локальні паролі, LDAP)
+	user, err := auth.Default.Authenticate(ctx, input.Username, input.Password)
+	if errors.Is(err, auth.ErrInvalidCredentials) {
+		loginFailed(w, r, usernameKey, ipKey)
+		return
+	}
 	if err != nil {
-		log.Println("Database error or user not found:", err)
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		// Недоступність бази чи каталогу не рахуємо невдалою спробою входу
+		log.Println("Authentication error for username:", input.Username, err)
+		metrics.DBErrorsTotal.Inc("login_authenticate")
+		http.Error(w, "Authentication service unavailable", http.StatusServiceUnavailable)
 		return
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(input.Password)); err != nil {
-		log.Println("Password mismatch for username:", input.Username)
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+	if user.Disabled {
+		log.Println("Login rejected for disabled user ID:", user.ID)
+		metrics.LoginAttemptsTotal.Inc("failure")
+		http.Error(w, "Account disabled", http.StatusForbidden)
 		return
 	}
+	// Лічильник IP не скидається: інакше один дійсний обліковий запис дозволяв би знімати
+	// блокування IP між спробами підбору паролів інших користувачів. Він згасає сам.
+	if err := ratelimit.LoginUsername.Success(r.Context(), usernameKey); err != nil {
+		log.Println("Failed to reset login failures for username:", input.Username, err)
+	}
 
-	// Генерація JWT
-	token, err := generateJWT(user.ID)
+	// Генерація JWT (або проміжного токена, якщо потрібен код 2FA)
+	response, err := completeLogin(ctx, user)
 	if err != nil {
 		log.Println("Failed to generate JWT:", err)
 		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
 		return
 	}
-
-	response := models.LoginResponse{
-		Token: token,
-		User:  user,
+	if response.MFARequired {
+		log.Println("Password accepted, waiting for 2FA code for user ID:", user.ID)
+		json.NewEncoder(w).Encode(response)
+		return
 	}
-	log.Println("Login successful for user ID:", user.ID, "Response:", response)
+
+	metrics.LoginAttemptsTotal.Inc("success")
+	log.Println("Login successful for user ID:", user.ID)
 	json.NewEncoder(w).Encode(response)
 }
 
+// loginFailed фіксує невдалу спробу входу для користувача та IP і відповідає 401.
+// Якщо після цієї спроби ключ заблоковано, клієнт одразу отримує Retry-After.
+func loginFailed(w http.ResponseWriter, r *http.Request, usernameKey, ipKey string) {
+	metrics.LoginAttemptsTotal.Inc("failure")
+
+	var retryAfter time.Duration
+	for _, limit := range []struct {
+		limiter *ratelimit.Limiter
+		key     string
+	}{{ratelimit.LoginUsername, usernameKey}, {ratelimit.LoginIP, ipKey}} {
+		blockedFor, locked, err := limit.limiter.Failure(r.Context(), limit.key)
+		if err != nil {
+			log.Println("Rate limit store error while recording login failure:", err)
+			metrics.DBErrorsTotal.Inc("rate_limit_login")
+			continue
+		}
+		if locked {
+			log.Println("Temporarily locked after repeated login failures:", limit.key, "for", blockedFor)
+		}
+		if blockedFor > retryAfter {
+			retryAfter = blockedFor
+		}
+	}
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", ratelimit.RetryAfterSeconds(retryAfter))
+	}
+	http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+}
+
 func CreateGrade(w http.ResponseWriter, r *http.Request) {
 	var grade models.Grade
 	if err := json.NewDecoder(r.Body).Decode(&grade); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	grade.ID = 0
 
-	// Валідація
-	var err error
-	if err = validate.Struct(grade); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	// Валідація та обчислення показників
+	if msg := validateGrade(&grade); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
 		return
 	}
-	if grade.Date.IsZero() || grade.Semester < 1 || grade.TotalStudents < 1 ||
-		grade.Grade5 < 0 || grade.Grade4 < 0 || grade.Grade3 < 0 || grade.Grade2 < 0 || grade.NotPassed < 0 {
-		http.Error(w, "Invalid grade data", http.StatusBadRequest)
-		return
-	}
-	if grade.Grade5+grade.Grade4+grade.Grade3+grade.Grade2+grade.NotPassed != grade.TotalStudents {
-		http.Error(w, "Sum of grades must equal total students", http.StatusBadRequest)
-		return
-	}
-
-	// Обчислення показників
-	grade.AverageScore = float64(grade.Grade5*5+grade.Grade4*4+grade.Grade3*3+grade.Grade2*2) / float64(grade.TotalStudents)
-	grade.SuccessRate = float64(grade.Grade5+grade.Grade4+grade.Grade3) / float64(grade.TotalStudents) * 100
-	grade.QualityRate = float64(grade.Grade5+grade.Grade4) / float64(grade.TotalStudents) * 100
 
 	// Отримання userID з JWT
 	userID, ok := r.Context().Value("userID").(int)
@@ -182,19 +322,133 @@ func CreateGrade(w http.ResponseWriter, r *http.Request) {
 	}
 	grade.UserID = userID
 
-	// Збереження оцінки
-	_, err = db.DB.Exec(
-		"INSERT INTO grades (date, semester, subject, group_name, total_students, grade_5, grade_4, grade_3, grade_2, not_passed, average_score, success_rate, quality_rate, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
-		grade.Date, grade.Semester, grade.Subject, grade.Group, grade.TotalStudents, grade.Grade5, grade.Grade4, grade.Grade3, grade.Grade2, grade.NotPassed, grade.AverageScore, grade.SuccessRate, grade.QualityRate, grade.UserID,
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	examID, ok := prepareGrade(ctx, w, r, &grade)
+	if !ok {
+		return
+	}
+
+	// Збереження оцінки разом з першою ревізією історії
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_insert")
+		http.Error(w, "Failed to save grade", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if !rejectDuplicate(ctx, w, r, tx, grade) {
+		return
+	}
+	result, err := tx.ExecContext(ctx,
+		"INSERT INTO grades (date, semester, subject, group_name, total_students, grade_5, grade_4, grade_3, grade_2, not_passed, average_score, success_rate, quality_rate, user_id, institution_id, academic_year, exam_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		grade.Date, grade.Semester, grade.Subject, grade.Group, grade.TotalStudents, grade.Grade5, grade.Grade4, grade.Grade3, grade.Grade2, grade.NotPassed, grade.AverageScore, grade.SuccessRate, grade.QualityRate, grade.UserID, institutionID(r), grade.AcademicYear, examID,
 	)
+	if isDuplicateKey(err) {
+		http.Error(w, "Exam already has a grade record", http.StatusConflict)
+		return
+	}
 	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_insert")
+		http.Error(w, "Failed to save grade", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	grade.ID = int(id)
+	grade.Version = 1
+	if err := recordRevision(ctx, tx, institutionID(r), grade, models.RevisionCreate, nil, userID); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_revision_insert")
 		http.Error(w, "Failed to save grade", http.StatusInternalServerError)
 		return
 	}
+	if err := tx.Commit(); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_insert")
+		http.Error(w, "Failed to save grade", http.StatusInternalServerError)
+		return
+	}
+	metrics.GradesCreatedTotal.Inc()
 
+	// Підозрілий запис зберігається, але позначається для перевірки
+	grade.Anomalies = flagAnomalies(ctx, institutionID(r), grade)
+	grade.BelowTarget = checkThresholds(ctx, institutionID(r), grade)
+
+	w.Header().Set("ETag", gradeETag(grade))
 	json.NewEncoder(w).Encode(grade)
 }
 
+// validateGrade перевіряє кількості оцінок і обчислює показники; повертає текст помилки для 400
+func validateGrade(grade *models.Grade) string {
+	if err := validate.Struct(grade); err != nil {
+		return err.Error()
+	}
+	if grade.Date.IsZero() || grade.Semester < 1 || grade.TotalStudents < 1 ||
+		grade.Grade5 < 0 || grade.Grade4 < 0 || grade.Grade3 < 0 || grade.Grade2 < 0 || grade.NotPassed < 0 {
+		return "Invalid grade data"
+	}
+	if grade.Grade5+grade.Grade4+grade.Grade3+grade.Grade2+grade.NotPassed != grade.TotalStudents {
+		return "Sum of grades must equal total students"
+	}
+	grade.AverageScore, grade.SuccessRate, grade.QualityRate = calculateAverages(grade.TotalStudents, grade.Grade5, grade.Grade4, grade.Grade3, grade.Grade2)
+	return ""
+}
+
+// prepareGrade визначає навчальний рік запису і перевіряє посилання на іспит
+// (дублікати перевіряє rejectDuplicate у транзакції запису). Повертає exam_id для запису в базу і false, якщо відповідь уже надіслано.
+func prepareGrade(ctx context.Context, w http.ResponseWriter, r *http.Request, grade *models.Grade) (sql.NullInt64, bool) {
+	// Навчальний рік визначається за датою; якщо його передано, він має збігатися
+	academicYear, err := resolveAcademicYear(ctx, institutionID(r), grade.Date)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_academic_year")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return sql.NullInt64{}, false
+	}
+	if grade.AcademicYear != "" && grade.AcademicYear != academicYear {
+		http.Error(w, "Date does not belong to academic year "+grade.AcademicYear, http.StatusBadRequest)
+		return sql.NullInt64{}, false
+	}
+	grade.AcademicYear = academicYear
+
+	// Запис може бути результатом запланованого іспиту з тим самим предметом і групою
+	var examID sql.NullInt64
+	if grade.ExamID != nil && *grade.ExamID > 0 {
+		var subject, group string
+		err := db.DB.QueryRowContext(ctx, "SELECT subject, group_name FROM exams WHERE id = ? AND institution_id = ?",
+			*grade.ExamID, institutionID(r)).Scan(&subject, &group)
+		if err == sql.ErrNoRows {
+			http.Error(w, "Exam not found", http.StatusBadRequest)
+			return examID, false
+		}
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("exam_select")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return examID, false
+		}
+		if subject != grade.Subject || group != grade.Group {
+			http.Error(w, "Subject and group must match the scheduled exam", http.StatusBadRequest)
+			return examID, false
+		}
+		examID = sql.NullInt64{Int64: int64(*grade.ExamID), Valid: true}
+	}
+	grade.ExamID = nullIntPtr(examID)
+
+	return examID, true
+}
+
+// gradeColumns - колонки оцінки (з псевдонімом g) у порядку scanGrade
+const gradeColumns = "g.id, g.date, g.semester, g.subject, g.group_name, g.total_students, g.grade_5, g.grade_4, g.grade_3, g.grade_2, g.not_passed, g.average_score, g.success_rate, g.quality_rate, g.user_id, g.academic_year, g.exam_id, g.version"
+
+// scanGrade читає оцінку; prefix - приймачі для колонок, вибраних перед gradeColumns
+func scanGrade(row interface{ Scan(...any) error }, prefix ...any) (models.Grade, error) {
+	var g models.Grade
+	var userID, examID sql.NullInt64
+	err := row.Scan(append(prefix, &g.ID, &g.Date, &g.Semester, &g.Subject, &g.Group, &g.TotalStudents, &g.Grade5, &g.Grade4, &g.Grade3, &g.Grade2, &g.NotPassed, &g.AverageScore, &g.SuccessRate, &g.QualityRate, &userID, &g.AcademicYear, &examID, &g.Version)...)
+	g.UserID = int(userID.Int64)
+	g.ExamID = nullIntPtr(examID)
+	return g, err
+}
+
 func GetGrades(w http.ResponseWriter, r *http.Request) {
 	userID, ok := r.Context().Value("userID").(int)
 	if !ok {
@@ -202,33 +456,38 @@ func GetGrades(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := db.DB.Query(
-		"SELECT id, date, semester, subject, group_name, total_students, grade_5, grade_4, grade_3, grade_2, not_passed, average_score, success_rate, quality_rate, user_id FROM grades WHERE user_id = ?",
-		userID,
+	// Необов'язкові фільтри academic_year і semester
+	where, args, msg := periodFilter(r.URL.Query(), []string{"g.user_id = ?", "g.institution_id = ?"}, []any{userID, institutionID(r)})
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT "+gradeColumns+" FROM grades g WHERE "+strings.Join(where, " AND ")+" ORDER BY g.date, g.id",
+		args...,
 	)
 	if err != nil {
+		metrics.DBErrorsTotal.Inc("grades_select")
 		http.Error(w, "Database error", http.StatusInternalServerError)
 		return
 	}
 	defer rows.Close()
 
-	var grades []models.Grade
+	grades := []models.Grade{}
 	for rows.Next() {
-		var grade models.Grade
-		if err := rows.Scan(&grade.ID, &grade.Date, &grade.Semester, &grade.Subject, &grade.Group, &grade.TotalStudents, &grade.Grade5, &grade.Grade4, &grade.Grade3, &grade.Grade2, &grade.NotPassed, &grade.AverageScore, &grade.SuccessRate, &grade.QualityRate, &grade.UserID); err != nil {
+		grade, err := scanGrade(rows)
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("grades_scan")
 			http.Error(w, "Failed to scan grades", http.StatusInternalServerError)
 			return
 		}
 		grades = append(grades, grade)
 	}
 
-	json.NewEncoder(w).Encode(grades)
-}
-
-func generateJWT(userID int) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 24).Unix(),
-	})
-	return token.SignedString(jwtSecret)
+	// Список має ETag від вмісту: If-None-Match з тим самим значенням отримує 304
+	writeJSONWithETag(w, r, grades)
 }
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"study_grade/db"
+	"sync/atomic"
+	"time"
+)
+
+// shuttingDown встановлюється на початку graceful shutdown. Слухач закривається лише
+// через SERVER_SHUTDOWN_DELAY, тож readiness probe встигає побачити 503 і оркестратор
+// перестає надсилати нові запити ще до закриття слухача
+var shuttingDown atomic.Bool
+
+// SetShuttingDown позначає сервер як такий, що завершує роботу
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// Healthz - liveness probe: процес живий і обробляє запити
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// Readyz - readiness probe: сервер не зупиняється і база даних відповідає
+func Readyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if shuttingDown.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 2*time.Second)
+	defer cancel()
+	if err := db.DB.PingContext(ctx); err != nil {
+		log.Println("Readiness check failed, database ping error:", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "unavailable", "database": "unreachable"})
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok", "database": "ok"})
+}
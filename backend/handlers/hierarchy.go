@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+)
+
+// inInstitution перевіряє, що запис таблиці з колонкою institution_id належить установі
+func inInstitution(ctx context.Context, table string, id, institutionID int) (bool, error) {
+	var exists bool
+	err := db.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM "+table+" WHERE id = ? AND institution_id = ?)", id, institutionID).Scan(&exists)
+	return exists, err
+}
+
+// optionalParent перевіряє необов'язкове посилання на батьківський вузол (0 або nil - без батька).
+// Повертає значення для запису в базу і false, якщо відповідь уже надіслано.
+func optionalParent(ctx context.Context, w http.ResponseWriter, r *http.Request, table string, id *int, notFound string) (sql.NullInt64, bool) {
+	if id == nil || *id <= 0 {
+		return sql.NullInt64{}, true
+	}
+	exists, err := inInstitution(ctx, table, *id, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc(table + "_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return sql.NullInt64{}, false
+	}
+	if !exists {
+		http.Error(w, notFound, http.StatusBadRequest)
+		return sql.NullInt64{}, false
+	}
+	return sql.NullInt64{Int64: int64(*id), Valid: true}, true
+}
+
+// ListFaculties повертає факультети установи
+func ListFaculties(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT id, name FROM faculties WHERE institution_id = ? ORDER BY name", institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("faculty_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	faculties := []models.Faculty{}
+	for rows.Next() {
+		var f models.Faculty
+		if err := rows.Scan(&f.ID, &f.Name); err != nil {
+			metrics.DBErrorsTotal.Inc("faculty_scan")
+			http.Error(w, "Failed to scan faculties", http.StatusInternalServerError)
+			return
+		}
+		faculties = append(faculties, f)
+	}
+	json.NewEncoder(w).Encode(faculties)
+}
+
+// CreateFaculty додає факультет установи
+func CreateFaculty(w http.ResponseWriter, r *http.Request) {
+	var f models.Faculty
+	if err := json.NewDecoder(r.Body).Decode(&f); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	f.Name = strings.TrimSpace(f.Name)
+	if f.Name == "" || len(f.Name) > 150 {
+		http.Error(w, "Faculty name must be 1-150 characters", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx, "INSERT INTO faculties (name, institution_id) VALUES (?, ?)", f.Name, institutionID(r))
+	if isDuplicateKey(err) {
+		http.Error(w, "Faculty already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("faculty_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	f.ID = int(id)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(f)
+}
+
+// ListDepartments повертає підрозділи установи
+func ListDepartments(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT id, name, faculty_id FROM departments WHERE institution_id = ? ORDER BY name", institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("department_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	departments := []models.Department{}
+	for rows.Next() {
+		var d models.Department
+		var facultyID sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.Name, &facultyID); err != nil {
+			metrics.DBErrorsTotal.Inc("department_scan")
+			http.Error(w, "Failed to scan departments", http.StatusInternalServerError)
+			return
+		}
+		d.FacultyID = nullIntPtr(facultyID)
+		departments = append(departments, d)
+	}
+	json.NewEncoder(w).Encode(departments)
+}
+
+// CreateDepartment додає підрозділ установи, за потреби - у складі факультету
+func CreateDepartment(w http.ResponseWriter, r *http.Request) {
+	var d models.Department
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	d.Name = strings.TrimSpace(d.Name)
+	if d.Name == "" || len(d.Name) > 150 {
+		http.Error(w, "Department name must be 1-150 characters", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	facultyID, ok := optionalParent(ctx, w, r, "faculties", d.FacultyID, "Faculty not found")
+	if !ok {
+		return
+	}
+	result, err := db.DB.ExecContext(ctx, "INSERT INTO departments (name, faculty_id, institution_id) VALUES (?, ?, ?)", d.Name, facultyID, institutionID(r))
+	if isDuplicateKey(err) {
+		http.Error(w, "Department already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("department_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	d.ID = int(id)
+	d.FacultyID = nullIntPtr(facultyID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(d)
+}
+
+// UpdateDepartment перейменовує підрозділ або переносить його до іншого факультету
+func UpdateDepartment(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid department ID", http.StatusBadRequest)
+		return
+	}
+	var req models.UpdateDepartmentRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	var set []string
+	var args []any
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" || len(name) > 150 {
+			http.Error(w, "Department name must be 1-150 characters", http.StatusBadRequest)
+			return
+		}
+		set = append(set, "name = ?")
+		args = append(args, name)
+	}
+	if req.FacultyID != nil {
+		facultyID, ok := optionalParent(ctx, w, r, "faculties", req.FacultyID, "Faculty not found")
+		if !ok {
+			return
+		}
+		set = append(set, "faculty_id = ?")
+		args = append(args, facultyID)
+	}
+	if len(set) == 0 {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	exists, err := inInstitution(ctx, "departments", id, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("department_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Department not found", http.StatusNotFound)
+		return
+	}
+	_, err = db.DB.ExecContext(ctx, "UPDATE departments SET "+strings.Join(set, ", ")+" WHERE id = ? AND institution_id = ?", append(args, id, institutionID(r))...)
+	if isDuplicateKey(err) {
+		http.Error(w, "Department already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("department_update")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	var d models.Department
+	var facultyID sql.NullInt64
+	if err := db.DB.QueryRowContext(ctx, "SELECT id, name, faculty_id FROM departments WHERE id = ?", id).Scan(&d.ID, &d.Name, &facultyID); err != nil {
+		metrics.DBErrorsTotal.Inc("department_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	d.FacultyID = nullIntPtr(facultyID)
+	json.NewEncoder(w).Encode(d)
+}
+
+// ListStudyGroups повертає академічні групи установи
+func ListStudyGroups(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT id, name, department_id FROM study_groups WHERE institution_id = ? ORDER BY name", institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("study_group_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	groups := []models.StudyGroup{}
+	for rows.Next() {
+		var g models.StudyGroup
+		var departmentID sql.NullInt64
+		if err := rows.Scan(&g.ID, &g.Name, &departmentID); err != nil {
+			metrics.DBErrorsTotal.Inc("study_group_scan")
+			http.Error(w, "Failed to scan groups", http.StatusInternalServerError)
+			return
+		}
+		g.DepartmentID = nullIntPtr(departmentID)
+		groups = append(groups, g)
+	}
+	json.NewEncoder(w).Encode(groups)
+}
+
+// CreateStudyGroup реєструє академічну групу і, за потреби, закріплює її за підрозділом
+func CreateStudyGroup(w http.ResponseWriter, r *http.Request) {
+	var g models.StudyGroup
+	if err := json.NewDecoder(r.Body).Decode(&g); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	g.Name = strings.TrimSpace(g.Name)
+	if g.Name == "" || len(g.Name) > 50 {
+		http.Error(w, "Group name must be 1-50 characters", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	departmentID, ok := optionalParent(ctx, w, r, "departments", g.DepartmentID, "Department not found")
+	if !ok {
+		return
+	}
+	result, err := db.DB.ExecContext(ctx, "INSERT INTO study_groups (name, department_id, institution_id) VALUES (?, ?, ?)", g.Name, departmentID, institutionID(r))
+	if isDuplicateKey(err) {
+		http.Error(w, "Group already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("study_group_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	g.ID = int(id)
+	g.DepartmentID = nullIntPtr(departmentID)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(g)
+}
+
+// UpdateStudyGroup переносить групу до іншого підрозділу
+func UpdateStudyGroup(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid group ID", http.StatusBadRequest)
+		return
+	}
+	var req models.UpdateStudyGroupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.DepartmentID == nil {
+		http.Error(w, "Nothing to update", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	departmentID, ok := optionalParent(ctx, w, r, "departments", req.DepartmentID, "Department not found")
+	if !ok {
+		return
+	}
+	var g models.StudyGroup
+	err := db.DB.QueryRowContext(ctx, "SELECT id, name FROM study_groups WHERE id = ? AND institution_id = ?", id, institutionID(r)).Scan(&g.ID, &g.Name)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Group not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("study_group_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := db.DB.ExecContext(ctx, "UPDATE study_groups SET department_id = ? WHERE id = ?", departmentID, id); err != nil {
+		metrics.DBErrorsTotal.Inc("study_group_update")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	g.DepartmentID = nullIntPtr(departmentID)
+	json.NewEncoder(w).Encode(g)
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+	"time"
+)
+
+var institutionSlugPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,49}$`)
+
+// institutionID повертає установу поточного користувача (з JWTAuthMiddleware).
+// Усі запити обробників до даних установи фільтруються за цим значенням.
+func institutionID(r *http.Request) int {
+	id, _ := r.Context().Value("institutionID").(int)
+	return id
+}
+
+// resolveInstitution знаходить установу за slug; порожній slug - установа за замовчуванням
+func resolveInstitution(ctx context.Context, slug string) (int, error) {
+	slug = strings.ToLower(strings.TrimSpace(slug))
+	if slug == "" {
+		return db.DefaultInstitutionID, nil
+	}
+	var id int
+	err := db.DB.QueryRowContext(ctx, "SELECT id FROM institutions WHERE slug = ?", slug).Scan(&id)
+	return id, err
+}
+
+// ListInstitutions повертає всі установи платформи (для superadmin)
+func ListInstitutions(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT id, name, slug, created_at FROM institutions ORDER BY name")
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("institution_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	institutions := []models.Institution{}
+	for rows.Next() {
+		var inst models.Institution
+		if err := rows.Scan(&inst.ID, &inst.Name, &inst.Slug, &inst.CreatedAt); err != nil {
+			metrics.DBErrorsTotal.Inc("institution_scan")
+			http.Error(w, "Failed to scan institutions", http.StatusInternalServerError)
+			return
+		}
+		institutions = append(institutions, inst)
+	}
+	json.NewEncoder(w).Encode(institutions)
+}
+
+// CreateInstitution створює установу і, за потреби, її першого адміністратора
+// з тимчасовим паролем - усе в одній транзакції
+func CreateInstitution(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateInstitutionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+	if req.Name == "" || len(req.Name) > 150 {
+		http.Error(w, "Institution name must be 1-150 characters", http.StatusBadRequest)
+		return
+	}
+	if !institutionSlugPattern.MatchString(req.Slug) {
+		http.Error(w, "Slug must be 2-50 lowercase letters, digits or dashes", http.StatusBadRequest)
+		return
+	}
+	if req.RegistrationMode == "" {
+		req.RegistrationMode = models.RegistrationClosed
+	}
+	if !validRegistrationMode(req.RegistrationMode) {
+		http.Error(w, "Registration mode must be open, closed or invite", http.StatusBadRequest)
+		return
+	}
+	if req.Admin != nil {
+		req.Admin.Role = models.RoleAdmin
+		if msg := createUserError(req.Admin); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("institution_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, "INSERT INTO institutions (name, slug) VALUES (?, ?)", req.Name, req.Slug)
+	if isDuplicateKey(err) {
+		http.Error(w, "Institution name or slug already exists", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to create institution:", err)
+		metrics.DBErrorsTotal.Inc("institution_insert")
+		http.Error(w, "Failed to create institution", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	if _, err := tx.ExecContext(ctx, "INSERT INTO settings (institution_id, name, value) VALUES (?, ?, ?)", id, settingRegistrationMode, req.RegistrationMode); err != nil {
+		metrics.DBErrorsTotal.Inc("institution_insert")
+		http.Error(w, "Failed to create institution", http.StatusInternalServerError)
+		return
+	}
+	response := models.CreateInstitutionResponse{Institution: models.Institution{
+		ID:        int(id),
+		Name:      req.Name,
+		Slug:      req.Slug,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+	}}
+
+	var adminID int
+	var tempPassword string
+	if req.Admin != nil {
+		adminID, tempPassword, err = insertTemporaryUser(ctx, tx, int(id), *req.Admin)
+		if isDuplicateKey(err) {
+			http.Error(w, "Username or email already taken", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Println("Failed to create institution admin:", err)
+			metrics.DBErrorsTotal.Inc("institution_insert_admin")
+			http.Error(w, "Failed to create institution", http.StatusInternalServerError)
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		metrics.DBErrorsTotal.Inc("institution_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	if req.Admin != nil {
+		admin, err := loadUser(ctx, int(id), adminID)
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("admin_select_user")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		response.Admin = &models.CreateUserResponse{User: admin, TemporaryPassword: tempPassword}
+	}
+
+	log.Println("Institution created:", id, req.Slug, "with admin:", adminID, "registration:", req.RegistrationMode)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// CreateInstitutionAdmin додає адміністратора до існуючої установи
+func CreateInstitutionAdmin(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid institution ID", http.StatusBadRequest)
+		return
+	}
+	var req models.CreateUserRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Role = models.RoleAdmin
+	if msg := createUserError(&req); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	var exists bool
+	if err := db.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM institutions WHERE id = ?)", id).Scan(&exists); err != nil {
+		metrics.DBErrorsTotal.Inc("institution_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !exists {
+		http.Error(w, "Institution not found", http.StatusNotFound)
+		return
+	}
+
+	adminID, tempPassword, err := insertTemporaryUser(ctx, db.DB, id, req)
+	if isDuplicateKey(err) {
+		http.Error(w, "Username or email already taken", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to create institution admin:", err)
+		metrics.DBErrorsTotal.Inc("institution_insert_admin")
+		http.Error(w, "Failed to create user", http.StatusInternalServerError)
+		return
+	}
+	admin, err := loadUser(ctx, id, adminID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("admin_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("Admin user", adminID, "created for institution", id)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreateUserResponse{User: admin, TemporaryPassword: tempPassword})
+}
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"study_grade/config"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+	"time"
+)
+
+// registrationMode повертає режим реєстрації платформи з REGISTRATION_MODE (open, closed, invite)
+func registrationMode() string {
+	mode := config.String("REGISTRATION_MODE", models.RegistrationOpen)
+	if !validRegistrationMode(mode) {
+		// Невідомий режим - безпечніше закрити реєстрацію
+		log.Printf("Unknown REGISTRATION_MODE %q, treating as closed", mode)
+		return models.RegistrationClosed
+	}
+	return mode
+}
+
+func validRegistrationMode(mode string) bool {
+	return mode == models.RegistrationOpen || mode == models.RegistrationClosed || mode == models.RegistrationInvite
+}
+
+// institutionRegistrationMode повертає режим реєстрації установи з урахуванням REGISTRATION_MODE:
+// closed або invite платформи не послаблюються налаштуванням установи
+func institutionRegistrationMode(ctx context.Context, institutionID int) (string, error) {
+	if platform := registrationMode(); platform != models.RegistrationOpen {
+		return platform, nil
+	}
+	return loadRegistrationPolicy(ctx, institutionID)
+}
+
+// loadRegistrationPolicy повертає власний режим установи. Якщо його не задано, основна установа
+// відкрита, а решта - закриті, щоб до них не можна було приєднатися, просто знаючи slug.
+func loadRegistrationPolicy(ctx context.Context, institutionID int) (string, error) {
+	value, ok, err := getSetting(ctx, institutionID, settingRegistrationMode)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		if institutionID == db.DefaultInstitutionID {
+			return models.RegistrationOpen, nil
+		}
+		return models.RegistrationClosed, nil
+	}
+	if !validRegistrationMode(value) {
+		log.Printf("Invalid registration mode %q for institution %d, treating as closed", value, institutionID)
+		return models.RegistrationClosed, nil
+	}
+	return value, nil
+}
+
+// GetRegistrationMode повідомляє фронтенду, чи потрібен код запрошення.
+// ?institution=slug повертає режим конкретної установи; невідома установа - closed.
+func GetRegistrationMode(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	mode := models.RegistrationClosed
+	id, err := resolveInstitution(ctx, r.URL.Query().Get("institution"))
+	if err == nil {
+		mode, err = institutionRegistrationMode(ctx, id)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		metrics.DBErrorsTotal.Inc("registration_mode")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(models.RegistrationModeResponse{Mode: mode})
+}
+
+// GetRegistrationPolicy повертає власний режим реєстрації установи (без урахування REGISTRATION_MODE)
+func GetRegistrationPolicy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	mode, err := loadRegistrationPolicy(ctx, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("registration_policy")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(models.RegistrationPolicy{Mode: mode})
+}
+
+// UpdateRegistrationPolicy задає, чи можна приєднатися до установи самостійно (open),
+// лише за запрошенням (invite) чи ніяк (closed)
+func UpdateRegistrationPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy models.RegistrationPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !validRegistrationMode(policy.Mode) {
+		http.Error(w, "Mode must be open, closed or invite", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	if err := putSetting(ctx, institutionID(r), settingRegistrationMode, policy.Mode); err != nil {
+		metrics.DBErrorsTotal.Inc("registration_policy")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	log.Println("Registration mode of institution", institutionID(r), "set to", policy.Mode)
+	json.NewEncoder(w).Encode(policy)
+}
+
+var errInvalidInvitation = errors.New("invalid, used or expired invitation code")
+
+// claimedInvitation - параметри, задані адміністратором у запрошенні
+type claimedInvitation struct {
+	id            int
+	role          string
+	departmentID  *int
+	institutionID int
+}
+
+// Усі часи запрошень (створення, строк дії, використання, відкликання) рахуються
+// за годинником застосунку в UTC, а не NOW() бази, часовий пояс якої може відрізнятися.
+
+// lockInvitation перевіряє код і блокує запрошення до кінця транзакції реєстрації.
+// Повертає роль, підрозділ і установу, задані адміністратором.
+func lockInvitation(ctx context.Context, tx *sql.Tx, code string) (claimedInvitation, error) {
+	var inv claimedInvitation
+	var departmentID sql.NullInt64
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, role, department_id, institution_id FROM invitations
+		WHERE code_hash = ? AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?
+		FOR UPDATE`,
+		hashInvitationCode(code), time.Now().UTC(),
+	).Scan(&inv.id, &inv.role, &departmentID, &inv.institutionID)
+	if err == sql.ErrNoRows {
+		return inv, errInvalidInvitation
+	}
+	inv.departmentID = nullIntPtr(departmentID)
+	return inv, err
+}
+
+// markInvitationUsed позначає заблоковане запрошення використаним новим користувачем
+func markInvitationUsed(ctx context.Context, tx *sql.Tx, inv claimedInvitation, userID int) error {
+	if _, err := tx.ExecContext(ctx, "UPDATE invitations SET used_by = ?, used_at = ? WHERE id = ?", userID, time.Now().UTC(), inv.id); err != nil {
+		return err
+	}
+	log.Println("Invitation", inv.id, "claimed by user ID:", userID)
+	return nil
+}
+
+func hashInvitationCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateInvitation створює одноразовий код запрошення з роллю та підрозділом
+func CreateInvitation(w http.ResponseWriter, r *http.Request) {
+	var req models.CreateInvitationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Role == "" {
+		req.Role = models.RoleTeacher
+	}
+	if !validRole(req.Role) {
+		http.Error(w, "Unknown role: "+req.Role, http.StatusBadRequest)
+		return
+	}
+	if req.ExpiresInHours == 0 {
+		req.ExpiresInHours = 72
+	}
+	if req.ExpiresInHours < 1 || req.ExpiresInHours > 24*90 {
+		http.Error(w, "expires_in_hours must be between 1 and 2160", http.StatusBadRequest)
+		return
+	}
+	adminID, _ := r.Context().Value("userID").(int)
+
+	buf := make([]byte, 10)
+	if _, err := rand.Read(buf); err != nil {
+		http.Error(w, "Failed to generate code", http.StatusInternalServerError)
+		return
+	}
+	code := strings.ToUpper(hex.EncodeToString(buf))
+	expiresAt := time.Now().UTC().Add(time.Duration(req.ExpiresInHours) * time.Hour).Truncate(time.Second)
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	if req.DepartmentID != nil {
+		var exists bool
+		if err := db.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM departments WHERE id = ? AND institution_id = ?)", *req.DepartmentID, institutionID(r)).Scan(&exists); err != nil || !exists {
+			http.Error(w, "Department not found", http.StatusBadRequest)
+			return
+		}
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	result, err := db.DB.ExecContext(ctx,
+		"INSERT INTO invitations (code_hash, role, department_id, expires_at, created_by, created_at, institution_id) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		hashInvitationCode(code), req.Role, req.DepartmentID, expiresAt, adminID, createdAt, institutionID(r))
+	if err != nil {
+		log.Println("Failed to create invitation:", err)
+		metrics.DBErrorsTotal.Inc("invitation_insert")
+		http.Error(w, "Failed to create invitation", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	log.Println("Admin", adminID, "created invitation", id, "for role", req.Role)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(models.CreateInvitationResponse{
+		Invitation: models.Invitation{
+			ID:           int(id),
+			Role:         req.Role,
+			DepartmentID: req.DepartmentID,
+			ExpiresAt:    expiresAt,
+			CreatedBy:    &adminID,
+			CreatedAt:    createdAt,
+		},
+		Code: code,
+	})
+}
+
+// ListInvitations повертає запрошення; ?status=active|used|expired|revoked фільтрує за станом
+func ListInvitations(w http.ResponseWriter, r *http.Request) {
+	now := time.Now().UTC()
+	where := "institution_id = ?"
+	args := []any{institutionID(r)}
+	switch status := r.URL.Query().Get("status"); status {
+	case "":
+	case "active":
+		where += " AND used_at IS NULL AND revoked_at IS NULL AND expires_at > ?"
+		args = append(args, now)
+	case "used":
+		where += " AND used_at IS NOT NULL"
+	case "revoked":
+		where += " AND revoked_at IS NOT NULL"
+	case "expired":
+		where += " AND used_at IS NULL AND revoked_at IS NULL AND expires_at <= ?"
+		args = append(args, now)
+	default:
+		http.Error(w, "Invalid status filter", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT id, role, department_id, expires_at, created_by, created_at, used_by, used_at, revoked_at FROM invitations WHERE "+where+" ORDER BY created_at DESC",
+		args...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("invitation_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	invitations := []models.Invitation{}
+	for rows.Next() {
+		var inv models.Invitation
+		var departmentID, createdBy, usedBy sql.NullInt64
+		var usedAt, revokedAt sql.NullTime
+		if err := rows.Scan(&inv.ID, &inv.Role, &departmentID, &inv.ExpiresAt, &createdBy, &inv.CreatedAt, &usedBy, &usedAt, &revokedAt); err != nil {
+			metrics.DBErrorsTotal.Inc("invitation_scan")
+			http.Error(w, "Failed to scan invitations", http.StatusInternalServerError)
+			return
+		}
+		inv.DepartmentID = nullIntPtr(departmentID)
+		inv.CreatedBy = nullIntPtr(createdBy)
+		inv.UsedBy = nullIntPtr(usedBy)
+		inv.UsedAt = nullTimePtr(usedAt)
+		inv.RevokedAt = nullTimePtr(revokedAt)
+		invitations = append(invitations, inv)
+	}
+	json.NewEncoder(w).Encode(invitations)
+}
+
+// RevokeInvitation анулює ще не використане запрошення
+func RevokeInvitation(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid invitation ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx, "UPDATE invitations SET revoked_at = ? WHERE id = ? AND institution_id = ? AND used_at IS NULL AND revoked_at IS NULL", time.Now().UTC(), id, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("invitation_revoke")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Invitation not found or already used", http.StatusNotFound)
+		return
+	}
+	log.Println("Invitation revoked:", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func nullTimePtr(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Time
+}
@@ -0,0 +1,498 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"study_grade/db"
+	"study_grade/models"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gorilla/mux"
+)
+
+// Дві установи: адміністратор установи 1 звертається до записів установи 2.
+// Мок бази відповідає так, ніби в установі 1 таких записів немає, тож обробник має
+// шукати їх лише з фільтром institution_id = 1 і відповідати 404 або порожнім результатом.
+const (
+	ownInstitution     = 1
+	foreignInstitution = 2
+	callerID           = 10
+	foreignGradeID     = 200
+	foreignUserID      = 201
+	foreignExamID      = 202
+	foreignNodeID      = 203
+)
+
+// tenantMatcher вимагає фільтра за установою в кожному запиті до даних установ
+var tenantMatcher = sqlmock.QueryMatcherFunc(func(expectedSQL, actualSQL string) error {
+	if !strings.Contains(actualSQL, "institution_id") && !strings.Contains(actualSQL, "FROM institutions WHERE") {
+		return fmt.Errorf("query is not scoped to an institution: %s", actualSQL)
+	}
+	return sqlmock.QueryMatcherRegexp.Match(expectedSQL, actualSQL)
+})
+
+// mockTenantDB підміняє db.DB моком на час тесту
+func mockTenantDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	return mockDB(t, tenantMatcher)
+}
+
+func mockDB(t *testing.T, matcher sqlmock.QueryMatcher) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New(sqlmock.QueryMatcherOption(matcher))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := db.DB
+	db.DB = mockDB
+	t.Cleanup(func() {
+		db.DB = prev
+		mockDB.Close()
+	})
+	return mock
+}
+
+// tenantRequest - запит адміністратора установи 1 (як після JWTAuthMiddleware)
+func tenantRequest(method, target, body string, vars map[string]string) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	ctx := context.WithValue(r.Context(), "userID", callerID)
+	ctx = context.WithValue(ctx, "userRole", models.RoleAdmin)
+	ctx = context.WithValue(ctx, "institutionID", ownInstitution)
+	r = r.WithContext(ctx)
+	if vars != nil {
+		r = mux.SetURLVars(r, vars)
+	}
+	return r
+}
+
+func noRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id"})
+}
+
+func idVars(id int) map[string]string {
+	return map[string]string{"id": fmt.Sprint(id)}
+}
+
+const validGradeBody = `{"date":"2025-01-20T00:00:00Z","semester":1,"subject":"Math","group":"KN-21","total_students":2,"grade_5":1,"grade_4":1,"grade_3":0,"grade_2":0,"not_passed":0}`
+
+func expectForeignGrade(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`FROM grades g WHERE g\.id = \? AND g\.institution_id = \?`).
+		WithArgs(foreignGradeID, ownInstitution).WillReturnRows(noRows())
+}
+
+// expectForeignDeletedGrade - запис шукається і серед видалених, теж лише в установі 1
+func expectForeignDeletedGrade(mock sqlmock.Sqlmock) {
+	expectForeignGrade(mock)
+	mock.ExpectQuery(`SELECT action, data FROM grade_revisions WHERE grade_id = \? AND institution_id = \?`).
+		WithArgs(foreignGradeID, ownInstitution).WillReturnRows(noRows())
+}
+
+func expectForeignUser(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(`SELECT role FROM users WHERE id = \? AND institution_id = \?`).
+		WithArgs(foreignUserID, ownInstitution).WillReturnRows(noRows())
+}
+
+func TestCrossTenantRecordsNotFound(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		request *http.Request
+		ifMatch string
+		expect  func(mock sqlmock.Sqlmock)
+	}{
+		{
+			name:    "GET /api/grades/{id}",
+			handler: GetGrade,
+			request: tenantRequest("GET", "/api/grades/200", "", idVars(foreignGradeID)),
+			expect:  expectForeignGrade,
+		},
+		{
+			name:    "PUT /api/grades/{id}",
+			handler: UpdateGrade,
+			request: tenantRequest("PUT", "/api/grades/200", validGradeBody, idVars(foreignGradeID)),
+			ifMatch: `"200-1"`,
+			expect:  expectForeignGrade,
+		},
+		{
+			name:    "DELETE /api/grades/{id}",
+			handler: DeleteGrade,
+			request: tenantRequest("DELETE", "/api/grades/200", "", idVars(foreignGradeID)),
+			ifMatch: `"200-1"`,
+			expect:  expectForeignGrade,
+		},
+		{
+			name:    "GET /api/grades/{id}/history",
+			handler: GetGradeHistory,
+			request: tenantRequest("GET", "/api/grades/200/history", "", idVars(foreignGradeID)),
+			expect:  expectForeignDeletedGrade,
+		},
+		{
+			name:    "POST /api/grades/{id}/restore",
+			handler: RestoreGrade,
+			request: tenantRequest("POST", "/api/grades/200/restore", `{"version":1}`, idVars(foreignGradeID)),
+			ifMatch: `"200-2"`,
+			expect:  expectForeignDeletedGrade,
+		},
+		{
+			name:    "PATCH /api/exams/{id}",
+			handler: UpdateExam,
+			request: tenantRequest("PATCH", "/api/exams/202", `{"room":"101"}`, idVars(foreignExamID)),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM exams e .+ WHERE e\.id = \? AND e\.institution_id = \?`).
+					WithArgs(foreignExamID, ownInstitution).WillReturnRows(noRows())
+			},
+		},
+		{
+			name:    "DELETE /api/exams/{id}",
+			handler: DeleteExam,
+			request: tenantRequest("DELETE", "/api/exams/202", "", idVars(foreignExamID)),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM exams e .+ WHERE e\.id = \? AND e\.institution_id = \?`).
+					WithArgs(foreignExamID, ownInstitution).WillReturnRows(noRows())
+			},
+		},
+		{
+			name:    "GET /api/stats?level=faculty",
+			handler: GetStats,
+			request: tenantRequest("GET", "/api/stats?level=faculty&id=203", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT name FROM faculties WHERE id = \? AND institution_id = \?`).
+					WithArgs(foreignNodeID, ownInstitution).WillReturnRows(noRows())
+			},
+		},
+		{
+			name:    "GET /api/stats?level=department",
+			handler: GetStats,
+			request: tenantRequest("GET", "/api/stats?level=department&id=203", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT name FROM departments WHERE id = \? AND institution_id = \?`).
+					WithArgs(foreignNodeID, ownInstitution).WillReturnRows(noRows())
+			},
+		},
+		{
+			name:    "PATCH /api/admin/departments/{id}",
+			handler: UpdateDepartment,
+			request: tenantRequest("PATCH", "/api/admin/departments/203", `{"name":"Physics"}`, idVars(foreignNodeID)),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM departments WHERE id = \? AND institution_id = \?\)`).
+					WithArgs(foreignNodeID, ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+			},
+		},
+		{
+			name:    "PATCH /api/admin/groups/{id}",
+			handler: UpdateStudyGroup,
+			request: tenantRequest("PATCH", "/api/admin/groups/203", `{"department_id":0}`, idVars(foreignNodeID)),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name FROM study_groups WHERE id = \? AND institution_id = \?`).
+					WithArgs(foreignNodeID, ownInstitution).WillReturnRows(noRows())
+			},
+		},
+		{
+			name:    "POST /api/admin/grades/merge",
+			handler: MergeDuplicates,
+			request: tenantRequest("POST", "/api/admin/grades/merge", `{"keep_id":200,"merge_ids":[204]}`, nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT value FROM settings WHERE institution_id = \? AND name = \?`).
+					WithArgs(ownInstitution, settingGradeNaturalKey).WillReturnRows(noRows())
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT id FROM institutions WHERE id = \? FOR UPDATE`).
+					WithArgs(ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(ownInstitution))
+				mock.ExpectQuery(`FROM grades g WHERE g\.institution_id = \? AND g\.id IN \(\?, \?\) ORDER BY g\.id FOR UPDATE`).
+					WithArgs(ownInstitution, foreignGradeID, 204).WillReturnRows(noRows())
+				mock.ExpectRollback()
+			},
+		},
+		{
+			name:    "PATCH /api/admin/users/{id}",
+			handler: UpdateUser,
+			request: tenantRequest("PATCH", "/api/admin/users/201", `{"email":"new@example.org"}`, idVars(foreignUserID)),
+			expect:  expectForeignUser,
+		},
+		{
+			name:    "PUT /api/admin/users/{id}/role",
+			handler: UpdateUserRole,
+			request: tenantRequest("PUT", "/api/admin/users/201/role", `{"role":"admin"}`, idVars(foreignUserID)),
+			expect:  expectForeignUser,
+		},
+		{
+			name:    "POST /api/admin/users/{id}/disable",
+			handler: DisableUser,
+			request: tenantRequest("POST", "/api/admin/users/201/disable", "", idVars(foreignUserID)),
+			expect:  expectForeignUser,
+		},
+		{
+			name:    "POST /api/admin/users/{id}/enable",
+			handler: EnableUser,
+			request: tenantRequest("POST", "/api/admin/users/201/enable", "", idVars(foreignUserID)),
+			expect:  expectForeignUser,
+		},
+		{
+			name:    "DELETE /api/admin/users/{id}",
+			handler: DeleteUser,
+			request: tenantRequest("DELETE", "/api/admin/users/201", "", idVars(foreignUserID)),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`SELECT role FROM users WHERE id = \? AND institution_id = \? FOR UPDATE`).
+					WithArgs(foreignUserID, ownInstitution).WillReturnRows(noRows())
+				mock.ExpectRollback()
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockTenantDB(t)
+			tt.expect(mock)
+			if tt.ifMatch != "" {
+				tt.request.Header.Set("If-Match", tt.ifMatch)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(w, tt.request)
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("got status %d (%s), want 404", w.Code, strings.TrimSpace(w.Body.String()))
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCrossTenantListsEmpty(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		request *http.Request
+		expect  func(mock sqlmock.Sqlmock)
+		// empty перевіряє, що відповідь не містить записів
+		empty func(t *testing.T, body string)
+	}{
+		{
+			name:    "GET /api/grades",
+			handler: GetGrades,
+			request: tenantRequest("GET", "/api/grades", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM grades g WHERE g\.user_id = \? AND g\.institution_id = \?`).
+					WithArgs(callerID, ownInstitution).WillReturnRows(noRows())
+			},
+			empty: expectJSON("[]"),
+		},
+		{
+			name:    "GET /api/stats",
+			handler: GetStats,
+			request: tenantRequest("GET", "/api/stats", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT name FROM institutions WHERE id = \?`).
+					WithArgs(ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("Own College"))
+				mock.ExpectQuery(`FROM grades g.+WHERE g\.institution_id = \?`).
+					WithArgs(ownInstitution).WillReturnRows(noRows())
+			},
+			empty: func(t *testing.T, body string) {
+				var node models.StatsNode
+				json.Unmarshal([]byte(body), &node)
+				if node.Records != 0 || len(node.Children) != 0 || node.ID == nil || *node.ID != ownInstitution {
+					t.Errorf("got %s, want an empty node of institution %d", body, ownInstitution)
+				}
+			},
+		},
+		{
+			name:    "GET /api/stats/trends",
+			handler: GetTrends,
+			request: tenantRequest("GET", "/api/stats/trends?by=subject", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM grades g\s+WHERE g\.institution_id = \?`).
+					WithArgs(ownInstitution).WillReturnRows(noRows())
+			},
+			empty: expectJSON(`{"by":"subject","series":[]}`),
+		},
+		{
+			name:    "GET /api/stats/compare",
+			handler: GetGroupComparison,
+			request: tenantRequest("GET", "/api/stats/compare?subject=Math&semester=1", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM grades g.+WHERE g\.institution_id = \? AND g\.subject = \? AND g\.semester = \?`).
+					WithArgs(ownInstitution, "Math", 1).WillReturnRows(noRows())
+			},
+			empty: func(t *testing.T, body string) {
+				var response models.GroupComparisonResponse
+				json.Unmarshal([]byte(body), &response)
+				if len(response.Groups) != 0 {
+					t.Errorf("got %s, want no groups", body)
+				}
+			},
+		},
+		{
+			name:    "GET /api/admin/faculties",
+			handler: ListFaculties,
+			request: tenantRequest("GET", "/api/admin/faculties", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT id, name FROM faculties WHERE institution_id = \?`).
+					WithArgs(ownInstitution).WillReturnRows(noRows())
+			},
+			empty: expectJSON("[]"),
+		},
+		{
+			name:    "GET /api/admin/departments",
+			handler: ListDepartments,
+			request: tenantRequest("GET", "/api/admin/departments", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM departments WHERE institution_id = \?`).
+					WithArgs(ownInstitution).WillReturnRows(noRows())
+			},
+			empty: expectJSON("[]"),
+		},
+		{
+			name:    "GET /api/admin/groups",
+			handler: ListStudyGroups,
+			request: tenantRequest("GET", "/api/admin/groups", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM study_groups WHERE institution_id = \?`).
+					WithArgs(ownInstitution).WillReturnRows(noRows())
+			},
+			empty: expectJSON("[]"),
+		},
+		{
+			name:    "GET /api/exams",
+			handler: ListExams,
+			request: tenantRequest("GET", "/api/exams", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM exams e .+ WHERE e\.institution_id = \?`).
+					WithArgs(ownInstitution, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(noRows())
+			},
+			empty: expectJSON("[]"),
+		},
+		{
+			name:    "GET /api/exams/calendar.ics",
+			handler: ExamCalendar,
+			request: tenantRequest("GET", "/api/exams/calendar.ics?examiner=201", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM exams e .+ WHERE e\.institution_id = \? AND e\.starts_at >= \? AND e\.examiner_id = \?`).
+					WithArgs(ownInstitution, sqlmock.AnyArg(), foreignUserID).WillReturnRows(noRows())
+			},
+			empty: func(t *testing.T, body string) {
+				if !strings.Contains(body, "BEGIN:VCALENDAR") || strings.Contains(body, "BEGIN:VEVENT") {
+					t.Errorf("got %q, want a calendar without events", body)
+				}
+			},
+		},
+		{
+			name:    "GET /api/admin/grades/duplicates",
+			handler: FindDuplicates,
+			request: tenantRequest("GET", "/api/admin/grades/duplicates?key=subject,group", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM grades x WHERE x\.institution_id = \?.+WHERE g\.institution_id = \?`).
+					WithArgs(ownInstitution, ownInstitution).WillReturnRows(noRows())
+			},
+			empty: expectJSON("[]"),
+		},
+		{
+			name:    "GET /api/admin/grades/orphaned",
+			handler: ListOrphanedGrades,
+			request: tenantRequest("GET", "/api/admin/grades/orphaned", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`FROM grades g WHERE g\.institution_id = \? AND g\.user_id IS NULL`).
+					WithArgs(ownInstitution).WillReturnRows(noRows())
+			},
+			empty: expectJSON("[]"),
+		},
+		{
+			name:    "GET /api/admin/users",
+			handler: ListUsers,
+			request: tenantRequest("GET", "/api/admin/users", "", nil),
+			expect: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users WHERE institution_id = \?`).
+					WithArgs(ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+				mock.ExpectQuery(`FROM users WHERE institution_id = \? ORDER BY username`).
+					WithArgs(ownInstitution, sqlmock.AnyArg(), sqlmock.AnyArg()).WillReturnRows(noRows())
+			},
+			empty: expectJSON(`{"users":[],"total":0}`),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockTenantDB(t)
+			tt.expect(mock)
+			w := httptest.NewRecorder()
+			tt.handler(w, tt.request)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %d (%s), want 200", w.Code, strings.TrimSpace(w.Body.String()))
+			}
+			tt.empty(t, w.Body.String())
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func expectJSON(want string) func(t *testing.T, body string) {
+	return func(t *testing.T, body string) {
+		t.Helper()
+		if strings.TrimSpace(body) != want {
+			t.Errorf("got %s, want %s", strings.TrimSpace(body), want)
+		}
+	}
+}
+
+// Відкрита реєстрація не дає приєднатися до іншої установи лише за її slug
+func TestRegisterRespectsInstitutionRegistrationMode(t *testing.T) {
+	t.Setenv("REGISTRATION_MODE", models.RegistrationOpen)
+	tests := []struct {
+		name    string
+		setting *string
+		want    int
+	}{
+		{name: "mode not set", want: http.StatusForbidden},
+		{name: "closed", setting: strPtr(models.RegistrationClosed), want: http.StatusForbidden},
+		{name: "invite", setting: strPtr(models.RegistrationInvite), want: http.StatusForbidden},
+		{name: "open", setting: strPtr(models.RegistrationOpen), want: http.StatusCreated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Перевірка імені глобальна, тож тут запити не обов'язково містять institution_id
+			mock := mockDB(t, sqlmock.QueryMatcherRegexp)
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT id FROM institutions WHERE slug = \?`).WithArgs("other-college").
+				WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(foreignInstitution))
+			setting := noRows()
+			if tt.setting != nil {
+				setting = sqlmock.NewRows([]string{"value"}).AddRow(*tt.setting)
+			}
+			mock.ExpectQuery(`SELECT value FROM settings WHERE institution_id = \? AND name = \?`).
+				WithArgs(foreignInstitution, settingRegistrationMode).WillReturnRows(setting)
+			if tt.want == http.StatusCreated {
+				mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM users WHERE username = \?\)`).WithArgs("intruder").
+					WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+				mock.ExpectExec(`INSERT INTO users .+institution_id`).
+					WithArgs("intruder", sqlmock.AnyArg(), sqlmock.AnyArg(), models.RoleTeacher, sqlmock.AnyArg(), foreignInstitution).
+					WillReturnResult(sqlmock.NewResult(30, 1))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			r := httptest.NewRequest("POST", "/api/register", strings.NewReader(
+				`{"username":"intruder","password":"Str0ng-enough-pass","institution":"other-college"}`))
+			w := httptest.NewRecorder()
+			Register(w, r)
+
+			if w.Code != tt.want {
+				t.Errorf("got status %d (%s), want %d", w.Code, strings.TrimSpace(w.Body.String()), tt.want)
+			}
+			// Відмова - до перевірки, чи зайняте ім'я
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"study_grade/auth"
+	"study_grade/config"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/middleware"
+	"study_grade/models"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	oidcCookieName = "sg_oidc"
+	oidcFlowTTL    = 10 * time.Minute
+	ssoTokenTTL    = 2 * time.Minute
+)
+
+// GetLoginMethods повідомляє фронтенду, чи доступний вхід через OpenID Connect
+func GetLoginMethods(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(models.LoginMethodsResponse{
+		Password: true,
+		OIDC:     auth.OIDCProvider != nil,
+		OIDCName: config.String("OIDC_DISPLAY_NAME", "SSO"),
+	})
+}
+
+// OIDCLogin починає вхід: зберігає state, nonce і PKCE verifier у підписаному cookie
+// та перенаправляє браузер на сторінку входу провайдера
+func OIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider := auth.OIDCProvider
+	if provider == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	state, errState := randomURLString(32)
+	nonce, errNonce := randomURLString(32)
+	verifier, errVerifier := randomURLString(32)
+	if errState != nil || errNonce != nil || errVerifier != nil {
+		http.Error(w, "Failed to start single sign-on", http.StatusInternalServerError)
+		return
+	}
+
+	redirect, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Println("Failed to build OIDC authorization URL:", err)
+		http.Error(w, "Identity provider unavailable", http.StatusBadGateway)
+		return
+	}
+
+	expires := time.Now().Add(oidcFlowTTL)
+	flow, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"scope":    "oidc_flow",
+		"state":    state,
+		"nonce":    nonce,
+		"verifier": verifier,
+		"exp":      expires.Unix(),
+	}).SignedString(jwtSecret)
+	if err != nil {
+		http.Error(w, "Failed to start single sign-on", http.StatusInternalServerError)
+		return
+	}
+	// SameSite=Lax: cookie має повернутися з перенаправленням від провайдера
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    flow,
+		Path:     "/api/oidc",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   config.Bool("OIDC_COOKIE_SECURE", r.TLS != nil),
+		SameSite: http.SameSiteLaxMode,
+	})
+	log.Println("Redirecting to identity provider for single sign-on from", r.RemoteAddr)
+	http.Redirect(w, r, redirect, http.StatusFound)
+}
+
+// OIDCCallback завершує вхід після повернення від провайдера і передає фронтенду
+// короткоживучий токен, який обмінюється на звичайну відповідь входу
+func OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider := auth.OIDCProvider
+	if provider == nil {
+		http.Error(w, "Single sign-on is not configured", http.StatusNotFound)
+		return
+	}
+	// Cookie одноразовий незалежно від результату
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Value: "", Path: "/api/oidc", MaxAge: -1, HttpOnly: true})
+
+	q := r.URL.Query()
+	if e := q.Get("error"); e != "" {
+		log.Println("Identity provider returned error:", e, q.Get("error_description"))
+		ssoRedirect(w, r, "sso_error", e)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		log.Println("OIDC callback without flow cookie from", r.RemoteAddr)
+		ssoRedirect(w, r, "sso_error", "session_expired")
+		return
+	}
+	flow, err := parseOIDCFlow(cookie.Value)
+	if err != nil || subtle.ConstantTimeCompare([]byte(flow["state"]), []byte(q.Get("state"))) != 1 {
+		log.Println("OIDC callback state mismatch from", r.RemoteAddr)
+		ssoRedirect(w, r, "sso_error", "invalid_state")
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	user, err := provider.Login(ctx, q.Get("code"), flow["verifier"], flow["nonce"])
+	if err != nil {
+		log.Println("OIDC login failed:", err)
+		metrics.LoginAttemptsTotal.Inc("failure")
+		ssoRedirect(w, r, "sso_error", "login_failed")
+		return
+	}
+	if user.Disabled {
+		log.Println("OIDC login rejected for disabled user ID:", user.ID)
+		metrics.LoginAttemptsTotal.Inc("failure")
+		ssoRedirect(w, r, "sso_error", "account_disabled")
+		return
+	}
+
+	jti, err := randomURLString(32)
+	if err != nil {
+		ssoRedirect(w, r, "sso_error", "server_error")
+		return
+	}
+	// jti робить токен одноразовим: OIDCToken записує його в sso_token_uses
+	claims := scopedClaims(user, middleware.ScopeSSO, ssoTokenTTL)
+	claims["jti"] = jti
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	if err != nil {
+		ssoRedirect(w, r, "sso_error", "server_error")
+		return
+	}
+	log.Println("OIDC login accepted for user ID:", user.ID)
+	ssoRedirect(w, r, "sso_token", token)
+}
+
+// OIDCToken обмінює токен з перенаправлення на відповідь входу (з урахуванням 2FA).
+// Кожен токен приймається лише один раз.
+func OIDCToken(w http.ResponseWriter, r *http.Request) {
+	var req models.SSOTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	claims, err := parseScopedClaims(req.SSOToken, middleware.ScopeSSO)
+	if err != nil {
+		http.Error(w, "Invalid or expired sign-on token", http.StatusUnauthorized)
+		return
+	}
+	userID, tokenVersion, err := claimsUser(claims)
+	jti, _ := claims["jti"].(string)
+	exp, _ := claims["exp"].(float64)
+	if err != nil || jti == "" {
+		http.Error(w, "Invalid or expired sign-on token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	if err := useSSOToken(ctx, jti, time.Unix(int64(exp), 0)); err != nil {
+		if errors.Is(err, errSSOTokenUsed) {
+			log.Println("Rejected reused sign-on token for user ID:", userID)
+			http.Error(w, "Invalid or expired sign-on token", http.StatusUnauthorized)
+			return
+		}
+		metrics.DBErrorsTotal.Inc("login_sso_token_use")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	st, err := loadTOTPState(ctx, userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("login_sso_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if st.tokenVersion != tokenVersion {
+		http.Error(w, "Invalid or expired sign-on token", http.StatusUnauthorized)
+		return
+	}
+	if st.disabled {
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+
+	response, err := completeLogin(ctx, st.user(userID))
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	if !response.MFARequired {
+		metrics.LoginAttemptsTotal.Inc("success")
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+var errSSOTokenUsed = errors.New("sign-on token already used")
+
+// useSSOToken позначає jti використаним; записи з минулим строком дії більше не потрібні,
+// бо такий токен однаково не пройде перевірку exp
+func useSSOToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	now := time.Now().UTC()
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM sso_token_uses WHERE expires_at < ?", now); err != nil {
+		return err
+	}
+	_, err := db.DB.ExecContext(ctx, "INSERT INTO sso_token_uses (jti, expires_at) VALUES (?, ?)", jti, expiresAt.UTC())
+	if isDuplicateKey(err) {
+		return errSSOTokenUsed
+	}
+	return err
+}
+
+func parseOIDCFlow(value string) (map[string]string, error) {
+	token, err := jwt.Parse(value, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, http.ErrNotSupported
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid flow cookie")
+	}
+	claims, _ := token.Claims.(jwt.MapClaims)
+	if claims["scope"] != "oidc_flow" {
+		return nil, errors.New("unexpected flow cookie scope")
+	}
+	flow := map[string]string{}
+	for _, name := range []string{"state", "nonce", "verifier"} {
+		v, _ := claims[name].(string)
+		if v == "" {
+			return nil, errors.New("flow cookie without " + name)
+		}
+		flow[name] = v
+	}
+	return flow, nil
+}
+
+// ssoRedirect повертає браузер на фронтенд; значення передається у фрагменті,
+// який не потрапляє в журнали серверів і заголовок Referer
+func ssoRedirect(w http.ResponseWriter, r *http.Request, key, value string) {
+	target := config.String("OIDC_FRONTEND_URL", "http://localhost:3000/")
+	http.Redirect(w, r, target+"#"+url.Values{key: {value}}.Encode(), http.StatusFound)
+}
+
+func randomURLString(n int) (string, error) {
+	buf := make([]byte, n)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
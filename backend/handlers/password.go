@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"study_grade/auth"
+	"study_grade/config"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+	"study_grade/notify"
+	"study_grade/ratelimit"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordError повертає опис порушення вимог до пароля або порожній рядок
+func passwordError(password string) string {
+	if len(password) < 8 {
+		return "Password must be at least 8 characters"
+	}
+	if len(password) > 72 {
+		// bcrypt враховує лише перші 72 байти
+		return "Password must be at most 72 bytes"
+	}
+	return ""
+}
+
+// ChangePassword змінює пароль після перевірки старого і відкликає інші сесії
+func ChangePassword(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req models.ChangePasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.NewPassword = strings.TrimSpace(req.NewPassword)
+	if msg := passwordError(req.NewPassword); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	var source string
+	if err := db.DB.QueryRowContext(ctx, "SELECT auth_source FROM users WHERE id = ?", userID).Scan(&source); err != nil {
+		metrics.DBErrorsTotal.Inc("password_change")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if source != auth.SourceLocal {
+		log.Println("Password change rejected for", source, "user ID:", userID)
+		http.Error(w, "Password is managed by an external identity provider", http.StatusConflict)
+		return
+	}
+
+	if err := checkPassword(ctx, userID, req.OldPassword); err != nil {
+		log.Println("Password change rejected, old password mismatch for user ID:", userID)
+		http.Error(w, "Invalid old password", http.StatusUnauthorized)
+		return
+	}
+
+	if err := setPassword(ctx, userID, req.NewPassword); err != nil {
+		log.Println("Failed to change password:", err)
+		metrics.DBErrorsTotal.Inc("password_change")
+		http.Error(w, "Failed to change password", http.StatusInternalServerError)
+		return
+	}
+
+	// Інші сесії відкликано, а поточна отримує новий токен
+	st, err := loadTOTPState(ctx, userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("password_change")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	token, err := generateJWT(st.user(userID))
+	if err != nil {
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	log.Println("Password changed for user ID:", userID)
+	json.NewEncoder(w).Encode(models.LoginResponse{Token: token, User: st.user(userID)})
+}
+
+// ForgotPassword надсилає одноразове посилання для скидання пароля.
+// Відповідь однакова незалежно від того, чи існує користувач.
+func ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	login := strings.TrimSpace(req.Login)
+	if login == "" {
+		http.Error(w, "Username or email required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	var userID int
+	var email sql.NullString
+	var source string
+	// Email унікальний, тож збігів не більше двох; точний збіг імені користувача має перевагу
+	// над чужою адресою, що випадково дорівнює цьому імені
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT id, email, auth_source FROM users WHERE username = ? OR email = ? ORDER BY username = ? DESC LIMIT 1", login, login, login,
+	).Scan(&userID, &email, &source)
+	switch {
+	case err == sql.ErrNoRows:
+		log.Println("Password reset requested for unknown login:", login)
+	case err == nil && source != auth.SourceLocal:
+		// Пароль користувача каталогу змінюється лише в самому каталозі
+		log.Println("Password reset requested for", source, "user ID:", userID, "ignored")
+	case err != nil:
+		log.Println("Database error during password reset request:", err)
+		metrics.DBErrorsTotal.Inc("password_forgot_select_user")
+	case !email.Valid || email.String == "":
+		log.Println("Password reset requested for user without email, ID:", userID)
+	default:
+		if err := sendPasswordReset(ctx, userID, email.String); err != nil {
+			log.Println("Failed to issue password reset:", err)
+			metrics.DBErrorsTotal.Inc("password_forgot_issue")
+		}
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "If the account exists, a reset link has been sent"})
+}
+
+func sendPasswordReset(ctx context.Context, userID int, email string) error {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return err
+	}
+	token := hex.EncodeToString(buf)
+	ttl := config.Duration("PASSWORD_RESET_TTL", time.Hour)
+
+	_, err := db.DB.ExecContext(ctx,
+		"INSERT INTO password_reset_tokens (user_id, token_hash, expires_at) VALUES (?, ?, ?)",
+		userID, hashResetToken(token), time.Now().UTC().Add(ttl))
+	if err != nil {
+		return err
+	}
+
+	link := passwordResetURL() + token
+	msg := notify.Message{
+		To:      email,
+		Subject: "Скидання пароля",
+		Body: "Щоб встановити новий пароль, перейдіть за посиланням:\n\n" + link +
+			"\n\nПосилання дійсне " + ttl.String() + " і може бути використане лише один раз.\n" +
+			"Якщо ви не запитували скидання пароля, просто проігноруйте цей лист.",
+	}
+	// Надсилаємо у фоні, щоб час відповіді не видавав існування облікового запису
+	go func() {
+		sendCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := notify.Default.Notify(sendCtx, msg); err != nil {
+			log.Println("Failed to deliver password reset message:", err)
+		}
+	}()
+	log.Println("Password reset token issued for user ID:", userID)
+	return nil
+}
+
+// ResetPassword встановлює новий пароль за одноразовим токеном і відкликає всі сесії
+func ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.NewPassword = strings.TrimSpace(req.NewPassword)
+	if msg := passwordError(req.NewPassword); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, "Failed to hash password", http.StatusInternalServerError)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("password_reset")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	var tokenID, userID int
+	var username string
+	err = tx.QueryRowContext(ctx, `
+		SELECT t.id, t.user_id, u.username FROM password_reset_tokens t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ? AND t.used_at IS NULL AND t.expires_at > ? AND u.auth_source = 'local'
+		FOR UPDATE`,
+		hashResetToken(strings.TrimSpace(req.Token)), time.Now().UTC(),
+	).Scan(&tokenID, &userID, &username)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Invalid or expired reset token", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("password_reset")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Токен одноразовий; інші невикористані токени користувача також анулюються
+	if _, err := tx.ExecContext(ctx, "UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = ? AND used_at IS NULL", userID); err != nil {
+		metrics.DBErrorsTotal.Inc("password_reset")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := storePassword(ctx, tx, userID, hashedPassword); err != nil {
+		metrics.DBErrorsTotal.Inc("password_reset")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		metrics.DBErrorsTotal.Inc("password_reset")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	// Власник щойно підтвердив доступ до пошти - знімаємо блокування входу
+	ratelimit.LoginUsername.Success(r.Context(), "login:user:"+strings.ToLower(username))
+
+	log.Println("Password reset completed for user ID:", userID, "all sessions and API tokens revoked")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setPassword зберігає новий хеш пароля і відкликає всі видані токени
+func setPassword(ctx context.Context, userID int, password string) error {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := storePassword(ctx, tx, userID, hashedPassword); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// storePassword записує хеш пароля, відкликає сесії (token_version) і персональні API-токени:
+// токен, створений тим, хто знав старий пароль, не повинен пережити його зміну
+func storePassword(ctx context.Context, ex execer, userID int, hashedPassword []byte) error {
+	if _, err := ex.ExecContext(ctx, "UPDATE users SET password = ?, must_change_password = FALSE, token_version = token_version + 1 WHERE id = ?", hashedPassword, userID); err != nil {
+		return err
+	}
+	_, err := ex.ExecContext(ctx, "UPDATE api_tokens SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL", userID)
+	return err
+}
+
+// passwordResetURL - початок посилання зі скидання пароля; токен дописується в кінець.
+// За замовчуванням - сторінка входу фронтенду, вбудованого в бекенд (APP_URL), з токеном
+// у фрагменті адреси. Host із запиту не використовується: його може підставити зловмисник.
+func passwordResetURL() string {
+	return config.String("PASSWORD_RESET_URL", strings.TrimRight(config.String("APP_URL", "http://localhost:8080"), "/")+"/#reset_token=")
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
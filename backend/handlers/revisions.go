@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"reflect"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+)
+
+// revisionFields - поля знімка (назви JSON), що порівнюються між ревізіями, у порядку показу
+var revisionFields = []string{
+	"date", "semester", "subject", "group", "academic_year", "exam_id", "total_students",
+	"grade_5", "grade_4", "grade_3", "grade_2", "not_passed", "average_score", "success_rate", "quality_rate", "user_id",
+}
+
+// revisionSnapshot - знімок запису для історії (без полів, що є лише у відповіді на створення)
+func revisionSnapshot(g models.Grade) ([]byte, error) {
+	g.Anomalies, g.BelowTarget = nil, nil
+	return json.Marshal(g)
+}
+
+// recordRevision зберігає знімок версії g.Version; restoredFrom - версія, з якої відновлено запис
+func recordRevision(ctx context.Context, ex execer, institutionID int, g models.Grade, action string, restoredFrom *int, userID int) error {
+	data, err := revisionSnapshot(g)
+	if err != nil {
+		return err
+	}
+	changedBy := sql.NullInt64{Int64: int64(userID), Valid: userID > 0}
+	_, err = ex.ExecContext(ctx,
+		"INSERT INTO grade_revisions (grade_id, institution_id, version, action, restored_from, data, changed_by) VALUES (?, ?, ?, ?, ?, ?, ?)",
+		g.ID, institutionID, g.Version, action, restoredFrom, data, changedBy)
+	return err
+}
+
+// recordBaseline зберігає поточний стан запису, створеного до ведення історії, щоб його теж можна було відновити
+func recordBaseline(ctx context.Context, ex execer, institutionID int, g models.Grade) error {
+	data, err := revisionSnapshot(g)
+	if err != nil {
+		return err
+	}
+	_, err = ex.ExecContext(ctx,
+		"INSERT IGNORE INTO grade_revisions (grade_id, institution_id, version, action, data) VALUES (?, ?, ?, ?, ?)",
+		g.ID, institutionID, g.Version, models.RevisionBaseline, data)
+	return err
+}
+
+// recordDeletion зберігає останній стан видаленого запису як ревізію delete наступної версії
+func recordDeletion(ctx context.Context, ex execer, institutionID int, g models.Grade, userID int) error {
+	if err := recordBaseline(ctx, ex, institutionID, g); err != nil {
+		return err
+	}
+	g.Version++
+	return recordRevision(ctx, ex, institutionID, g, models.RevisionDelete, nil, userID)
+}
+
+// loadDeletedGrade читає знімок видаленого запису установи з його останньої ревізії;
+// sql.ErrNoRows - історії немає або запис не видалено
+func loadDeletedGrade(ctx context.Context, institutionID, id int) (models.Grade, error) {
+	var g models.Grade
+	var action string
+	var data []byte
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT action, data FROM grade_revisions WHERE grade_id = ? AND institution_id = ? ORDER BY version DESC LIMIT 1",
+		id, institutionID).Scan(&action, &data)
+	if err != nil {
+		return g, err
+	}
+	if action != models.RevisionDelete {
+		return g, sql.ErrNoRows
+	}
+	err = json.Unmarshal(data, &g)
+	return g, err
+}
+
+// revisionChanges порівнює два знімки за revisionFields; prev = nil - перша ревізія
+func revisionChanges(prev, next []byte) []models.FieldChange {
+	changes := []models.FieldChange{}
+	var before, after map[string]any
+	json.Unmarshal(next, &after)
+	if prev != nil {
+		json.Unmarshal(prev, &before)
+	}
+	for _, f := range revisionFields {
+		var old any
+		if before != nil {
+			old = before[f]
+		}
+		if before != nil && reflect.DeepEqual(old, after[f]) {
+			continue
+		}
+		if before == nil && after[f] == nil {
+			continue
+		}
+		changes = append(changes, models.FieldChange{Field: f, Old: old, New: after[f]})
+	}
+	return changes
+}
+
+// GetGradeHistory повертає ревізії запису від найновішої з відмінностями від попередньої.
+// Історія видаленого запису теж доступна: остання ревізія в ній - delete.
+func GetGradeHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid grade ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	if _, _, ok := loadGradeOrDeleted(ctx, w, r, id); !ok {
+		return
+	}
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT v.id, v.version, v.action, v.restored_from, v.changed_by, COALESCE(u.username, ''), v.created_at, v.data
+		FROM grade_revisions v
+		LEFT JOIN users u ON u.id = v.changed_by
+		WHERE v.grade_id = ? AND v.institution_id = ?
+		ORDER BY v.version`,
+		id, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_revision_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	revisions := []models.GradeRevision{}
+	var prev []byte
+	for rows.Next() {
+		var v models.GradeRevision
+		var restoredFrom, changedBy sql.NullInt64
+		var data []byte
+		if err := rows.Scan(&v.ID, &v.Version, &v.Action, &restoredFrom, &changedBy, &v.ChangedByName, &v.CreatedAt, &data); err != nil {
+			metrics.DBErrorsTotal.Inc("grade_revision_scan")
+			http.Error(w, "Failed to scan revisions", http.StatusInternalServerError)
+			return
+		}
+		if err := json.Unmarshal(data, &v.Grade); err != nil {
+			log.Printf("Grade %d revision %d has invalid data: %v", id, v.Version, err)
+			metrics.DBErrorsTotal.Inc("grade_revision_scan")
+			http.Error(w, "Failed to read revisions", http.StatusInternalServerError)
+			return
+		}
+		v.RestoredFrom, v.ChangedBy = nullIntPtr(restoredFrom), nullIntPtr(changedBy)
+		v.Changes = revisionChanges(prev, data)
+		prev = data
+		revisions = append(revisions, v)
+	}
+
+	// Найновіші ревізії першими
+	for i, j := 0, len(revisions)-1; i < j; i, j = i+1, j-1 {
+		revisions[i], revisions[j] = revisions[j], revisions[i]
+	}
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// RestoreGrade створює нову версію запису з даних старої ревізії. Як і для оновлення,
+// потрібен If-Match з поточним ETag; відновлені дані проходять ті самі перевірки,
+// а навчальний рік визначається заново за датою. Видалений запис відновлюється з тим самим id
+// (If-Match - ETag його ревізії delete).
+func RestoreGrade(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid grade ID", http.StatusBadRequest)
+		return
+	}
+	var req models.RestoreGradeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Version < 1 {
+		http.Error(w, "version is required", http.StatusBadRequest)
+		return
+	}
+	userID, _ := r.Context().Value("userID").(int)
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	current, deleted, ok := loadGradeOrDeleted(ctx, w, r, id)
+	if !ok || !requireIfMatch(w, r, current) {
+		return
+	}
+	if req.Version == current.Version && !deleted {
+		http.Error(w, "Revision is already the current version", http.StatusBadRequest)
+		return
+	}
+	var data []byte
+	err := db.DB.QueryRowContext(ctx, "SELECT data FROM grade_revisions WHERE grade_id = ? AND institution_id = ? AND version = ?",
+		id, institutionID(r), req.Version).Scan(&data)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Revision not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_revision_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	var grade models.Grade
+	if err := json.Unmarshal(data, &grade); err != nil {
+		log.Printf("Grade %d revision %d has invalid data: %v", id, req.Version, err)
+		http.Error(w, "Revision data is invalid", http.StatusInternalServerError)
+		return
+	}
+	grade.ID, grade.UserID, grade.AcademicYear = current.ID, current.UserID, ""
+	if msg := validateGrade(&grade); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	examID, ok := prepareGrade(ctx, w, r, &grade)
+	if !ok {
+		return
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_restore")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if !rejectDuplicate(ctx, w, r, tx, grade) {
+		return
+	}
+	var result sql.Result
+	if deleted {
+		result, err = reinsertGrade(ctx, tx, institutionID(r), &grade, examID, current.Version+1)
+	} else {
+		if err := recordBaseline(ctx, tx, institutionID(r), current); err != nil {
+			metrics.DBErrorsTotal.Inc("grade_revision_insert")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		result, err = updateGradeRow(ctx, tx, grade, examID, current.Version)
+	}
+	if isDuplicateKey(err) {
+		http.Error(w, "Exam already has a grade record", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("grade_restore")
+		http.Error(w, "Failed to save grade", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Grade record was modified by someone else", http.StatusPreconditionFailed)
+		return
+	}
+	grade.Version = current.Version + 1
+	if err := recordRevision(ctx, tx, institutionID(r), grade, models.RevisionRestore, &req.Version, userID); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_revision_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		metrics.DBErrorsTotal.Inc("grade_restore")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Grade %d restored from version %d as version %d", id, req.Version, grade.Version)
+
+	// Відновлений запис перевіряється так само, як новий
+	grade.Anomalies = flagAnomalies(ctx, institutionID(r), grade)
+	grade.BelowTarget = checkThresholds(ctx, institutionID(r), grade)
+
+	w.Header().Set("ETag", gradeETag(grade))
+	json.NewEncoder(w).Encode(grade)
+}
+
+// reinsertGrade повертає видалений запис у grades з тим самим id і версією version.
+// Викликається під lockGradeWrites: 0 вставлених рядків - запис тим часом відновив інший запит
+// (або видалив знову, і ревізія delete вже не version-1).
+// Якщо власника тим часом видалено, запис повертається без власника.
+func reinsertGrade(ctx context.Context, tx *sql.Tx, institutionID int, grade *models.Grade, examID sql.NullInt64, version int) (sql.Result, error) {
+	var owner sql.NullInt64
+	if grade.UserID > 0 {
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE id = ? AND institution_id = ?)", grade.UserID, institutionID).Scan(&exists); err != nil {
+			return nil, err
+		}
+		if exists {
+			owner = sql.NullInt64{Int64: int64(grade.UserID), Valid: true}
+		} else {
+			log.Printf("Owner %d of deleted grade %d no longer exists, restoring it without owner", grade.UserID, grade.ID)
+			grade.UserID = 0
+		}
+	}
+	return tx.ExecContext(ctx, `
+		INSERT INTO grades (id, date, semester, subject, group_name, total_students, grade_5, grade_4, grade_3, grade_2, not_passed, average_score, success_rate, quality_rate, user_id, institution_id, academic_year, exam_id, version)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ? FROM DUAL
+		WHERE NOT EXISTS (SELECT 1 FROM grades WHERE id = ?)
+			AND (SELECT MAX(version) FROM grade_revisions WHERE grade_id = ? AND institution_id = ?) = ?`,
+		grade.ID, grade.Date, grade.Semester, grade.Subject, grade.Group, grade.TotalStudents, grade.Grade5, grade.Grade4, grade.Grade3, grade.Grade2, grade.NotPassed, grade.AverageScore, grade.SuccessRate, grade.QualityRate, owner, institutionID, grade.AcademicYear, examID, version, grade.ID, grade.ID, institutionID, version-1)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"study_grade/models"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const ownGradeID = 50
+
+func ownGrade(version int) models.Grade {
+	return models.Grade{
+		ID: ownGradeID, Date: time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC), Semester: 1, Subject: "Math", Group: "KN-21",
+		TotalStudents: 2, Grade5: 1, Grade4: 1, AverageScore: 4.5, SuccessRate: 100, QualityRate: 100,
+		UserID: callerID, AcademicYear: "2024/2025", Version: version,
+	}
+}
+
+func gradeRow(g models.Grade) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "date", "semester", "subject", "group_name", "total_students", "grade_5", "grade_4", "grade_3", "grade_2", "not_passed", "average_score", "success_rate", "quality_rate", "user_id", "academic_year", "exam_id", "version"}).
+		AddRow(g.ID, g.Date, g.Semester, g.Subject, g.Group, g.TotalStudents, g.Grade5, g.Grade4, g.Grade3, g.Grade2, g.NotPassed, g.AverageScore, g.SuccessRate, g.QualityRate, g.UserID, g.AcademicYear, nil, g.Version)
+}
+
+func snapshot(t *testing.T, g models.Grade) []byte {
+	t.Helper()
+	data, err := revisionSnapshot(g)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Видалення зберігає останній стан запису ревізією delete в тій самій транзакції
+func TestDeleteGradeRecordsDeleteRevision(t *testing.T) {
+	mock := mockTenantDB(t)
+	mock.ExpectQuery(`FROM grades g WHERE g\.id = \? AND g\.institution_id = \?`).
+		WithArgs(ownGradeID, ownInstitution).WillReturnRows(gradeRow(ownGrade(2)))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM institutions WHERE id = \? FOR UPDATE`).
+		WithArgs(ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(ownInstitution))
+	mock.ExpectExec(`DELETE FROM grades WHERE id = \? AND institution_id = \? AND version = \?`).
+		WithArgs(ownGradeID, ownInstitution, 2).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT IGNORE INTO grade_revisions`).
+		WithArgs(ownGradeID, ownInstitution, 2, models.RevisionBaseline, snapshot(t, ownGrade(2))).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO grade_revisions`).
+		WithArgs(ownGradeID, ownInstitution, 3, models.RevisionDelete, nil, snapshot(t, ownGrade(3)), callerID).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	r := tenantRequest("DELETE", "/api/grades/50", "", idVars(ownGradeID))
+	r.Header.Set("If-Match", `"50-2"`)
+	w := httptest.NewRecorder()
+	DeleteGrade(w, r)
+
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("got status %d, want 204: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Видалений запис відновлюється з тим самим id за ETag ревізії delete
+func TestRestoreDeletedGrade(t *testing.T) {
+	deleted := snapshot(t, ownGrade(3))
+	mock := mockTenantDB(t)
+	mock.ExpectQuery(`FROM grades g WHERE g\.id = \? AND g\.institution_id = \?`).
+		WithArgs(ownGradeID, ownInstitution).WillReturnRows(noRows())
+	mock.ExpectQuery(`SELECT action, data FROM grade_revisions WHERE grade_id = \? AND institution_id = \?`).
+		WithArgs(ownGradeID, ownInstitution).
+		WillReturnRows(sqlmock.NewRows([]string{"action", "data"}).AddRow(models.RevisionDelete, deleted))
+	mock.ExpectQuery(`SELECT data FROM grade_revisions WHERE grade_id = \? AND institution_id = \? AND version = \?`).
+		WithArgs(ownGradeID, ownInstitution, 3).WillReturnRows(sqlmock.NewRows([]string{"data"}).AddRow(deleted))
+	mock.ExpectQuery(`SELECT name FROM academic_years WHERE institution_id = \?`).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT value FROM settings WHERE institution_id = \? AND name = \?`).
+		WithArgs(ownInstitution, settingGradeNaturalKey).WillReturnRows(sqlmock.NewRows([]string{"value"}))
+	mock.ExpectQuery(`SELECT id FROM institutions WHERE id = \? FOR UPDATE`).
+		WithArgs(ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(ownInstitution))
+	mock.ExpectQuery(`SELECT id, user_id FROM grades WHERE institution_id = \? AND id <> \? .+ FOR UPDATE`).
+		WillReturnRows(noRows())
+	mock.ExpectQuery(`SELECT EXISTS\(SELECT 1 FROM users WHERE id = \? AND institution_id = \?\)`).
+		WithArgs(callerID, ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectExec(`INSERT INTO grades \(id, .+ WHERE NOT EXISTS`).
+		WillReturnResult(sqlmock.NewResult(ownGradeID, 1))
+	mock.ExpectExec(`INSERT INTO grade_revisions`).
+		WithArgs(ownGradeID, ownInstitution, 4, models.RevisionRestore, 3, sqlmock.AnyArg(), callerID).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	r := tenantRequest("POST", "/api/grades/50/restore", `{"version":3}`, idVars(ownGradeID))
+	r.Header.Set("If-Match", `"50-3"`)
+	w := httptest.NewRecorder()
+	RestoreGrade(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", w.Code, w.Body.String())
+	}
+	var grade models.Grade
+	if err := json.NewDecoder(w.Body).Decode(&grade); err != nil || grade.ID != ownGradeID || grade.Version != 4 {
+		t.Errorf("got %+v (%v), want grade 50 at version 4", grade, err)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"50-4"` {
+		t.Errorf("got ETag %s, want \"50-4\"", etag)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
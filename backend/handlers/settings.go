@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"study_grade/db"
+)
+
+// Назви налаштувань у таблиці settings
+const (
+	settingTOTPRequiredRoles = "totp_required_roles"
+	settingRegistrationMode  = "registration_mode"
+	settingAlertNotifyEmails = "alert_notify_emails"
+	settingAlertWebhookURL   = "alert_webhook_url"
+)
+
+// getSetting повертає значення налаштування установи; ok=false, якщо його ще не задано
+func getSetting(ctx context.Context, institutionID int, name string) (value string, ok bool, err error) {
+	err = db.DB.QueryRowContext(ctx, "SELECT value FROM settings WHERE institution_id = ? AND name = ?", institutionID, name).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return value, true, nil
+}
+
+// putSetting створює або оновлює налаштування установи
+func putSetting(ctx context.Context, institutionID int, name, value string) error {
+	_, err := db.DB.ExecContext(ctx,
+		"INSERT INTO settings (institution_id, name, value) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value)",
+		institutionID, name, value)
+	return err
+}
@@ -0,0 +1,241 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+)
+
+// unassignedName - вузол для оцінок, групу чи викладача яких не прив'язано до ієрархії
+const unassignedName = "Unassigned"
+
+// gradeHierarchyJoins приєднує до оцінок g їхню групу sg, підрозділ d і факультет f.
+// Підрозділ визначається групою, а для незареєстрованих груп - підрозділом викладача.
+const gradeHierarchyJoins = `
+		LEFT JOIN study_groups sg ON sg.institution_id = g.institution_id AND sg.name = g.group_name
+		LEFT JOIN users u ON u.id = g.user_id
+		LEFT JOIN departments d ON d.id = COALESCE(sg.department_id, u.department_id)
+		LEFT JOIN faculties f ON f.id = d.faculty_id`
+
+// childLevel - рівень, на який розкривається вузол статистики
+var childLevel = map[string]string{
+	models.StatsLevelInstitution: models.StatsLevelFaculty,
+	models.StatsLevelFaculty:     models.StatsLevelDepartment,
+	models.StatsLevelDepartment:  models.StatsLevelGroup,
+	models.StatsLevelGroup:       models.StatsLevelSubject,
+}
+
+// calculateAverages обчислює середній бал, успішність і якість (у відсотках).
+// Для зведених показників передаються сумарні кількості, тому результат зважений за студентами.
+func calculateAverages(total, grade5, grade4, grade3, grade2 int) (average, success, quality float64) {
+	if total <= 0 {
+		return 0, 0, 0
+	}
+	average = float64(grade5*5+grade4*4+grade3*3+grade2*2) / float64(total)
+	success = float64(grade5+grade4+grade3) / float64(total) * 100
+	quality = float64(grade5+grade4) / float64(total) * 100
+	return average, success, quality
+}
+
+// statsRow - сумарні кількості для пари група/предмет разом із розташуванням групи в ієрархії
+type statsRow struct {
+	facultyID, departmentID, groupID sql.NullInt64
+	facultyName, departmentName      sql.NullString
+	group, subject                   string
+	counts                           models.StatsNode
+}
+
+// child повертає ідентифікатор і назву дочірнього вузла, до якого належить рядок
+func (row statsRow) child(level string) (*int, string) {
+	switch level {
+	case models.StatsLevelFaculty:
+		return nullIntPtr(row.facultyID), nullName(row.facultyName)
+	case models.StatsLevelDepartment:
+		return nullIntPtr(row.departmentID), nullName(row.departmentName)
+	case models.StatsLevelGroup:
+		return nullIntPtr(row.groupID), row.group
+	default:
+		return nil, row.subject
+	}
+}
+
+func nullName(v sql.NullString) string {
+	if !v.Valid {
+		return unassignedName
+	}
+	return v.String
+}
+
+// unassigned - вузол факультету чи підрозділу для записів поза ієрархією
+func unassigned(n models.StatsNode) bool {
+	return n.ID == nil && (n.Level == models.StatsLevelFaculty || n.Level == models.StatsLevelDepartment)
+}
+
+// addCounts додає кількості одного вузла до іншого
+func addCounts(n *models.StatsNode, c models.StatsNode) {
+	n.Records += c.Records
+	n.TotalStudents += c.TotalStudents
+	n.Grade5 += c.Grade5
+	n.Grade4 += c.Grade4
+	n.Grade3 += c.Grade3
+	n.Grade2 += c.Grade2
+	n.NotPassed += c.NotPassed
+}
+
+func finishNode(n *models.StatsNode) {
+	n.AverageScore, n.SuccessRate, n.QualityRate = calculateAverages(n.TotalStudents, n.Grade5, n.Grade4, n.Grade3, n.Grade2)
+}
+
+// ownRecordsOnly обмежує аналітику викладача його власними записами, як у GetGrades та експорті:
+// показники груп колег доступні лише адміністраторам установи
+func ownRecordsOnly(r *http.Request, where []string, args []any) ([]string, []any) {
+	if isInstitutionAdmin(r) {
+		return where, args
+	}
+	userID, _ := r.Context().Value("userID").(int)
+	return append(where, "g.user_id = ?"), append(args, userID)
+}
+
+// GetStats повертає зведені показники вузла ієрархії установи та його дочірніх вузлів.
+// level: institution (за замовчуванням), faculty, department або group;
+// для faculty і department потрібен id (0 - записи поза ієрархією), для group - group (назва).
+// Необов'язкові academic_year і semester обмежують період.
+// Викладач отримує показники лише за власними записами, адміністратор - за всіма записами установи.
+func GetStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	level := q.Get("level")
+	if level == "" {
+		level = models.StatsLevelInstitution
+	}
+	if _, ok := childLevel[level]; !ok {
+		http.Error(w, "level must be institution, faculty, department or group", http.StatusBadRequest)
+		return
+	}
+
+	where, args, msg := periodFilter(q, []string{"g.institution_id = ?"}, []any{institutionID(r)})
+	if msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	where, args = ownRecordsOnly(r, where, args)
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	node := models.StatsNode{Level: level}
+	switch level {
+	case models.StatsLevelInstitution:
+		id := institutionID(r)
+		node.ID = &id
+		if err := db.DB.QueryRowContext(ctx, "SELECT name FROM institutions WHERE id = ?", id).Scan(&node.Name); err != nil {
+			metrics.DBErrorsTotal.Inc("stats_select_node")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+	case models.StatsLevelFaculty, models.StatsLevelDepartment:
+		id, err := strconv.Atoi(q.Get("id"))
+		if err != nil || id < 0 {
+			http.Error(w, "id is required (0 for records outside the hierarchy)", http.StatusBadRequest)
+			return
+		}
+		table, column, notFound := "faculties", "f.id", "Faculty not found"
+		if level == models.StatsLevelDepartment {
+			table, column, notFound = "departments", "d.id", "Department not found"
+		}
+		if id == 0 {
+			node.Name = unassignedName
+			where = append(where, column+" IS NULL")
+			break
+		}
+		err = db.DB.QueryRowContext(ctx, "SELECT name FROM "+table+" WHERE id = ? AND institution_id = ?", id, institutionID(r)).Scan(&node.Name)
+		if err == sql.ErrNoRows {
+			http.Error(w, notFound, http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("stats_select_node")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		node.ID = &id
+		where = append(where, column+" = ?")
+		args = append(args, id)
+	case models.StatsLevelGroup:
+		node.Name = q.Get("group")
+		if node.Name == "" {
+			http.Error(w, "group is required", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "g.group_name = ?")
+		args = append(args, node.Name)
+	}
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT f.id, f.name, d.id, d.name, sg.id, g.group_name, g.subject,
+			COUNT(*), SUM(g.total_students), SUM(g.grade_5), SUM(g.grade_4), SUM(g.grade_3), SUM(g.grade_2), SUM(g.not_passed)
+		FROM grades g`+gradeHierarchyJoins+`
+		WHERE `+strings.Join(where, " AND ")+`
+		GROUP BY f.id, f.name, d.id, d.name, sg.id, g.group_name, g.subject`,
+		args...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("stats_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	children := map[string]*models.StatsNode{}
+	for rows.Next() {
+		var row statsRow
+		c := &row.counts
+		if err := rows.Scan(&row.facultyID, &row.facultyName, &row.departmentID, &row.departmentName, &row.groupID, &row.group, &row.subject,
+			&c.Records, &c.TotalStudents, &c.Grade5, &c.Grade4, &c.Grade3, &c.Grade2, &c.NotPassed); err != nil {
+			metrics.DBErrorsTotal.Inc("stats_scan")
+			http.Error(w, "Failed to scan stats", http.StatusInternalServerError)
+			return
+		}
+		if level == models.StatsLevelGroup && node.ID == nil {
+			node.ID = nullIntPtr(row.groupID)
+		}
+		addCounts(&node, row.counts)
+
+		id, name := row.child(childLevel[level])
+		key := "name:" + name
+		if id != nil {
+			key = "id:" + strconv.Itoa(*id)
+		}
+		child, ok := children[key]
+		if !ok {
+			child = &models.StatsNode{Level: childLevel[level], ID: id, Name: name}
+			children[key] = child
+		}
+		addCounts(child, row.counts)
+	}
+	if err := rows.Err(); err != nil {
+		metrics.DBErrorsTotal.Inc("stats_scan")
+		http.Error(w, "Failed to scan stats", http.StatusInternalServerError)
+		return
+	}
+
+	node.Children = make([]models.StatsNode, 0, len(children))
+	for _, child := range children {
+		finishNode(child)
+		node.Children = append(node.Children, *child)
+	}
+	// За назвою; записи поза ієрархією - в кінці
+	sort.Slice(node.Children, func(i, j int) bool {
+		a, b := node.Children[i], node.Children[j]
+		if unassigned(a) != unassigned(b) {
+			return unassigned(b)
+		}
+		return a.Name < b.Name
+	})
+	finishNode(&node)
+	json.NewEncoder(w).Encode(node)
+}
@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"study_grade/models"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// teacherRequest - запит викладача установи 1
+func teacherRequest(method, target string) *http.Request {
+	r := tenantRequest(method, target, "", nil)
+	return r.WithContext(context.WithValue(r.Context(), "userRole", models.RoleTeacher))
+}
+
+// Викладач бачить аналітику лише за власними записами
+func TestGetStatsScopesTeacherToOwnRecords(t *testing.T) {
+	mock := mockTenantDB(t)
+	mock.ExpectQuery(`FROM grades g .+ WHERE g\.institution_id = \? AND g\.user_id = \? AND g\.group_name = \?`).
+		WithArgs(ownInstitution, callerID, "KN-21").WillReturnRows(sqlmock.NewRows(nil))
+
+	w := httptest.NewRecorder()
+	GetStats(w, teacherRequest("GET", "/api/stats?level=group&group=KN-21"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetTrendsScopesTeacherToOwnRecords(t *testing.T) {
+	mock := mockTenantDB(t)
+	mock.ExpectQuery(`FROM grades g\s+WHERE g\.institution_id = \? AND g\.user_id = \? AND g\.subject = \?`).
+		WithArgs(ownInstitution, callerID, "Math").WillReturnRows(sqlmock.NewRows(nil))
+
+	w := httptest.NewRecorder()
+	GetTrends(w, teacherRequest("GET", "/api/stats/trends?subject=Math"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestGetGroupComparisonScopesTeacherToOwnRecords(t *testing.T) {
+	mock := mockTenantDB(t)
+	mock.ExpectQuery(`FROM grades g .+ WHERE g\.institution_id = \? AND g\.user_id = \? AND g\.subject = \? AND g\.semester = \?`).
+		WithArgs(ownInstitution, callerID, "Math", 1).WillReturnRows(sqlmock.NewRows(nil))
+
+	w := httptest.NewRecorder()
+	GetGroupComparison(w, teacherRequest("GET", "/api/stats/compare?subject=Math&semester=1"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %d, want 200: %s", w.Code, w.Body.String())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,541 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"study_grade/config"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+	"study_grade/notify"
+	"time"
+)
+
+// metricMax - допустимі показники порогів і їхні максимальні значення
+var metricMax = map[string]float64{
+	models.MetricAverageScore: 5,
+	models.MetricSuccessRate:  100,
+	models.MetricQualityRate:  100,
+}
+
+func metricValue(g models.Grade, metric string) float64 {
+	switch metric {
+	case models.MetricAverageScore:
+		return g.AverageScore
+	case models.MetricSuccessRate:
+		return g.SuccessRate
+	default:
+		return g.QualityRate
+	}
+}
+
+// ListThresholds повертає цільові пороги установи
+func ListThresholds(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT id, department_id, subject, metric, min_value, created_at FROM grade_thresholds WHERE institution_id = ? ORDER BY metric, department_id, subject",
+		institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("threshold_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	thresholds := []models.Threshold{}
+	for rows.Next() {
+		var t models.Threshold
+		var departmentID sql.NullInt64
+		var subject sql.NullString
+		if err := rows.Scan(&t.ID, &departmentID, &subject, &t.Metric, &t.MinValue, &t.CreatedAt); err != nil {
+			metrics.DBErrorsTotal.Inc("threshold_scan")
+			http.Error(w, "Failed to scan thresholds", http.StatusInternalServerError)
+			return
+		}
+		t.DepartmentID = nullIntPtr(departmentID)
+		t.Subject = subject.String
+		thresholds = append(thresholds, t)
+	}
+	json.NewEncoder(w).Encode(thresholds)
+}
+
+// CreateThreshold задає мінімальне цільове значення показника для установи, підрозділу та/або предмета
+func CreateThreshold(w http.ResponseWriter, r *http.Request) {
+	var t models.Threshold
+	if err := json.NewDecoder(r.Body).Decode(&t); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	maxValue, ok := metricMax[t.Metric]
+	if !ok {
+		http.Error(w, "Metric must be average_score, success_rate or quality_rate", http.StatusBadRequest)
+		return
+	}
+	if t.MinValue < 0 || t.MinValue > maxValue {
+		http.Error(w, fmt.Sprintf("min_value must be between 0 and %g", maxValue), http.StatusBadRequest)
+		return
+	}
+	t.Subject = strings.TrimSpace(t.Subject)
+	if len(t.Subject) > 100 {
+		http.Error(w, "Subject must be at most 100 characters", http.StatusBadRequest)
+		return
+	}
+	subject := sql.NullString{String: t.Subject, Valid: t.Subject != ""}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	departmentID, ok := optionalParent(ctx, w, r, "departments", t.DepartmentID, "Department not found")
+	if !ok {
+		return
+	}
+	// NULL у складеному унікальному ключі MySQL не порівнюється, тому дублікат шукаємо через <=>
+	var exists bool
+	if err := db.DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM grade_thresholds WHERE institution_id = ? AND metric = ? AND department_id <=> ? AND subject <=> ?)",
+		institutionID(r), t.Metric, departmentID, subject).Scan(&exists); err != nil {
+		metrics.DBErrorsTotal.Inc("threshold_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if exists {
+		http.Error(w, "Threshold for this metric and scope already exists", http.StatusConflict)
+		return
+	}
+
+	t.CreatedAt = time.Now().UTC().Truncate(time.Second)
+	result, err := db.DB.ExecContext(ctx,
+		"INSERT INTO grade_thresholds (institution_id, department_id, subject, metric, min_value, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		institutionID(r), departmentID, subject, t.Metric, t.MinValue, t.CreatedAt)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("threshold_insert")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	id, _ := result.LastInsertId()
+	t.ID = int(id)
+	t.DepartmentID = nullIntPtr(departmentID)
+	log.Printf("Threshold %d created: %s >= %g", t.ID, t.Metric, t.MinValue)
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(t)
+}
+
+// DeleteThreshold видаляє поріг; вже створені сповіщення зберігаються
+func DeleteThreshold(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid threshold ID", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx, "DELETE FROM grade_thresholds WHERE id = ? AND institution_id = ?", id, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("threshold_delete")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Threshold not found", http.StatusNotFound)
+		return
+	}
+	log.Println("Threshold deleted:", id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// evaluateThresholds порівнює показники запису з найконкретнішими порогами його підрозділу та предмета
+func evaluateThresholds(ctx context.Context, institutionID int, grade models.Grade) ([]models.ThresholdBreach, error) {
+	var departmentID sql.NullInt64
+	if err := db.DB.QueryRowContext(ctx, `
+		SELECT d.id FROM grades g`+gradeHierarchyJoins+`
+		WHERE g.id = ?`, grade.ID).Scan(&departmentID); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT id, department_id, subject, metric, min_value FROM grade_thresholds
+		WHERE institution_id = ? AND (department_id IS NULL OR department_id = ?) AND (subject IS NULL OR subject = ?)`,
+		institutionID, departmentID, grade.Subject)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type candidate struct {
+		id          int
+		target      float64
+		specificity int
+	}
+	best := map[string]candidate{}
+	for rows.Next() {
+		var c candidate
+		var thresholdDepartment sql.NullInt64
+		var subject sql.NullString
+		var metric string
+		if err := rows.Scan(&c.id, &thresholdDepartment, &subject, &metric, &c.target); err != nil {
+			return nil, err
+		}
+		if subject.Valid {
+			c.specificity += 2
+		}
+		if thresholdDepartment.Valid {
+			c.specificity++
+		}
+		if prev, ok := best[metric]; !ok || c.specificity > prev.specificity {
+			best[metric] = c
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var breaches []models.ThresholdBreach
+	for _, metric := range []string{models.MetricAverageScore, models.MetricSuccessRate, models.MetricQualityRate} {
+		c, ok := best[metric]
+		if !ok || metricValue(grade, metric) >= c.target {
+			continue
+		}
+		id := c.id
+		breaches = append(breaches, models.ThresholdBreach{ThresholdID: &id, Metric: metric, Target: c.target, Value: metricValue(grade, metric)})
+	}
+	return breaches, nil
+}
+
+// checkThresholds зводить сповіщення запису до поточних показників нижче цілі і сповіщає адресатів
+// лише про нові порушення. Повторна перевірка після зміни чи відновлення не дублює сповіщення,
+// а відкриті сповіщення показників, що вже досягли цілі, знімаються.
+// Запис не блокується: помилки перевірки лише логуються.
+func checkThresholds(ctx context.Context, institutionID int, grade models.Grade) []models.ThresholdBreach {
+	breaches, err := evaluateThresholds(ctx, institutionID, grade)
+	if err != nil {
+		log.Println("Threshold check failed for grade", grade.ID, ":", err)
+		metrics.DBErrorsTotal.Inc("threshold_evaluate")
+		return nil
+	}
+	fresh, err := syncAlerts(ctx, institutionID, grade.ID, breaches)
+	if err != nil {
+		log.Println("Failed to save alerts for grade", grade.ID, ":", err)
+		metrics.DBErrorsTotal.Inc("alert_insert")
+		return breaches
+	}
+	if len(breaches) > 0 {
+		log.Printf("Grade %d is below target on %d metric(s), %d new", grade.ID, len(breaches), len(fresh))
+	}
+	if len(fresh) > 0 {
+		go sendThresholdAlerts(institutionID, grade, fresh)
+	}
+	return breaches
+}
+
+// syncAlerts в одній транзакції оновлює відкриті сповіщення запису за поточними порушеннями,
+// видаляє відкриті сповіщення показників без порушення і створює сповіщення для решти.
+// Порушення з тими самими ціллю і значенням, що й останнє опрацьоване сповіщення показника,
+// вже переглянуте адміністратором і повторно не створюється. Повертає нові порушення.
+func syncAlerts(ctx context.Context, institutionID, gradeID int, breaches []models.ThresholdBreach) ([]models.ThresholdBreach, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	// Зміна і відновлення запису можуть перевіряти пороги паралельно
+	if err := lockGradeWrites(ctx, tx, institutionID); err != nil {
+		return nil, err
+	}
+
+	type alert struct {
+		id            int
+		target, value float32
+	}
+	open := map[string]alert{}
+	acknowledged := map[string]alert{}
+	var stale []int
+	rows, err := tx.QueryContext(ctx,
+		"SELECT id, metric, target, value, acknowledged_at IS NOT NULL FROM grade_alerts WHERE grade_id = ? AND institution_id = ? ORDER BY id",
+		gradeID, institutionID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var a alert
+		var metric string
+		var isAcknowledged bool
+		if err := rows.Scan(&a.id, &metric, &a.target, &a.value, &isAcknowledged); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		switch {
+		case isAcknowledged:
+			acknowledged[metric] = a
+		case open[metric].id != 0:
+			stale = append(stale, a.id)
+		default:
+			open[metric] = a
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var fresh []models.ThresholdBreach
+	for _, b := range breaches {
+		if a, ok := open[b.Metric]; ok {
+			delete(open, b.Metric)
+			if _, err := tx.ExecContext(ctx,
+				"UPDATE grade_alerts SET threshold_id = ?, target = ?, value = ? WHERE id = ? AND institution_id = ?",
+				b.ThresholdID, b.Target, b.Value, a.id, institutionID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if a, ok := acknowledged[b.Metric]; ok && a.target == float32(b.Target) && a.value == float32(b.Value) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO grade_alerts (grade_id, institution_id, threshold_id, metric, target, value) VALUES (?, ?, ?, ?, ?, ?)",
+			gradeID, institutionID, b.ThresholdID, b.Metric, b.Target, b.Value); err != nil {
+			return nil, err
+		}
+		fresh = append(fresh, b)
+	}
+	for _, a := range open {
+		stale = append(stale, a.id)
+	}
+	sort.Ints(stale)
+	for _, id := range stale {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM grade_alerts WHERE id = ? AND institution_id = ?", id, institutionID); err != nil {
+			return nil, err
+		}
+	}
+	return fresh, tx.Commit()
+}
+
+// thresholdAlertPayload - тіло вебхука про показники нижче цільових
+type thresholdAlertPayload struct {
+	Event         string                   `json:"event"`
+	InstitutionID int                      `json:"institution_id"`
+	Grade         models.Grade             `json:"grade"`
+	Breaches      []models.ThresholdBreach `json:"breaches"`
+}
+
+// loadAlertRecipients повертає адресатів сповіщень установи. Якщо їх не задано, основна установа
+// використовує ALERT_NOTIFY_EMAILS і ALERT_WEBHOOK_URL, а решта не сповіщає нікого,
+// щоб дані однієї установи не потрапляли до адресатів іншої.
+func loadAlertRecipients(ctx context.Context, institutionID int) (models.AlertRecipients, error) {
+	recipients := models.AlertRecipients{Emails: []string{}}
+	emails, emailsSet, err := getSetting(ctx, institutionID, settingAlertNotifyEmails)
+	if err != nil {
+		return recipients, err
+	}
+	webhookURL, webhookSet, err := getSetting(ctx, institutionID, settingAlertWebhookURL)
+	if err != nil {
+		return recipients, err
+	}
+	if institutionID == db.DefaultInstitutionID {
+		if !emailsSet {
+			emails = strings.Join(config.List("ALERT_NOTIFY_EMAILS", nil), ",")
+		}
+		if !webhookSet {
+			webhookURL = config.String("ALERT_WEBHOOK_URL", "")
+		}
+	}
+	for _, email := range strings.Split(emails, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			recipients.Emails = append(recipients.Emails, email)
+		}
+	}
+	recipients.WebhookURL = webhookURL
+	return recipients, nil
+}
+
+// sendThresholdAlerts сповіщає адресатів установи поштою та вебхуком
+func sendThresholdAlerts(institutionID int, grade models.Grade, breaches []models.ThresholdBreach) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	recipients, err := loadAlertRecipients(ctx, institutionID)
+	if err != nil {
+		log.Println("Failed to load alert recipients of institution", institutionID, ":", err)
+		metrics.DBErrorsTotal.Inc("alert_recipients")
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Результати групи %s з предмета %q (семестр %d, %s) нижче цільових показників:\n\n",
+		grade.Group, grade.Subject, grade.Semester, grade.Date.Format("2006-01-02"))
+	for _, breach := range breaches {
+		fmt.Fprintf(&b, "- %s: %.2f (ціль %.2f)\n", breach.Metric, breach.Value, breach.Target)
+	}
+	for _, to := range recipients.Emails {
+		msg := notify.Message{To: to, Subject: "Показники нижче цільових: " + grade.Subject + ", " + grade.Group, Body: b.String()}
+		if err := notify.Default.Notify(ctx, msg); err != nil {
+			log.Println("Failed to deliver threshold alert:", err)
+		}
+	}
+	if recipients.WebhookURL != "" {
+		payload := thresholdAlertPayload{Event: "grade.below_target", InstitutionID: institutionID, Grade: grade, Breaches: breaches}
+		if err := notify.Webhook(ctx, recipients.WebhookURL, payload); err != nil {
+			log.Println("Failed to deliver threshold alert webhook:", err)
+		}
+	}
+}
+
+// GetAlertRecipients повертає адресатів сповіщень установи про показники нижче цільових
+func GetAlertRecipients(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	recipients, err := loadAlertRecipients(ctx, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("alert_recipients")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(recipients)
+}
+
+// UpdateAlertRecipients задає адресатів сповіщень установи; порожні значення вимикають канал
+func UpdateAlertRecipients(w http.ResponseWriter, r *http.Request) {
+	var recipients models.AlertRecipients
+	if err := json.NewDecoder(r.Body).Decode(&recipients); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	emails := []string{}
+	for _, email := range recipients.Emails {
+		email = strings.TrimSpace(email)
+		if err := validate.Var(email, "required,email,max=255"); err != nil {
+			http.Error(w, "Invalid email: "+email, http.StatusBadRequest)
+			return
+		}
+		emails = append(emails, email)
+	}
+	recipients.Emails = emails
+	recipients.WebhookURL = strings.TrimSpace(recipients.WebhookURL)
+	if recipients.WebhookURL != "" {
+		if err := validate.Var(recipients.WebhookURL, "http_url,max=1000"); err != nil {
+			http.Error(w, "webhook_url must be an http(s) URL", http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	if err := putSetting(ctx, institutionID(r), settingAlertNotifyEmails, strings.Join(recipients.Emails, ",")); err != nil {
+		metrics.DBErrorsTotal.Inc("alert_recipients")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if err := putSetting(ctx, institutionID(r), settingAlertWebhookURL, recipients.WebhookURL); err != nil {
+		metrics.DBErrorsTotal.Inc("alert_recipients")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	log.Println("Alert recipients of institution", institutionID(r), "updated:", len(recipients.Emails), "email(s), webhook set:", recipients.WebhookURL != "")
+	json.NewEncoder(w).Encode(recipients)
+}
+
+// ListAlerts повертає записи нижче цільових показників; status: open (за замовчуванням),
+// acknowledged або all; необов'язковий фільтр metric
+func ListAlerts(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	where := []string{"a.institution_id = ?"}
+	args := []any{institutionID(r)}
+	switch q.Get("status") {
+	case "", "open":
+		where = append(where, "a.acknowledged_at IS NULL")
+	case "acknowledged":
+		where = append(where, "a.acknowledged_at IS NOT NULL")
+	case "all":
+	default:
+		http.Error(w, "Invalid status filter", http.StatusBadRequest)
+		return
+	}
+	if metric := q.Get("metric"); metric != "" {
+		if _, ok := metricMax[metric]; !ok {
+			http.Error(w, "Invalid metric filter", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "a.metric = ?")
+		args = append(args, metric)
+	}
+	limit, offset, ok := pagination(q.Get("limit"), q.Get("offset"))
+	if !ok {
+		http.Error(w, "Invalid pagination parameters", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT a.id, a.threshold_id, a.metric, a.target, a.value, a.created_at, a.acknowledged_by, a.acknowledged_at, `+gradeColumns+`
+		FROM grade_alerts a JOIN grades g ON g.id = a.grade_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY a.created_at DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("alert_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	alerts := []models.GradeAlert{}
+	for rows.Next() {
+		var a models.GradeAlert
+		var thresholdID, acknowledgedBy sql.NullInt64
+		var acknowledgedAt sql.NullTime
+		g, err := scanGrade(rows, &a.ID, &thresholdID, &a.Metric, &a.Target, &a.Value, &a.CreatedAt, &acknowledgedBy, &acknowledgedAt)
+		if err != nil {
+			metrics.DBErrorsTotal.Inc("alert_scan")
+			http.Error(w, "Failed to scan alerts", http.StatusInternalServerError)
+			return
+		}
+		a.Grade = g
+		a.ThresholdID = nullIntPtr(thresholdID)
+		a.AcknowledgedBy = nullIntPtr(acknowledgedBy)
+		a.AcknowledgedAt = nullTimePtr(acknowledgedAt)
+		alerts = append(alerts, a)
+	}
+	json.NewEncoder(w).Encode(alerts)
+}
+
+// AcknowledgeAlert позначає сповіщення опрацьованим
+func AcknowledgeAlert(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(r, "id")
+	if !ok {
+		http.Error(w, "Invalid alert ID", http.StatusBadRequest)
+		return
+	}
+	adminID, _ := r.Context().Value("userID").(int)
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx,
+		"UPDATE grade_alerts SET acknowledged_by = ?, acknowledged_at = NOW() WHERE id = ? AND institution_id = ? AND acknowledged_at IS NULL",
+		adminID, id, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("alert_acknowledge")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		http.Error(w, "Alert not found or already acknowledged", http.StatusNotFound)
+		return
+	}
+	log.Println("Alert", id, "acknowledged by admin", adminID)
+	w.WriteHeader(http.StatusNoContent)
+}
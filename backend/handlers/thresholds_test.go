@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"study_grade/db"
+	"study_grade/models"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Глобальні адресати з оточення отримують сповіщення лише основної установи
+func TestLoadAlertRecipientsEnvFallbackOnlyForDefaultInstitution(t *testing.T) {
+	t.Setenv("ALERT_NOTIFY_EMAILS", "dean@example.com")
+	t.Setenv("ALERT_WEBHOOK_URL", "https://hooks.example.com/alerts")
+	tests := []struct {
+		name        string
+		institution int
+		wantEmails  []string
+		wantWebhook string
+	}{
+		{name: "default institution", institution: db.DefaultInstitutionID, wantEmails: []string{"dean@example.com"}, wantWebhook: "https://hooks.example.com/alerts"},
+		{name: "other institution", institution: foreignInstitution, wantEmails: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockTenantDB(t)
+			mock.ExpectQuery(`SELECT value FROM settings WHERE institution_id = \? AND name = \?`).
+				WithArgs(tt.institution, settingAlertNotifyEmails).WillReturnRows(sqlmock.NewRows([]string{"value"}))
+			mock.ExpectQuery(`SELECT value FROM settings WHERE institution_id = \? AND name = \?`).
+				WithArgs(tt.institution, settingAlertWebhookURL).WillReturnRows(sqlmock.NewRows([]string{"value"}))
+
+			recipients, err := loadAlertRecipients(context.Background(), tt.institution)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(recipients.Emails, tt.wantEmails) || recipients.WebhookURL != tt.wantWebhook {
+				t.Errorf("got %+v, want emails %v and webhook %q", recipients, tt.wantEmails, tt.wantWebhook)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// Власні адресати установи замінюють глобальні, навіть якщо вони порожні
+func TestLoadAlertRecipientsPrefersInstitutionSettings(t *testing.T) {
+	t.Setenv("ALERT_NOTIFY_EMAILS", "dean@example.com")
+	t.Setenv("ALERT_WEBHOOK_URL", "https://hooks.example.com/alerts")
+	mock := mockTenantDB(t)
+	mock.ExpectQuery(`SELECT value FROM settings WHERE institution_id = \? AND name = \?`).
+		WithArgs(ownInstitution, settingAlertNotifyEmails).WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow("head@college.example, qa@college.example"))
+	mock.ExpectQuery(`SELECT value FROM settings WHERE institution_id = \? AND name = \?`).
+		WithArgs(ownInstitution, settingAlertWebhookURL).WillReturnRows(sqlmock.NewRows([]string{"value"}).AddRow(""))
+
+	recipients, err := loadAlertRecipients(context.Background(), ownInstitution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"head@college.example", "qa@college.example"}; !reflect.DeepEqual(recipients.Emails, want) || recipients.WebhookURL != "" {
+		t.Errorf("got %+v, want emails %v and no webhook", recipients, want)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUpdateAlertRecipientsValidates(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "invalid email", body: `{"emails":["not-an-email"]}`},
+		{name: "non-http webhook", body: `{"webhook_url":"file:///etc/passwd"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockTenantDB(t)
+
+			w := httptest.NewRecorder()
+			UpdateAlertRecipients(w, tenantRequest("PUT", "/api/admin/alert-recipients", tt.body, nil))
+
+			if w.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want 400: %s", w.Code, w.Body.String())
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func alertRows() *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "metric", "target", "value", "acknowledged"})
+}
+
+// Повторна перевірка оновлює відкрите сповіщення, не повторює опрацьоване
+// і знімає сповіщення показника, що вже досяг цілі
+func TestSyncAlertsReplacesOpenAlerts(t *testing.T) {
+	thresholdID := 3
+	breaches := []models.ThresholdBreach{
+		{ThresholdID: &thresholdID, Metric: models.MetricAverageScore, Target: 4, Value: 3.5},
+		{Metric: models.MetricSuccessRate, Target: 90, Value: 50},
+	}
+	mock := mockTenantDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM institutions WHERE id = \? FOR UPDATE`).
+		WithArgs(ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(ownInstitution))
+	mock.ExpectQuery(`SELECT id, metric, target, value, acknowledged_at IS NOT NULL FROM grade_alerts WHERE grade_id = \? AND institution_id = \?`).
+		WithArgs(ownGradeID, ownInstitution).
+		WillReturnRows(alertRows().
+			AddRow(1, models.MetricAverageScore, 4, 3.0, false).
+			AddRow(2, models.MetricAverageScore, 4, 3.0, false).
+			AddRow(3, models.MetricSuccessRate, 90, 50, true).
+			AddRow(4, models.MetricQualityRate, 60, 40, false))
+	mock.ExpectExec(`UPDATE grade_alerts SET threshold_id = \?, target = \?, value = \? WHERE id = \? AND institution_id = \?`).
+		WithArgs(&thresholdID, 4.0, 3.5, 1, ownInstitution).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM grade_alerts WHERE id = \? AND institution_id = \?`).
+		WithArgs(2, ownInstitution).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM grade_alerts WHERE id = \? AND institution_id = \?`).
+		WithArgs(4, ownInstitution).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	fresh, err := syncAlerts(context.Background(), ownInstitution, ownGradeID, breaches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fresh) != 0 {
+		t.Errorf("got new breaches %+v, want none", fresh)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Нове порушення або зміна значення після опрацювання створює сповіщення, про яке сповіщають
+func TestSyncAlertsReportsNewBreaches(t *testing.T) {
+	breaches := []models.ThresholdBreach{{Metric: models.MetricSuccessRate, Target: 90, Value: 40}}
+	mock := mockTenantDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT id FROM institutions WHERE id = \? FOR UPDATE`).
+		WithArgs(ownInstitution).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(ownInstitution))
+	mock.ExpectQuery(`FROM grade_alerts WHERE grade_id = \? AND institution_id = \?`).
+		WithArgs(ownGradeID, ownInstitution).
+		WillReturnRows(alertRows().AddRow(3, models.MetricSuccessRate, 90, 50, true))
+	mock.ExpectExec(`INSERT INTO grade_alerts`).
+		WithArgs(ownGradeID, ownInstitution, nil, models.MetricSuccessRate, 90.0, 40.0).WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectCommit()
+
+	fresh, err := syncAlerts(context.Background(), ownInstitution, ownGradeID, breaches)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fresh, breaches) {
+		t.Errorf("got new breaches %+v, want %+v", fresh, breaches)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
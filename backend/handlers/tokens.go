@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"study_grade/middleware"
+	"study_grade/models"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func generateJWT(user models.User) (string, error) {
+	return generateScopedJWT(user, middleware.ScopeFull, time.Hour*24)
+}
+
+// generateScopedJWT видає токен з обмеженою областю дії та часом життя.
+// Claim "tv" (token_version) дозволяє відкликати всі токени користувача,
+// "inst" прив'язує токен до установи, дані якої він відкриває.
+func generateScopedJWT(user models.User, scope string, ttl time.Duration) (string, error) {
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, scopedClaims(user, scope, ttl)).SignedString(jwtSecret)
+}
+
+func scopedClaims(user models.User, scope string, ttl time.Duration) jwt.MapClaims {
+	return jwt.MapClaims{
+		"user_id": user.ID,
+		"role":    user.Role,
+		"scope":   scope,
+		"tv":      user.TokenVersion,
+		"inst":    user.InstitutionID,
+		"exp":     time.Now().Add(ttl).Unix(),
+	}
+}
+
+// parseScopedJWT перевіряє токен з очікуваним scope і повертає user_id та token_version
+func parseScopedJWT(tokenString, scope string) (int, int, error) {
+	claims, err := parseScopedClaims(tokenString, scope)
+	if err != nil {
+		return 0, 0, err
+	}
+	return claimsUser(claims)
+}
+
+// parseScopedClaims перевіряє підпис, строк дії та scope токена і повертає всі його claims
+func parseScopedClaims(tokenString, scope string) (jwt.MapClaims, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, http.ErrNotSupported
+		}
+		return jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["scope"] != scope {
+		return nil, errors.New("unexpected token scope")
+	}
+	return claims, nil
+}
+
+func claimsUser(claims jwt.MapClaims) (int, int, error) {
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return 0, 0, errors.New("invalid user ID claim")
+	}
+	tokenVersion, _ := claims["tv"].(float64)
+	return int(userID), int(tokenVersion), nil
+}
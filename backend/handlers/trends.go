@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/models"
+	"study_grade/stats"
+)
+
+// trendColumns - за чим будуються серії трендів
+var trendColumns = map[string]string{
+	"group":   "g.group_name",
+	"subject": "g.subject",
+}
+
+// GetTrends повертає часові ряди показників для кожної групи (by=group) або предмета (by=subject)
+// у порядку навчальних років і семестрів, з дельтами між сусідніми точками та нахилом лінійного тренду.
+// Необов'язкові фільтри group і subject звужують вибірку, academic_year задає перший рік ряду.
+// Викладач отримує ряди лише за власними записами.
+func GetTrends(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	by := q.Get("by")
+	if by == "" {
+		by = "group"
+	}
+	column, ok := trendColumns[by]
+	if !ok {
+		http.Error(w, "by must be group or subject", http.StatusBadRequest)
+		return
+	}
+
+	where, args := ownRecordsOnly(r, []string{"g.institution_id = ?"}, []any{institutionID(r)})
+	if group := q.Get("group"); group != "" {
+		where = append(where, "g.group_name = ?")
+		args = append(args, group)
+	}
+	if subject := q.Get("subject"); subject != "" {
+		where = append(where, "g.subject = ?")
+		args = append(args, subject)
+	}
+	if year := q.Get("academic_year"); year != "" {
+		if !validAcademicYear(year) {
+			http.Error(w, "academic_year must look like 2025/2026", http.StatusBadRequest)
+			return
+		}
+		where = append(where, "g.academic_year >= ?")
+		args = append(args, year)
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx, `
+		SELECT `+column+`, g.academic_year, g.semester,
+			COUNT(*), SUM(g.total_students), SUM(g.grade_5), SUM(g.grade_4), SUM(g.grade_3), SUM(g.grade_2)
+		FROM grades g
+		WHERE `+strings.Join(where, " AND ")+`
+		GROUP BY 1, 2, 3
+		ORDER BY 1, 2, 3`,
+		args...)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("trends_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer rows.Close()
+
+	response := models.TrendsResponse{By: by, Series: []models.TrendSeries{}}
+	var current *models.TrendSeries
+	for rows.Next() {
+		var name string
+		var p models.TrendPoint
+		var grade5, grade4, grade3, grade2 int
+		if err := rows.Scan(&name, &p.AcademicYear, &p.Semester, &p.Records, &p.TotalStudents, &grade5, &grade4, &grade3, &grade2); err != nil {
+			metrics.DBErrorsTotal.Inc("trends_scan")
+			http.Error(w, "Failed to scan trends", http.StatusInternalServerError)
+			return
+		}
+		p.AverageScore, p.SuccessRate, p.QualityRate = calculateAverages(p.TotalStudents, grade5, grade4, grade3, grade2)
+
+		if current == nil || current.Name != name {
+			response.Series = append(response.Series, models.TrendSeries{Name: name})
+			current = &response.Series[len(response.Series)-1]
+		}
+		if n := len(current.Points); n > 0 {
+			prev := current.Points[n-1]
+			p.AverageScoreDelta = floatPtr(p.AverageScore - prev.AverageScore)
+			p.SuccessRateDelta = floatPtr(p.SuccessRate - prev.SuccessRate)
+			p.QualityRateDelta = floatPtr(p.QualityRate - prev.QualityRate)
+		}
+		current.Points = append(current.Points, p)
+	}
+	if err := rows.Err(); err != nil {
+		metrics.DBErrorsTotal.Inc("trends_scan")
+		http.Error(w, "Failed to scan trends", http.StatusInternalServerError)
+		return
+	}
+
+	for i := range response.Series {
+		s := &response.Series[i]
+		average := make([]float64, len(s.Points))
+		success := make([]float64, len(s.Points))
+		quality := make([]float64, len(s.Points))
+		for j, p := range s.Points {
+			average[j], success[j], quality[j] = p.AverageScore, p.SuccessRate, p.QualityRate
+		}
+		s.AverageScoreSlope = slopePtr(average)
+		s.SuccessRateSlope = slopePtr(success)
+		s.QualityRateSlope = slopePtr(quality)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+func floatPtr(v float64) *float64 {
+	return &v
+}
+
+func slopePtr(values []float64) *float64 {
+	slope, ok := stats.Slope(values)
+	if !ok {
+		return nil
+	}
+	return &slope
+}
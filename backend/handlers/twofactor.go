@@ -0,0 +1,571 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"study_grade/auth"
+	"study_grade/config"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/middleware"
+	"study_grade/models"
+	"study_grade/ratelimit"
+	"study_grade/totp"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	recoveryCodeCount = 10
+	mfaTokenTTL       = 5 * time.Minute
+	enrollTokenTTL    = 15 * time.Minute
+)
+
+// totpState - дані 2FA користувача
+type totpState struct {
+	username      string
+	role          string
+	secret        string
+	enabled       bool
+	lastStep      int64
+	tokenVersion  int
+	disabled      bool
+	institutionID int
+}
+
+func (st totpState) user(userID int) models.User {
+	return models.User{ID: userID, Username: st.username, Role: st.role, TOTPEnabled: st.enabled, InstitutionID: st.institutionID, TokenVersion: st.tokenVersion}
+}
+
+func loadTOTPState(ctx context.Context, userID int) (totpState, error) {
+	var st totpState
+	var secret sql.NullString
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT username, role, totp_secret, totp_enabled, totp_last_step, token_version, disabled, institution_id FROM users WHERE id = ?", userID,
+	).Scan(&st.username, &st.role, &secret, &st.enabled, &st.lastStep, &st.tokenVersion, &st.disabled, &st.institutionID)
+	st.secret = secret.String
+	return st, err
+}
+
+// completeLogin видає токен після перевірки пароля: повний, проміжний для
+// введення коду 2FA або обмежений для налаштування обов'язкової 2FA
+func completeLogin(ctx context.Context, user models.User) (models.LoginResponse, error) {
+	if user.TOTPEnabled {
+		token, err := generateScopedJWT(user, middleware.ScopeMFA, mfaTokenTTL)
+		return models.LoginResponse{User: user, MFARequired: true, MFAToken: token}, err
+	}
+
+	required, err := twoFactorRequired(ctx, user.InstitutionID, user.Role)
+	if err != nil {
+		return models.LoginResponse{}, err
+	}
+	if required {
+		token, err := generateScopedJWT(user, middleware.ScopeEnroll, enrollTokenTTL)
+		return models.LoginResponse{Token: token, User: user, MFAEnrollmentRequired: true}, err
+	}
+
+	token, err := generateJWT(user)
+	return models.LoginResponse{Token: token, User: user}, err
+}
+
+// twoFactorRequired перевіряє політику адміністратора установи для ролі
+func twoFactorRequired(ctx context.Context, institutionID int, role string) (bool, error) {
+	policy, err := loadTwoFactorPolicy(ctx, institutionID)
+	if err != nil {
+		return false, err
+	}
+	for _, r := range policy.RequiredRoles {
+		if r == role {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func loadTwoFactorPolicy(ctx context.Context, institutionID int) (models.TwoFactorPolicy, error) {
+	policy := models.TwoFactorPolicy{RequiredRoles: []string{}}
+	value, ok, err := getSetting(ctx, institutionID, settingTOTPRequiredRoles)
+	if err != nil || !ok {
+		return policy, err
+	}
+	for _, role := range strings.Split(value, ",") {
+		if role = strings.TrimSpace(role); role != "" {
+			policy.RequiredRoles = append(policy.RequiredRoles, role)
+		}
+	}
+	return policy, nil
+}
+
+// verifyTOTPCode перевіряє код і атомарно запам'ятовує його крок,
+// щоб один і той самий код не можна було використати двічі
+func verifyTOTPCode(ctx context.Context, userID int, st totpState, code string) (bool, error) {
+	step, ok := totp.Validate(st.secret, code, time.Now())
+	if !ok || step <= st.lastStep {
+		return false, nil
+	}
+	result, err := db.DB.ExecContext(ctx,
+		"UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?", step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+// useRecoveryCode позначає одноразовий код відновлення використаним
+func useRecoveryCode(ctx context.Context, userID int, code string) (bool, error) {
+	result, err := db.DB.ExecContext(ctx,
+		"UPDATE user_recovery_codes SET used_at = NOW() WHERE user_id = ? AND code_hash = ? AND used_at IS NULL",
+		userID, hashRecoveryCode(code))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n == 1, err
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(strings.TrimSpace(code)))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// replaceRecoveryCodes генерує новий набір кодів відновлення замість старого
+func replaceRecoveryCodes(ctx context.Context, userID int) ([]string, error) {
+	const alphabet = "abcdefghijkmnpqrstuvwxyz23456789" // без схожих символів l/1, o/0
+	codes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 12)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		for j := range buf {
+			buf[j] = alphabet[int(buf[j])%len(alphabet)]
+		}
+		codes[i] = string(buf[0:4]) + "-" + string(buf[4:8]) + "-" + string(buf[8:12])
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)", userID, hashRecoveryCode(code)); err != nil {
+			return nil, err
+		}
+	}
+	return codes, tx.Commit()
+}
+
+// LoginTwoFactor завершує вхід кодом з автентифікатора або кодом відновлення
+func LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	log.Println("LoginTwoFactor handler called from", r.RemoteAddr)
+	var req models.LoginTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Code == "" && req.RecoveryCode == "" {
+		http.Error(w, "Code or recovery code required", http.StatusBadRequest)
+		return
+	}
+
+	userID, tokenVersion, err := parseScopedJWT(req.MFAToken, middleware.ScopeMFA)
+	if err != nil {
+		log.Println("Invalid MFA token:", err)
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	st, err := loadTOTPState(ctx, userID)
+	if err != nil {
+		log.Println("Failed to load user for 2FA login:", err)
+		metrics.DBErrorsTotal.Inc("login_2fa_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if st.tokenVersion != tokenVersion {
+		http.Error(w, "Invalid or expired MFA token", http.StatusUnauthorized)
+		return
+	}
+	if st.disabled {
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+	if !st.enabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+
+	// Коди 2FA підбираються так само, як паролі, тож діють ті ж обмеження
+	usernameKey := "login:user:" + strings.ToLower(st.username)
+	ipKey := "login:ip:" + ratelimit.ClientIP(r)
+	if retryAfter, err := ratelimit.LoginUsername.Blocked(r.Context(), usernameKey); err == nil && retryAfter > 0 {
+		metrics.RateLimitedTotal.Inc("login", "username")
+		w.Header().Set("Retry-After", ratelimit.RetryAfterSeconds(retryAfter))
+		http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	var ok bool
+	if req.Code != "" {
+		ok, err = verifyTOTPCode(ctx, userID, st, req.Code)
+	} else {
+		ok, err = useRecoveryCode(ctx, userID, req.RecoveryCode)
+		if ok {
+			log.Println("Recovery code used for user ID:", userID)
+		}
+	}
+	if err != nil {
+		log.Println("Database error during 2FA verification:", err)
+		metrics.DBErrorsTotal.Inc("login_2fa_verify")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		log.Println("Invalid 2FA code for user ID:", userID)
+		loginFailed(w, r, usernameKey, ipKey)
+		return
+	}
+	// Як і при вході паролем, лічильник IP не скидається
+	ratelimit.LoginUsername.Success(r.Context(), usernameKey)
+
+	token, err := generateJWT(st.user(userID))
+	if err != nil {
+		log.Println("Failed to generate JWT:", err)
+		http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+		return
+	}
+	metrics.LoginAttemptsTotal.Inc("success")
+	log.Println("Two-factor login successful for user ID:", userID)
+	json.NewEncoder(w).Encode(models.LoginResponse{
+		Token: token,
+		User:  st.user(userID),
+	})
+}
+
+// EnrollTwoFactor створює новий секрет TOTP (ще не активний до підтвердження кодом)
+func EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	st, err := loadTOTPState(ctx, userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if st.enabled {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, "Failed to generate secret", http.StatusInternalServerError)
+		return
+	}
+	if _, err := db.DB.ExecContext(ctx, "UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?", secret, userID); err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_update_secret")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+
+	uri := totp.URI(config.String("TOTP_ISSUER", "Study Grade"), st.username, secret)
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		log.Println("Failed to render QR code:", err)
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+
+	log.Println("2FA enrollment started for user ID:", userID)
+	json.NewEncoder(w).Encode(models.TwoFactorEnrollResponse{Secret: secret, OTPAuthURI: uri, QRCodePNG: png})
+}
+
+// TwoFactorQRCode віддає QR-код незавершеного налаштування як PNG
+func TwoFactorQRCode(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	st, err := loadTOTPState(ctx, userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	// Після активації секрет більше не показується
+	if st.enabled || st.secret == "" {
+		http.Error(w, "No pending two-factor enrollment", http.StatusNotFound)
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URI(config.String("TOTP_ISSUER", "Study Grade"), st.username, st.secret), qrcode.Medium, 256)
+	if err != nil {
+		http.Error(w, "Failed to render QR code", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(png)
+}
+
+// ConfirmTwoFactor активує 2FA після введення першого коду та видає коди відновлення
+func ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	st, err := loadTOTPState(ctx, userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if st.enabled {
+		http.Error(w, "Two-factor authentication already enabled", http.StatusConflict)
+		return
+	}
+	if st.secret == "" {
+		http.Error(w, "Start enrollment first", http.StatusBadRequest)
+		return
+	}
+	step, valid := totp.Validate(st.secret, req.Code, time.Now())
+	if !valid {
+		http.Error(w, "Invalid code", http.StatusBadRequest)
+		return
+	}
+
+	if _, err := db.DB.ExecContext(ctx, "UPDATE users SET totp_enabled = TRUE, totp_last_step = ? WHERE id = ?", step, userID); err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_enable")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	codes, err := replaceRecoveryCodes(ctx, userID)
+	if err != nil {
+		log.Println("Failed to create recovery codes:", err)
+		metrics.DBErrorsTotal.Inc("2fa_recovery_codes")
+		http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+		return
+	}
+
+	response := models.RecoveryCodesResponse{RecoveryCodes: codes}
+	// Вхід з обов'язковою 2FA завершується тут - видаємо повний токен
+	if scope, _ := r.Context().Value("tokenScope").(string); scope == middleware.ScopeEnroll {
+		if response.Token, err = generateJWT(st.user(userID)); err != nil {
+			http.Error(w, "Failed to generate token", http.StatusInternalServerError)
+			return
+		}
+	}
+	log.Println("2FA enabled for user ID:", userID)
+	json.NewEncoder(w).Encode(response)
+}
+
+// DisableTwoFactor вимикає 2FA (потрібні пароль і поточний код), якщо політика це дозволяє
+func DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	st, err := loadTOTPState(ctx, userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !st.enabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	required, err := twoFactorRequired(ctx, st.institutionID, st.role)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_policy")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if required {
+		http.Error(w, "Two-factor authentication is required for your role", http.StatusForbidden)
+		return
+	}
+	if err := checkPassword(ctx, userID, req.Password); err != nil {
+		http.Error(w, "Invalid password", http.StatusUnauthorized)
+		return
+	}
+	if valid, err := verifyTOTPCode(ctx, userID, st, req.Code); err != nil || !valid {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+
+	if _, err := db.DB.ExecContext(ctx, "UPDATE users SET totp_enabled = FALSE, totp_secret = NULL, totp_last_step = 0 WHERE id = ?", userID); err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_disable")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		log.Println("Failed to delete recovery codes:", err)
+	}
+	log.Println("2FA disabled for user ID:", userID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RegenerateRecoveryCodes замінює коди відновлення (потрібен поточний код)
+func RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("userID").(int)
+	if !ok {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var req models.TwoFactorCodeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	st, err := loadTOTPState(ctx, userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_select_user")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !st.enabled {
+		http.Error(w, "Two-factor authentication is not enabled", http.StatusBadRequest)
+		return
+	}
+	if valid, err := verifyTOTPCode(ctx, userID, st, req.Code); err != nil || !valid {
+		http.Error(w, "Invalid code", http.StatusUnauthorized)
+		return
+	}
+	codes, err := replaceRecoveryCodes(ctx, userID)
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_recovery_codes")
+		http.Error(w, "Failed to create recovery codes", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(models.RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// GetTwoFactorPolicy повертає ролі установи, для яких 2FA обов'язкова
+func GetTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	policy, err := loadTwoFactorPolicy(ctx, institutionID(r))
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_policy")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	json.NewEncoder(w).Encode(policy)
+}
+
+// UpdateTwoFactorPolicy задає ролі установи, для яких 2FA обов'язкова
+func UpdateTwoFactorPolicy(w http.ResponseWriter, r *http.Request) {
+	var policy models.TwoFactorPolicy
+	if err := json.NewDecoder(r.Body).Decode(&policy); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	for _, role := range policy.RequiredRoles {
+		if !validRole(role) {
+			http.Error(w, "Unknown role: "+role, http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	if err := putSetting(ctx, institutionID(r), settingTOTPRequiredRoles, strings.Join(policy.RequiredRoles, ",")); err != nil {
+		metrics.DBErrorsTotal.Inc("2fa_policy")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	log.Println("2FA policy of institution", institutionID(r), "updated, required for roles:", policy.RequiredRoles)
+	json.NewEncoder(w).Encode(policy)
+}
+
+func validRole(role string) bool {
+	for _, r := range models.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPassword звіряє пароль користувача: bcrypt-хеш для локальних записів,
+// бекенд автентифікації (наприклад, LDAP) для зовнішніх
+func checkPassword(ctx context.Context, userID int, password string) error {
+	var username, hashedPassword, source string
+	if err := db.DB.QueryRowContext(ctx, "SELECT username, password, auth_source FROM users WHERE id = ?", userID).Scan(&username, &hashedPassword, &source); err != nil {
+		return err
+	}
+	password = strings.TrimSpace(password)
+	if source == auth.SourceLocal {
+		return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
+	}
+	backend := auth.Default.Backend(source)
+	if backend == nil {
+		return errors.New("authentication backend " + source + " is not enabled")
+	}
+	user, err := backend.Authenticate(ctx, username, password)
+	if err != nil {
+		return err
+	}
+	if user.ID != userID {
+		return auth.ErrInvalidCredentials
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"context"
+	"study_grade/totp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// Код 2FA приймається лише один раз: крок запам'ятовується, і повтор відхиляється
+func TestVerifyTOTPCodeRejectsReplay(t *testing.T) {
+	mock := mockDB(t, sqlmock.QueryMatcherRegexp)
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	step := totp.Step(time.Now())
+	code, _ := totp.Code(secret, step)
+	ctx := context.Background()
+
+	mock.ExpectExec(`UPDATE users SET totp_last_step = \? WHERE id = \? AND totp_last_step < \?`).
+		WithArgs(sqlmock.AnyArg(), callerID, sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	ok, err := verifyTOTPCode(ctx, callerID, totpState{secret: secret, lastStep: step - 5}, code)
+	if err != nil || !ok {
+		t.Fatalf("first use: got (%v, %v), want accepted", ok, err)
+	}
+
+	// Після першого входу в базі вже збережено крок коду: повтор відхиляється без запису
+	if ok, err := verifyTOTPCode(ctx, callerID, totpState{secret: secret, lastStep: step + 1}, code); ok || err != nil {
+		t.Errorf("replay with stored step: got (%v, %v), want rejected", ok, err)
+	}
+
+	// Паралельний запит з тим самим кодом встиг зберегти крок першим
+	mock.ExpectExec(`UPDATE users SET totp_last_step = \? WHERE id = \? AND totp_last_step < \?`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	if ok, err := verifyTOTPCode(ctx, callerID, totpState{secret: secret, lastStep: step - 5}, code); ok || err != nil {
+		t.Errorf("concurrent replay: got (%v, %v), want rejected", ok, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,49 +1,288 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"study_grade/auth"
+	"study_grade/config"
 	"study_grade/db"
 	"study_grade/handlers"
+	"study_grade/metrics"
 	"study_grade/middleware"
+	"study_grade/models"
+	"study_grade/notify"
+	"study_grade/ratelimit"
+	"study_grade/web"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
 func main() {
 	db.InitDB()
+	metrics.RegisterDBStats(db.DB)
+	ratelimit.InitFromEnv(db.DB)
+	notify.InitFromEnv()
+	auth.InitFromEnv(db.DB)
 	r := mux.NewRouter().StrictSlash(true) // Handle trailing slashes
 
-	// Налаштування CORS
-	r.Use(middleware.CORSMiddleware)
+	// Метрики запитів (має йти першим, щоб враховувати і відповіді CORS)
+	r.Use(middleware.MetricsMiddleware)
+
+	// Налаштування CORS (не потрібні, якщо фронтенд віддається цим же сервером)
+	if config.Bool("CORS_ENABLED", true) {
+		r.Use(middleware.CORSMiddleware(middleware.CORSConfigFromEnv()))
+	} else {
+		log.Println("CORS middleware disabled (CORS_ENABLED=false)")
+	}
+
+	// Preflight-запити до будь-якого маршруту: middleware виконуються лише для знайдених
+	// маршрутів, тож без цього OPTIONS до /api/grades отримував би 405 без CORS-заголовків.
+	// Метод перевіряється MatcherFunc, а не Methods: інакше кожен не-OPTIONS запит
+	// до невідомого шляху вважався б невідповідністю методу і отримував 405 замість 404.
+	r.MatcherFunc(isMethod(http.MethodOptions)).HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
 
 	// Публічні маршрути
-	r.HandleFunc("/api/register", handlers.Register).Methods("POST")
-	r.HandleFunc("/api/register", handlers.Register).Methods("OPTIONS")
+	registerLimit := middleware.RateLimitMiddleware(ratelimit.RegisterIP, "register")
+	loginLimit := middleware.RateLimitMiddleware(ratelimit.LoginIP, "login")
+	r.Handle("/api/register", registerLimit(http.HandlerFunc(handlers.Register))).Methods("POST")
+	r.HandleFunc("/api/register/mode", handlers.GetRegistrationMode).Methods("GET")
 	r.HandleFunc("/api/register", func(w http.ResponseWriter, r *http.Request) {
 		log.Println("Invalid method for /api/register:", r.Method)
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 	}).Methods("GET")
-	r.HandleFunc("/api/login", handlers.Login).Methods("POST")
-	r.HandleFunc("/api/login", handlers.Login).Methods("OPTIONS")
-	log.Println("Registered public routes: /api/register (POST, GET), /api/login (POST)")
+	r.Handle("/api/login", loginLimit(http.HandlerFunc(handlers.Login))).Methods("POST")
+	r.Handle("/api/login/2fa", loginLimit(http.HandlerFunc(handlers.LoginTwoFactor))).Methods("POST")
+	resetLimit := middleware.RateLimitMiddleware(ratelimit.PasswordResetIP, "password_reset")
+	r.Handle("/api/password/forgot", resetLimit(http.HandlerFunc(handlers.ForgotPassword))).Methods("POST")
+	r.Handle("/api/password/reset", resetLimit(http.HandlerFunc(handlers.ResetPassword))).Methods("POST")
+	log.Println("Registered public routes: /api/register (POST, GET), /api/register/mode (GET), /api/login (POST), /api/login/2fa (POST), /api/password/forgot (POST), /api/password/reset (POST)")
+
+	// Вхід через OpenID Connect (обробники відповідають 404, якщо OIDC_ISSUER не задано)
+	r.HandleFunc("/api/login/methods", handlers.GetLoginMethods).Methods("GET")
+	r.Handle("/api/oidc/login", loginLimit(http.HandlerFunc(handlers.OIDCLogin))).Methods("GET")
+	r.Handle("/api/oidc/callback", loginLimit(http.HandlerFunc(handlers.OIDCCallback))).Methods("GET")
+	r.Handle("/api/oidc/token", loginLimit(http.HandlerFunc(handlers.OIDCToken))).Methods("POST")
+	log.Println("Registered SSO routes: /api/login/methods (GET), /api/oidc/login (GET), /api/oidc/callback (GET), /api/oidc/token (POST)")
+
+	// Перевірки стану для оркестратора
+	r.HandleFunc("/healthz", handlers.Healthz).Methods("GET")
+	r.HandleFunc("/readyz", handlers.Readyz).Methods("GET")
+	log.Println("Registered health routes: /healthz (GET), /readyz (GET)")
+
+	// Метрики у форматі Prometheus
+	r.Handle("/metrics", metrics.Handler()).Methods("GET")
+	log.Println("Registered metrics route: /metrics (GET)")
+
+	// Налаштування 2FA: доступне і з обмеженим токеном, якщо роль вимагає 2FA
+	enrollment := r.PathPrefix("/api/2fa").Subrouter()
+	enrollment.Use(middleware.EnrollmentAuthMiddleware)
+	enrollment.HandleFunc("/enroll", handlers.EnrollTwoFactor).Methods("POST")
+	enrollment.HandleFunc("/qr.png", handlers.TwoFactorQRCode).Methods("GET")
+	enrollment.HandleFunc("/confirm", handlers.ConfirmTwoFactor).Methods("POST")
+	log.Println("Registered 2FA enrollment routes: /api/2fa/enroll (POST), /api/2fa/qr.png (GET), /api/2fa/confirm (POST)")
+
+	// Маршрути адміністратора
+	admin := r.PathPrefix("/api/admin").Subrouter()
+	admin.Use(middleware.JWTAuthMiddleware, middleware.RequireRole(models.RoleAdmin, models.RoleSuperadmin), middleware.IdempotencyMiddleware)
+	admin.HandleFunc("/2fa-policy", handlers.GetTwoFactorPolicy).Methods("GET")
+	admin.HandleFunc("/2fa-policy", handlers.UpdateTwoFactorPolicy).Methods("PUT")
+	admin.HandleFunc("/duplicate-policy", handlers.GetDuplicatePolicy).Methods("GET")
+	admin.HandleFunc("/duplicate-policy", handlers.UpdateDuplicatePolicy).Methods("PUT")
+	admin.HandleFunc("/registration-policy", handlers.GetRegistrationPolicy).Methods("GET")
+	admin.HandleFunc("/registration-policy", handlers.UpdateRegistrationPolicy).Methods("PUT")
+	admin.HandleFunc("/grades/duplicates", handlers.FindDuplicates).Methods("GET")
+	admin.HandleFunc("/grades/merge", handlers.MergeDuplicates).Methods("POST")
+	admin.HandleFunc("/grades/orphaned", handlers.ListOrphanedGrades).Methods("GET")
+	admin.HandleFunc("/grades/orphaned/assign", handlers.AssignOrphanedGrades).Methods("POST")
+	admin.HandleFunc("/departments", handlers.ListDepartments).Methods("GET")
+	admin.HandleFunc("/departments", handlers.CreateDepartment).Methods("POST")
+	admin.HandleFunc("/departments/{id:[0-9]+}", handlers.UpdateDepartment).Methods("PATCH")
+	admin.HandleFunc("/academic-years", handlers.CreateAcademicYear).Methods("POST")
+	admin.HandleFunc("/faculties", handlers.ListFaculties).Methods("GET")
+	admin.HandleFunc("/faculties", handlers.CreateFaculty).Methods("POST")
+	admin.HandleFunc("/groups", handlers.ListStudyGroups).Methods("GET")
+	admin.HandleFunc("/groups", handlers.CreateStudyGroup).Methods("POST")
+	admin.HandleFunc("/groups/{id:[0-9]+}", handlers.UpdateStudyGroup).Methods("PATCH")
+	admin.HandleFunc("/invitations", handlers.ListInvitations).Methods("GET")
+	admin.HandleFunc("/invitations", handlers.CreateInvitation).Methods("POST")
+	admin.HandleFunc("/invitations/{id:[0-9]+}", handlers.RevokeInvitation).Methods("DELETE")
+	admin.HandleFunc("/anomalies", handlers.ListAnomalies).Methods("GET")
+	admin.HandleFunc("/anomalies/{id:[0-9]+}", handlers.ReviewAnomaly).Methods("PUT")
+	admin.HandleFunc("/thresholds", handlers.ListThresholds).Methods("GET")
+	admin.HandleFunc("/thresholds", handlers.CreateThreshold).Methods("POST")
+	admin.HandleFunc("/thresholds/{id:[0-9]+}", handlers.DeleteThreshold).Methods("DELETE")
+	admin.HandleFunc("/alerts", handlers.ListAlerts).Methods("GET")
+	admin.HandleFunc("/alert-recipients", handlers.GetAlertRecipients).Methods("GET")
+	admin.HandleFunc("/alert-recipients", handlers.UpdateAlertRecipients).Methods("PUT")
+	admin.HandleFunc("/alerts/{id:[0-9]+}/acknowledge", handlers.AcknowledgeAlert).Methods("POST")
+	admin.HandleFunc("/users", handlers.ListUsers).Methods("GET")
+	admin.HandleFunc("/users", handlers.CreateUser).Methods("POST")
+	admin.HandleFunc("/users/{id:[0-9]+}", handlers.UpdateUser).Methods("PATCH")
+	admin.HandleFunc("/users/{id:[0-9]+}", handlers.DeleteUser).Methods("DELETE")
+	admin.HandleFunc("/users/{id:[0-9]+}/role", handlers.UpdateUserRole).Methods("PUT")
+	admin.HandleFunc("/users/{id:[0-9]+}/disable", handlers.DisableUser).Methods("POST")
+	admin.HandleFunc("/users/{id:[0-9]+}/enable", handlers.EnableUser).Methods("POST")
+	log.Println("Registered admin routes: /api/admin/2fa-policy (GET, PUT), /api/admin/duplicate-policy (GET, PUT), /api/admin/registration-policy (GET, PUT), /api/admin/grades/duplicates (GET), /api/admin/grades/merge (POST), /api/admin/grades/orphaned (GET), /api/admin/grades/orphaned/assign (POST), /api/admin/departments (GET, POST), /api/admin/departments/{id} (PATCH), /api/admin/academic-years (POST), /api/admin/faculties (GET, POST), /api/admin/groups (GET, POST), /api/admin/groups/{id} (PATCH), /api/admin/invitations (GET, POST), /api/admin/invitations/{id} (DELETE), /api/admin/anomalies (GET), /api/admin/anomalies/{id} (PUT), /api/admin/thresholds (GET, POST), /api/admin/thresholds/{id} (DELETE), /api/admin/alerts (GET), /api/admin/alert-recipients (GET, PUT), /api/admin/alerts/{id}/acknowledge (POST), /api/admin/users (GET, POST), /api/admin/users/{id} (PATCH, DELETE), /api/admin/users/{id}/role (PUT), /api/admin/users/{id}/disable|enable (POST)")
+
+	// Маршрути платформи: керування установами (лише superadmin)
+	platform := r.PathPrefix("/api/institutions").Subrouter()
+	platform.Use(middleware.JWTAuthMiddleware, middleware.RequireRole(models.RoleSuperadmin), middleware.IdempotencyMiddleware)
+	platform.HandleFunc("", handlers.ListInstitutions).Methods("GET")
+	platform.HandleFunc("", handlers.CreateInstitution).Methods("POST")
+	platform.HandleFunc("/{id:[0-9]+}/admins", handlers.CreateInstitutionAdmin).Methods("POST")
+	log.Println("Registered institution routes: /api/institutions (GET, POST), /api/institutions/{id}/admins (POST)")
 
 	// Захищені маршрути з JWT
+	// Підписка на розклад іспитів: календарні застосунки передають токен з областю calendar у ?token=
+	r.Handle("/api/exams/calendar.ics", middleware.CalendarFeedAuth(http.HandlerFunc(handlers.ExamCalendar))).Methods("GET")
+	log.Println("Registered calendar feed route: /api/exams/calendar.ics (GET, Authorization or ?token=)")
+
 	protected := r.PathPrefix("/api").Subrouter()
-	protected.Use(middleware.JWTAuthMiddleware)
+	// POST-запити з Idempotency-Key не виконуються повторно
+	protected.Use(middleware.JWTAuthMiddleware, middleware.IdempotencyMiddleware)
 	protected.HandleFunc("/grades", handlers.CreateGrade).Methods("POST")
 	protected.HandleFunc("/grades", handlers.GetGrades).Methods("GET")
-	log.Println("Registered protected routes: /api/grades (POST, GET)")
+	protected.HandleFunc("/grades/export", handlers.ExportGrades).Methods("GET")
+	protected.HandleFunc("/grades/{id:[0-9]+}", handlers.GetGrade).Methods("GET")
+	protected.HandleFunc("/grades/{id:[0-9]+}", handlers.UpdateGrade).Methods("PUT")
+	protected.HandleFunc("/grades/{id:[0-9]+}", handlers.DeleteGrade).Methods("DELETE")
+	protected.HandleFunc("/grades/{id:[0-9]+}/history", handlers.GetGradeHistory).Methods("GET")
+	protected.HandleFunc("/grades/{id:[0-9]+}/restore", handlers.RestoreGrade).Methods("POST")
+	protected.HandleFunc("/academic-years", handlers.ListAcademicYears).Methods("GET")
+	protected.HandleFunc("/exams", handlers.ListExams).Methods("GET")
+	protected.HandleFunc("/exams", handlers.CreateExam).Methods("POST")
+	protected.HandleFunc("/exams/{id:[0-9]+}", handlers.UpdateExam).Methods("PATCH")
+	protected.HandleFunc("/exams/{id:[0-9]+}", handlers.DeleteExam).Methods("DELETE")
+	protected.HandleFunc("/stats", handlers.GetStats).Methods("GET")
+	protected.HandleFunc("/stats/trends", handlers.GetTrends).Methods("GET")
+	protected.HandleFunc("/stats/compare", handlers.GetGroupComparison).Methods("GET")
+	// Керування обліковим записом і токенами - лише з інтерактивного входу, не персональним токеном
+	protected.Handle("/password", middleware.SessionOnly(http.HandlerFunc(handlers.ChangePassword))).Methods("POST")
+	protected.Handle("/2fa/disable", middleware.SessionOnly(http.HandlerFunc(handlers.DisableTwoFactor))).Methods("POST")
+	protected.Handle("/2fa/recovery-codes", middleware.SessionOnly(http.HandlerFunc(handlers.RegenerateRecoveryCodes))).Methods("POST")
+	protected.Handle("/tokens", middleware.SessionOnly(http.HandlerFunc(handlers.ListAPITokens))).Methods("GET")
+	protected.Handle("/tokens", middleware.SessionOnly(http.HandlerFunc(handlers.CreateAPIToken))).Methods("POST")
+	protected.Handle("/tokens/{id:[0-9]+}", middleware.SessionOnly(http.HandlerFunc(handlers.RevokeAPIToken))).Methods("DELETE")
+	log.Println("Registered protected routes: /api/grades (POST, GET), /api/grades/export (GET), /api/grades/{id} (GET, PUT, DELETE), /api/grades/{id}/history (GET), /api/grades/{id}/restore (POST), /api/academic-years (GET), /api/exams (GET, POST), /api/exams/{id} (PATCH, DELETE), /api/stats (GET), /api/stats/trends (GET), /api/stats/compare (GET), /api/password (POST), /api/2fa/disable (POST), /api/2fa/recovery-codes (POST), /api/tokens (GET, POST), /api/tokens/{id} (DELETE)")
 
 	// Catch-all for undefined routes
+	router := r
 	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// mux губить невідповідність методу, якщо шлях далі збігається з префіксом підроутера
+		if allowed := allowedMethods(router, r); len(allowed) > 0 {
+			methodNotAllowed(w, r, allowed)
+			return
+		}
 		log.Println("Received request for undefined route:", r.Method, r.URL.Path)
 		// CORS headers are set by CORSMiddleware, so no need to add here
 		http.Error(w, "404 page not found", http.StatusNotFound)
 	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		methodNotAllowed(w, r, allowedMethods(router, r))
+	})
+
+	// Вбудований фронтенд (має реєструватися останнім: ловить усі інші GET/HEAD-запити поза /api/).
+	// Запит до /api/* з невірним методом сюди не потрапляє і отримує 405, невідомий /api/* - 404.
+	if config.Bool("SERVE_FRONTEND", true) {
+		r.MatcherFunc(isFrontendRequest).Handler(web.Handler(r.NotFoundHandler))
+		log.Println("Serving embedded frontend on / with SPA fallback")
+	}
+
+	srv := &http.Server{
+		Addr:              config.String("SERVER_ADDR", ":8080"),
+		Handler:           r,
+		ReadHeaderTimeout: config.Duration("SERVER_READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       config.Duration("SERVER_READ_TIMEOUT", 15*time.Second),
+		WriteTimeout:      config.Duration("SERVER_WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       config.Duration("SERVER_IDLE_TIMEOUT", 120*time.Second),
+	}
+
+	// Graceful shutdown по SIGTERM/SIGINT
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Printf("Backend server starting on %s with StrictSlash enabled...", srv.Addr)
+		serverErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal("Failed to start server:", err)
+		}
+	case <-ctx.Done():
+		// Повторний сигнал завершує процес одразу, не чекаючи затримки
+		stop()
+		handlers.SetShuttingDown()
+		// Поки слухач ще відкритий, /readyz відповідає 503: оркестратор встигає помітити це
+		// і прибрати под з балансування до того, як нові з'єднання почнуть відхилятися
+		if delay := config.Duration("SERVER_SHUTDOWN_DELAY", 5*time.Second); delay > 0 {
+			log.Printf("Shutdown signal received, reporting not ready for %s...", delay)
+			time.Sleep(delay)
+		}
+		log.Println("Draining connections...")
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), config.Duration("SERVER_SHUTDOWN_TIMEOUT", 20*time.Second))
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			log.Println("Graceful shutdown did not complete:", err)
+		} else {
+			log.Println("All connections drained")
+		}
+	}
+
+	db.Close()
+	log.Println("Backend server stopped")
+}
+
+// isMethod - matcher за методом, що не позначає запит як "невідповідність методу" для mux
+func isMethod(method string) mux.MatcherFunc {
+	return func(r *http.Request, _ *mux.RouteMatch) bool {
+		return r.Method == method
+	}
+}
+
+// allowedMethods - методи, з якими шлях запиту відповідає зареєстрованому маршруту
+func allowedMethods(router *mux.Router, r *http.Request) []string {
+	var allowed []string
+	for _, method := range []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+		if method == r.Method {
+			continue
+		}
+		probe := r.Clone(r.Context())
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// methodNotAllowed відповідає 405 із заголовком Allow
+func methodNotAllowed(w http.ResponseWriter, r *http.Request, allowed []string) {
+	log.Println("Invalid method for route:", r.Method, r.URL.Path)
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+	}
+	http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+}
 
-	log.Println("Backend server starting on :8080 with StrictSlash enabled...")
-	if err := http.ListenAndServe(":8080", r); err != nil {
-		log.Fatal("Failed to start server:", err)
+// isFrontendRequest - GET/HEAD-запит поза API, який обслуговує вбудований фронтенд
+func isFrontendRequest(r *http.Request, _ *mux.RouteMatch) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
 	}
+	return r.URL.Path != "/api" && !strings.HasPrefix(r.URL.Path, "/api/")
 }
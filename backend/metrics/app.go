@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"database/sql"
+)
+
+// Метрики застосунку
+var (
+	HTTPRequestsTotal = NewCounterVec(
+		"http_requests_total",
+		"Total number of HTTP requests by method, mux route template and status code.",
+		"method", "route", "status",
+	)
+	HTTPRequestDuration = NewHistogramVec(
+		"http_request_duration_seconds",
+		"HTTP request latency in seconds by method and mux route template.",
+		DefaultBuckets,
+		"method", "route",
+	)
+	DBErrorsTotal = NewCounterVec(
+		"db_errors_total",
+		"Total number of failed database operations by operation name.",
+		"operation",
+	)
+	LoginAttemptsTotal = NewCounterVec(
+		"login_attempts_total",
+		"Total number of login attempts by result (success, failure).",
+		"result",
+	)
+	RateLimitedTotal = NewCounterVec(
+		"rate_limited_requests_total",
+		"Total number of requests rejected by rate limiting by scope and key type (ip, username).",
+		"scope", "key",
+	)
+	GradesCreatedTotal = NewCounterVec(
+		"grades_created_total",
+		"Total number of grade records created.",
+	)
+	IdempotencyKeysTotal = NewCounterVec(
+		"idempotency_keys_total",
+		"Total number of POST requests with an Idempotency-Key by outcome (stored, replayed, mismatch, in_progress).",
+		"outcome",
+	)
+)
+
+// RegisterDBStats реєструє метрики пулу з'єднань із db.Stats()
+func RegisterDBStats(db *sql.DB) {
+	NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(db.Stats().MaxOpenConnections)
+	})
+	NewGaugeFunc("db_open_connections", "The number of established connections both in use and idle.", func() float64 {
+		return float64(db.Stats().OpenConnections)
+	})
+	NewGaugeFunc("db_in_use_connections", "The number of connections currently in use.", func() float64 {
+		return float64(db.Stats().InUse)
+	})
+	NewGaugeFunc("db_idle_connections", "The number of idle connections.", func() float64 {
+		return float64(db.Stats().Idle)
+	})
+	NewCounterFunc("db_wait_count_total", "The total number of connections waited for.", func() float64 {
+		return float64(db.Stats().WaitCount)
+	})
+	NewCounterFunc("db_wait_duration_seconds_total", "The total time blocked waiting for a new connection.", func() float64 {
+		return db.Stats().WaitDuration.Seconds()
+	})
+	NewCounterFunc("db_max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns.", func() float64 {
+		return float64(db.Stats().MaxIdleClosed)
+	})
+	NewCounterFunc("db_max_idle_time_closed_total", "The total number of connections closed due to SetConnMaxIdleTime.", func() float64 {
+		return float64(db.Stats().MaxIdleTimeClosed)
+	})
+	NewCounterFunc("db_max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime.", func() float64 {
+		return float64(db.Stats().MaxLifetimeClosed)
+	})
+}
@@ -0,0 +1,148 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"time"
+)
+
+// APITokenPrefix відрізняє персональні токени доступу від JWT у заголовку Authorization
+const APITokenPrefix = "sg_pat_"
+
+// Області дії персональних токенів: кожна наступна включає попередні
+const (
+	APIScopeRead  = "read"  // лише читання (GET)
+	APIScopeWrite = "write" // також створення та зміна оцінок
+	APIScopeAdmin = "admin" // також адміністративні маршрути (для адміністраторів)
+)
+
+// APIScopeCalendar - окрема область лише для підписки на розклад іспитів: календарні застосунки
+// не надсилають Authorization, тому токен стоїть в URL і не повинен відкривати решту API
+const APIScopeCalendar = "calendar"
+
+var apiScopeLevel = map[string]int{APIScopeCalendar: 0, APIScopeRead: 1, APIScopeWrite: 2, APIScopeAdmin: 3}
+
+// ValidAPIScope перевіряє назву області дії токена
+func ValidAPIScope(scope string) bool {
+	_, ok := apiScopeLevel[scope]
+	return ok
+}
+
+// apiScopeAllows повертає true, якщо область has включає required
+func apiScopeAllows(has, required string) bool {
+	return apiScopeLevel[has] >= apiScopeLevel[required]
+}
+
+// HashAPIToken - у базі зберігається лише SHA-256 токена
+func HashAPIToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// apiTokenAuth перевіряє персональний токен і кладе в контекст ті ж значення, що й JWT,
+// плюс "apiTokenScope", за яким RequireRole і SessionOnly обмежують доступ.
+// feed=true - токен прийшов у URL календарної підписки і має бути з областю calendar.
+func apiTokenAuth(w http.ResponseWriter, r *http.Request, next http.Handler, token string, feed bool) {
+	dbCtx, cancel := db.WithTimeout(r.Context())
+	defer cancel()
+
+	var tokenID, userID, institutionID int
+	var scope, role string
+	var expiresAt, revokedAt sql.NullTime
+	var disabled bool
+	err := db.DB.QueryRowContext(dbCtx, `
+		SELECT t.id, t.user_id, t.scope, t.expires_at, t.revoked_at, u.role, u.disabled, u.institution_id
+		FROM api_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = ?`, HashAPIToken(token),
+	).Scan(&tokenID, &userID, &scope, &expiresAt, &revokedAt, &role, &disabled, &institutionID)
+	if err == sql.ErrNoRows {
+		log.Println("    API token: unknown token. Returning 401.")
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		log.Println("    API token: failed to load token:", err)
+		metrics.DBErrorsTotal.Inc("auth_select_api_token")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if revokedAt.Valid || (expiresAt.Valid && time.Now().After(expiresAt.Time)) {
+		log.Printf("    API token: token %d is revoked or expired. Returning 401.", tokenID)
+		http.Error(w, "Invalid token", http.StatusUnauthorized)
+		return
+	}
+	if disabled {
+		log.Printf("    API token: user %d is disabled. Returning 403.", userID)
+		http.Error(w, "Account disabled", http.StatusForbidden)
+		return
+	}
+	required := APIScopeWrite
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		required = APIScopeRead
+	}
+	allowed := apiScopeAllows(scope, required)
+	if feed {
+		allowed = scope == APIScopeCalendar
+	}
+	if !allowed {
+		log.Printf("    API token: token %d with scope %q cannot %s %s. Returning 403.", tokenID, scope, r.Method, r.URL.Path)
+		http.Error(w, "Token scope does not allow this operation", http.StatusForbidden)
+		return
+	}
+
+	// Час останнього використання оновлюємо не частіше разу на хвилину
+	if _, err := db.DB.ExecContext(dbCtx,
+		"UPDATE api_tokens SET last_used_at = NOW() WHERE id = ? AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL 1 MINUTE)",
+		tokenID); err != nil {
+		log.Println("    API token: failed to update last_used_at:", err)
+	}
+	log.Printf("    API token: token %d validated for user ID: %d", tokenID, userID)
+
+	ctx := context.WithValue(r.Context(), "userID", userID)
+	ctx = context.WithValue(ctx, "userRole", role)
+	ctx = context.WithValue(ctx, "institutionID", institutionID)
+	ctx = context.WithValue(ctx, "tokenScope", ScopeFull)
+	ctx = context.WithValue(ctx, "apiTokenScope", scope)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// CalendarFeedAuth захищає підписку на розклад іспитів. Календарні застосунки не вміють
+// надсилати заголовок Authorization, тому без нього приймається персональний токен
+// з областю calendar у параметрі token; такий токен можна відкликати як і будь-який інший.
+// Запити із заголовком перевіряє JWTAuthMiddleware.
+func CalendarFeedAuth(next http.Handler) http.Handler {
+	withHeader := JWTAuthMiddleware(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.URL.Query().Get("token")
+		if token == "" || r.Header.Get("Authorization") != "" {
+			withHeader.ServeHTTP(w, r)
+			return
+		}
+		if !strings.HasPrefix(token, APITokenPrefix) {
+			log.Println("    Calendar feed: token parameter is not a personal API token. Returning 401.")
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+		apiTokenAuth(w, r, next, token, true)
+	})
+}
+
+// SessionOnly закриває маршрут для персональних токенів (керування токенами, паролем, 2FA),
+// щоб викрадений токен не давав змоги закріпитися в обліковому записі
+func SessionOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Value("apiTokenScope").(string); ok {
+			log.Printf("API token rejected for session-only route %s %s", r.Method, r.URL.Path)
+			http.Error(w, "This operation requires an interactive login", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
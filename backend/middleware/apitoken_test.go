@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func expectAPIToken(mock sqlmock.Sqlmock, token, scope string) {
+	mock.ExpectQuery(`FROM api_tokens t JOIN users u ON u.id = t.user_id\s+WHERE t.token_hash = \?`).
+		WithArgs(HashAPIToken(token)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "scope", "expires_at", "revoked_at", "role", "disabled", "institution_id"}).
+			AddRow(4, 10, scope, nil, nil, "teacher", false, 1))
+}
+
+// Підписка на календар приймає в URL лише токен з областю calendar,
+// а сам такий токен не відкриває решту API
+func TestCalendarFeedAuthAcceptsOnlyCalendarTokens(t *testing.T) {
+	const token = APITokenPrefix + "feedtoken"
+	tests := []struct {
+		name    string
+		scope   string
+		handler func(http.Handler) http.Handler
+		target  string
+		header  bool
+		want    int
+	}{
+		{name: "calendar token in feed URL", scope: APIScopeCalendar, handler: CalendarFeedAuth, target: "/api/exams/calendar.ics?group=KN-21&token=" + token, want: http.StatusOK},
+		{name: "read token in feed URL", scope: APIScopeRead, handler: CalendarFeedAuth, target: "/api/exams/calendar.ics?group=KN-21&token=" + token, want: http.StatusForbidden},
+		{name: "calendar token on other routes", scope: APIScopeCalendar, handler: JWTAuthMiddleware, target: "/api/grades", header: true, want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := mockDB(t)
+			expectAPIToken(mock, token, tt.scope)
+			if tt.want == http.StatusOK {
+				mock.ExpectExec(`UPDATE api_tokens SET last_used_at = NOW\(\) WHERE id = \?`).WithArgs(4).
+					WillReturnResult(sqlmock.NewResult(0, 1))
+			}
+
+			r := httptest.NewRequest("GET", tt.target, nil)
+			if tt.header {
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			tt.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if id, _ := r.Context().Value("institutionID").(int); id != 1 {
+					t.Errorf("got institution %d in context, want 1", id)
+				}
+			})).ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestCalendarFeedAuthRejectsNonTokenParameter(t *testing.T) {
+	mock := mockDB(t)
+	w := httptest.NewRecorder()
+	CalendarFeedAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler must not run")
+	})).ServeHTTP(w, httptest.NewRequest("GET", "/api/exams/calendar.ics?group=KN-21&token=eyJhbGciOi", nil))
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("got status %d, want 401", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"study_grade/config"
+	"time"
+)
+
+// CORSConfig - налаштування крос-доменних запитів
+type CORSConfig struct {
+	// AllowedOrigins - дозволені origin: точні ("https://grades.example.edu")
+	// або з маскою піддоменів ("https://*.example.edu")
+	AllowedOrigins []string
+	// MaxAge - скільки браузер може кешувати відповідь на preflight
+	MaxAge time.Duration
+}
+
+// CORSConfigFromEnv читає CORS_ALLOWED_ORIGINS та CORS_MAX_AGE
+func CORSConfigFromEnv() CORSConfig {
+	return CORSConfig{
+		AllowedOrigins: config.List("CORS_ALLOWED_ORIGINS", []string{"http://localhost:3000"}),
+		MaxAge:         config.Duration("CORS_MAX_AGE", 10*time.Minute),
+	}
+}
+
+type originPattern struct {
+	scheme string
+	host   string // для масок - суфікс разом з крапкою, наприклад ".example.edu"
+	port   string
+	suffix bool
+}
+
+func parseOriginPattern(raw string) (originPattern, bool) {
+	u, err := url.Parse(strings.ToLower(strings.TrimSpace(raw)))
+	if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+		return originPattern{}, false
+	}
+	p := originPattern{scheme: u.Scheme, host: u.Hostname(), port: u.Port()}
+	if strings.HasPrefix(p.host, "*.") {
+		p.suffix = true
+		p.host = p.host[1:]
+	}
+	if strings.Contains(p.host, "*") {
+		return originPattern{}, false
+	}
+	return p, true
+}
+
+func (p originPattern) matches(scheme, host, port string) bool {
+	if scheme != p.scheme || port != p.port {
+		return false
+	}
+	if p.suffix {
+		// "*.example.edu" дозволяє піддомени, але не сам example.edu
+		return strings.HasSuffix(host, p.host) && len(host) > len(p.host)
+	}
+	return host == p.host
+}
+
+// CORSMiddleware дозволяє credentialed-запити лише з origin зі списку дозволених
+func CORSMiddleware(cfg CORSConfig) func(http.Handler) http.Handler {
+	var patterns []originPattern
+	for _, raw := range cfg.AllowedOrigins {
+		p, ok := parseOriginPattern(raw)
+		if !ok {
+			log.Printf("CORS: ignoring invalid allowed origin %q", raw)
+			continue
+		}
+		patterns = append(patterns, p)
+	}
+	log.Printf("CORS: %d allowed origin pattern(s): %v, preflight max age %s", len(patterns), cfg.AllowedOrigins, cfg.MaxAge)
+
+	allowed := func(origin string, r *http.Request) bool {
+		u, err := url.Parse(strings.ToLower(origin))
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return false
+		}
+		// Запити з того ж origin (вбудований фронтенд) дозволені завжди;
+		// браузери надсилають Origin і для них у POST-запитах
+		if strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+		for _, p := range patterns {
+			if p.matches(u.Scheme, u.Hostname(), u.Port()) {
+				return true
+			}
+		}
+		return false
+	}
+
+	maxAge := strconv.Itoa(int(cfg.MaxAge.Seconds()))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			// Відповідь залежить від Origin, тож проміжні кеші мають це враховувати
+			w.Header().Add("Vary", "Origin")
+
+			origin := r.Header.Get("Origin")
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if origin == "" {
+				// Не браузерний або same-origin GET запит - CORS не застосовується
+				if r.Method == http.MethodOptions {
+					w.WriteHeader(http.StatusNoContent)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if !allowed(origin, r) {
+				log.Printf("CORS: rejected request from disallowed origin %q: %s %s from %s", origin, r.Method, r.URL.Path, r.RemoteAddr)
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+			w.Header().Set("Access-Control-Expose-Headers", "Retry-After, ETag, "+IdempotentReplayedHeader)
+
+			if preflight {
+				w.Header().Add("Vary", "Access-Control-Request-Method")
+				w.Header().Add("Vary", "Access-Control-Request-Headers")
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, PATCH, DELETE")
+				w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match, If-None-Match, "+IdempotencyKeyHeader)
+				w.Header().Set("Access-Control-Max-Age", maxAge)
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			if r.Method == http.MethodOptions {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
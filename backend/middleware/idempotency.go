@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"study_grade/config"
+	"study_grade/db"
+	"study_grade/metrics"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// IdempotencyKeyHeader - заголовок, яким клієнт позначає повтори одного POST-запиту
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotentReplayedHeader додається до відповіді, відтвореної зі збереженої
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+const (
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBody - більші тіла запитів із ключем відхиляються, бо їх треба читати в пам'ять для хешу
+	maxIdempotentBody = 1 << 20
+)
+
+var (
+	idempotencySweepMu   sync.Mutex
+	idempotencyLastSweep time.Time
+)
+
+// validIdempotencyKey - ключ з 1-255 друкованих ASCII-символів (зазвичай UUID)
+func validIdempotencyKey(key string) bool {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if key[i] < 0x21 || key[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// idempotencyRecorder пропускає відповідь клієнту і копіює її для збереження
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
+
+// IdempotencyMiddleware обробляє POST-запити із заголовком Idempotency-Key: перший запит
+// виконується, а його відповідь зберігається на IDEMPOTENCY_TTL (за замовчуванням 24h);
+// повтори з тим самим ключем отримують збережену відповідь без повторного виконання.
+// Ключ належить користувачу, тому middleware ставиться після JWTAuthMiddleware.
+// Той самий ключ з іншим запитом - 422, поки перший запит виконується - 409.
+// Відповіді 5xx не зберігаються, щоб запит можна було повторити.
+// Разом з тілом зберігаються Content-Type, ETag і Location: повтор створення запису отримує
+// той самий ETag, з яким потім можна змінювати запис через If-Match.
+func IdempotencyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if r.Method != http.MethodPost || key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !validIdempotencyKey(key) {
+			http.Error(w, "Invalid Idempotency-Key", http.StatusBadRequest)
+			return
+		}
+		userID, ok := r.Context().Value("userID").(int)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxIdempotentBody+1))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if len(body) > maxIdempotentBody {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := idempotencyHash(r, body)
+
+		ctx, cancel := db.WithTimeout(r.Context())
+		defer cancel()
+		sweepIdempotencyKeys(ctx)
+
+		ttl := config.Duration("IDEMPOTENCY_TTL", 24*time.Hour)
+		claimed, err := claimIdempotencyKey(ctx, userID, key, hash, ttl)
+		if err != nil {
+			log.Println("Idempotency: failed to claim key:", err)
+			metrics.DBErrorsTotal.Inc("idempotency_claim")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			return
+		}
+		if !claimed {
+			replayIdempotentResponse(ctx, w, userID, key, hash)
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		// Запит міг вичерпати тайм-аут контексту - збереження відповіді має власний
+		saveCtx, saveCancel := db.WithTimeout(context.Background())
+		defer saveCancel()
+		if rec.status == 0 || rec.status >= 500 {
+			_, err = db.DB.ExecContext(saveCtx, "DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?", userID, key)
+		} else {
+			_, err = db.DB.ExecContext(saveCtx,
+				"UPDATE idempotency_keys SET status_code = ?, content_type = ?, etag = ?, location = ?, response_body = ? WHERE user_id = ? AND idempotency_key = ?",
+				rec.status, rec.Header().Get("Content-Type"), rec.Header().Get("ETag"), rec.Header().Get("Location"), rec.body.Bytes(), userID, key)
+			metrics.IdempotencyKeysTotal.Inc("stored")
+		}
+		if err != nil {
+			log.Println("Idempotency: failed to store response:", err)
+			metrics.DBErrorsTotal.Inc("idempotency_store")
+		}
+	})
+}
+
+// idempotencyHash - відбиток запиту, з яким звіряються повтори того самого ключа
+func idempotencyHash(r *http.Request, body []byte) string {
+	sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+	return hex.EncodeToString(sum[:])
+}
+
+// claimIdempotencyKey резервує ключ за запитом; false - ключ уже використано і він ще діє
+func claimIdempotencyKey(ctx context.Context, userID int, key, hash string, ttl time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	// Прострочений ключ можна використати знову
+	if _, err := db.DB.ExecContext(ctx,
+		"DELETE FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ? AND expires_at <= ?", userID, key, now); err != nil {
+		return false, err
+	}
+	_, err := db.DB.ExecContext(ctx,
+		"INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash, expires_at) VALUES (?, ?, ?, ?)",
+		userID, key, hash, now.Add(ttl))
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok && mysqlErr.Number == 1062 {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// replayIdempotentResponse віддає збережену відповідь на ключ
+func replayIdempotentResponse(ctx context.Context, w http.ResponseWriter, userID int, key, hash string) {
+	var storedHash, contentType, etag, location string
+	var status sql.NullInt64
+	var body []byte
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT request_hash, status_code, content_type, etag, location, response_body FROM idempotency_keys WHERE user_id = ? AND idempotency_key = ?",
+		userID, key).Scan(&storedHash, &status, &contentType, &etag, &location, &body)
+	if err == sql.ErrNoRows {
+		// Перший запит завершився помилкою сервера і звільнив ключ - клієнт може повторити
+		http.Error(w, "Request with this Idempotency-Key failed, retry it", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		metrics.DBErrorsTotal.Inc("idempotency_select")
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if storedHash != hash {
+		metrics.IdempotencyKeysTotal.Inc("mismatch")
+		http.Error(w, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+		return
+	}
+	if !status.Valid {
+		metrics.IdempotencyKeysTotal.Inc("in_progress")
+		http.Error(w, "Request with this Idempotency-Key is still in progress", http.StatusConflict)
+		return
+	}
+
+	metrics.IdempotencyKeysTotal.Inc("replayed")
+	log.Printf("Idempotency: replaying stored response for user %d", userID)
+	for name, value := range map[string]string{"Content-Type": contentType, "ETag": etag, "Location": location} {
+		if value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	w.Header().Set(IdempotentReplayedHeader, "true")
+	w.WriteHeader(int(status.Int64))
+	w.Write(body)
+}
+
+// sweepIdempotencyKeys не частіше ніж раз на 10 хвилин видаляє прострочені ключі
+func sweepIdempotencyKeys(ctx context.Context) {
+	idempotencySweepMu.Lock()
+	if time.Since(idempotencyLastSweep) < 10*time.Minute {
+		idempotencySweepMu.Unlock()
+		return
+	}
+	idempotencyLastSweep = time.Now()
+	idempotencySweepMu.Unlock()
+
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM idempotency_keys WHERE expires_at <= ?", time.Now().UTC()); err != nil {
+		metrics.DBErrorsTotal.Inc("idempotency_sweep")
+	}
+}
@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"study_grade/db"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/go-sql-driver/mysql"
+)
+
+func mockDB(t *testing.T) sqlmock.Sqlmock {
+	t.Helper()
+	mockDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := db.DB
+	db.DB = mockDB
+	// Очищення прострочених ключів тут не перевіряється
+	idempotencySweepMu.Lock()
+	idempotencyLastSweep = time.Now()
+	idempotencySweepMu.Unlock()
+	t.Cleanup(func() {
+		db.DB = prev
+		mockDB.Close()
+	})
+	return mock
+}
+
+func idempotentRequest() *http.Request {
+	r := httptest.NewRequest("POST", "/api/grades", strings.NewReader(`{"subject":"Math"}`))
+	r.Header.Set(IdempotencyKeyHeader, "b7e1c0de-0000-4000-8000-000000000001")
+	return r.WithContext(context.WithValue(r.Context(), "userID", 10))
+}
+
+// Перший запит зберігає ETag і Location разом із тілом
+func TestIdempotencyMiddlewareStoresResponseHeaders(t *testing.T) {
+	mock := mockDB(t)
+	mock.ExpectExec(`DELETE FROM idempotency_keys WHERE user_id = \? AND idempotency_key = \? AND expires_at <= \?`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO idempotency_keys`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE idempotency_keys SET status_code = \?, content_type = \?, etag = \?, location = \?, response_body = \?`).
+		WithArgs(http.StatusCreated, "application/json", `"51-1"`, "/api/grades/51", []byte(`{"id":51}`), 10, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	handler := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", `"51-1"`)
+		w.Header().Set("Location", "/api/grades/51")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":51}`))
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, idempotentRequest())
+
+	if w.Code != http.StatusCreated {
+		t.Fatalf("got status %d, want 201", w.Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// Повтор з тим самим ключем отримує збережені ETag і Location без виклику обробника
+func TestIdempotencyMiddlewareReplaysResponseHeaders(t *testing.T) {
+	mock := mockDB(t)
+	r := idempotentRequest()
+	mock.ExpectExec(`DELETE FROM idempotency_keys WHERE user_id = \? AND idempotency_key = \? AND expires_at <= \?`).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO idempotency_keys`).WillReturnError(&mysql.MySQLError{Number: 1062})
+	mock.ExpectQuery(`SELECT request_hash, status_code, content_type, etag, location, response_body FROM idempotency_keys`).
+		WillReturnRows(sqlmock.NewRows([]string{"request_hash", "status_code", "content_type", "etag", "location", "response_body"}).
+			AddRow(idempotencyHash(r, []byte(`{"subject":"Math"}`)), http.StatusCreated, "application/json", `"51-1"`, "/api/grades/51", []byte(`{"id":51}`)))
+
+	handler := IdempotencyMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("handler must not run on replay")
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	if w.Code != http.StatusCreated || w.Body.String() != `{"id":51}` {
+		t.Fatalf("got %d %s, want the stored 201 response", w.Code, w.Body.String())
+	}
+	for name, want := range map[string]string{"ETag": `"51-1"`, "Location": "/api/grades/51", IdempotentReplayedHeader: "true"} {
+		if got := w.Header().Get(name); got != want {
+			t.Errorf("got %s %q, want %q", name, got, want)
+		}
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -2,18 +2,43 @@ package middleware
 
 import (
 	"context"
+	"database/sql"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"study_grade/db"
+	"study_grade/metrics"
+	"study_grade/ratelimit"
+	"time"
 
 	"github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
 )
 
 // Рекомендовано зберігати секретний ключ в змінних оточення, а не прямо в коді!
 var jwtSecret = []byte("supersecretkey") // !!! ЗМІНІТЬ ЦЕЙ КЛЮЧ НА НАДІЙНІШИЙ І ЗБЕРІГАЙТЕ В БЕЗПЕЧНОМУ МІСЦІ !!!
 
-// JWTAuthMiddleware перевіряє наявність та валідність JWT токена
+// Області дії (claim "scope") JWT токенів
+const (
+	ScopeFull   = "full"   // повний доступ після завершення входу
+	ScopeMFA    = "mfa"    // пароль перевірено, очікується код двофакторної автентифікації
+	ScopeEnroll = "enroll" // роль вимагає 2FA, але її ще не налаштовано - доступне лише налаштування 2FA
+	ScopeSSO    = "sso"    // вхід через провайдера OIDC підтверджено, токен обмінюється на відповідь входу
+)
+
+// JWTAuthMiddleware перевіряє наявність та валідність JWT токена або персонального токена доступу
 func JWTAuthMiddleware(next http.Handler) http.Handler {
+	return jwtAuth(next, true, ScopeFull)
+}
+
+// EnrollmentAuthMiddleware пропускає також токени, видані лише для налаштування 2FA
+// (персональні токени тут не приймаються)
+func EnrollmentAuthMiddleware(next http.Handler) http.Handler {
+	return jwtAuth(next, false, ScopeFull, ScopeEnroll)
+}
+
+func jwtAuth(next http.Handler, acceptAPITokens bool, allowedScopes ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		log.Printf("--> JWTAuthMiddleware called for %s %s", r.Method, r.URL.Path) // Детальний лог входу
 
@@ -38,6 +63,17 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 		}
 		log.Println("    JWT: Extracted token string.")
 
+		if strings.HasPrefix(tokenString, APITokenPrefix) {
+			if !acceptAPITokens {
+				log.Println("    JWT: Personal API token is not accepted here. Returning 401.")
+				http.Error(w, "Personal API tokens are not accepted here", http.StatusUnauthorized)
+				return
+			}
+			apiTokenAuth(w, r, next, tokenString, false)
+			log.Println("<-- JWTAuthMiddleware finished processing (API token)")
+			return
+		}
+
 		// Парсимо та перевіряємо токен
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 			// Перевіряємо метод підпису токена (наприклад, HMAC)
@@ -79,10 +115,67 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 			return
 		}
 		userID := int(userIDFloat)
+
+		// Токени без scope видані до появи 2FA і мають повний доступ
+		scope, _ := claims["scope"].(string)
+		if scope == "" {
+			scope = ScopeFull
+		}
+		if !scopeAllowed(scope, allowedScopes) {
+			log.Printf("    JWT: Token scope %q is not allowed here. Returning 401.", scope)
+			http.Error(w, "Two-factor authentication required", http.StatusUnauthorized)
+			log.Println("<-- JWTAuthMiddleware exited (Scope not allowed)")
+			return
+		}
+		tokenVersion, _ := claims["tv"].(float64)
+
+		// Стан користувача читається з бази, щоб зміна пароля відкликала старі токени,
+		// а зміна ролі діяла одразу
+		dbCtx, cancel := db.WithTimeout(r.Context())
+		var role string
+		var currentVersion, institutionID int
+		var disabled bool
+		err = db.DB.QueryRowContext(dbCtx, "SELECT role, token_version, disabled, institution_id FROM users WHERE id = ?", userID).Scan(&role, &currentVersion, &disabled, &institutionID)
+		cancel()
+		if err == sql.ErrNoRows {
+			log.Printf("    JWT: User %d no longer exists. Returning 401.", userID)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			log.Println("<-- JWTAuthMiddleware exited (Unknown user)")
+			return
+		}
+		if err != nil {
+			log.Printf("    JWT: Failed to load user %d: %v. Returning 500.", userID, err)
+			metrics.DBErrorsTotal.Inc("auth_select_user")
+			http.Error(w, "Database error", http.StatusInternalServerError)
+			log.Println("<-- JWTAuthMiddleware exited (Database error)")
+			return
+		}
+		if int(tokenVersion) != currentVersion {
+			log.Printf("    JWT: Token for user %d was revoked (version %d, current %d). Returning 401.", userID, int(tokenVersion), currentVersion)
+			http.Error(w, "Token revoked", http.StatusUnauthorized)
+			log.Println("<-- JWTAuthMiddleware exited (Revoked token)")
+			return
+		}
+		// Токен прив'язаний до установи: після переведення користувача старі токени недійсні
+		if inst, ok := claims["inst"].(float64); ok && int(inst) != institutionID {
+			log.Printf("    JWT: Token for user %d was issued for institution %d (current %d). Returning 401.", userID, int(inst), institutionID)
+			http.Error(w, "Token revoked", http.StatusUnauthorized)
+			log.Println("<-- JWTAuthMiddleware exited (Institution changed)")
+			return
+		}
+		if disabled {
+			log.Printf("    JWT: User %d is disabled. Returning 403.", userID)
+			http.Error(w, "Account disabled", http.StatusForbidden)
+			log.Println("<-- JWTAuthMiddleware exited (Disabled account)")
+			return
+		}
 		log.Printf("    JWT: Token validated successfully for user ID: %d", userID)
 
-		// Додаємо user_id в контекст запиту, щоб обробники могли його отримати
+		// Додаємо user_id, роль, установу та scope в контекст запиту, щоб обробники могли їх отримати
 		ctx := context.WithValue(r.Context(), "userID", userID)
+		ctx = context.WithValue(ctx, "userRole", role)
+		ctx = context.WithValue(ctx, "institutionID", institutionID)
+		ctx = context.WithValue(ctx, "tokenScope", scope)
 		log.Println("    JWT: User ID added to context. Proceeding to next handler.") // Лог успішного проходження
 
 		// Передаємо запит далі по ланцюгу обробників (до кінцевого handler)
@@ -91,50 +184,91 @@ func JWTAuthMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// CORSMiddleware залишається без змін з попереднього кроку
-func CORSMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		log.Printf("--> CORSMiddleware called for %s %s", r.Method, r.URL.Path) // Детальний лог входу
-
-		origin := r.Header.Get("Origin")
-		log.Printf("    CORS: Request Origin header: %s", origin)
-
-		// Встановлення заголовків Access-Control
-		if origin != "" {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Credentials", "true") // Вмикаємо підтримку credentials (наприклад, Authorization)
-			log.Printf("    CORS: Set Allow-Origin: %s, Allow-Credentials: true", origin)
-		} else {
-			// Для запитів з того ж орігіну, або якщо Origin відсутній
-			log.Println("    CORS: No Origin header found (likely same-origin).")
-			// Тут можна вирішити, чи встановлювати *, чи нічого.
-			// Якщо credentials використовуються (Auth header), * без credentials=true не працює.
-			// Найбезпечніше, якщо Origin присутній, ставити його + credentials.
-			// Якщо ні, нічого не ставити або ставити * без credentials, якщо ви впевнені, що такі запити можливі і потрібні.
-			// Поточна логіка (тільки якщо Origin присутній, встановлюємо конкретний Origin + Credentials) є правильною для крос-оріджин запитів з credentials.
+func scopeAllowed(scope string, allowed []string) bool {
+	for _, s := range allowed {
+		if s == scope {
+			return true
 		}
+	}
+	return false
+}
 
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE") // Дозволені методи
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")     // Дозволені заголовки
-
-		// Логування встановлених заголовків перед продовженням
-		log.Printf("    CORS: Set Headers: Allow-Origin='%s', Allow-Credentials='%s', Allow-Methods='%s', Allow-Headers='%s'",
-			w.Header().Get("Access-Control-Allow-Origin"),
-			w.Header().Get("Access-Control-Allow-Credentials"),
-			w.Header().Get("Access-Control-Allow-Methods"),
-			w.Header().Get("Access-Control-Allow-Headers"))
-
-		// Обробка preflight OPTIONS запитів
-		if r.Method == "OPTIONS" {
-			log.Println("    CORS: Handling OPTIONS preflight request. Sending 200 OK.")
-			w.WriteHeader(http.StatusOK)
-			log.Println("<-- CORSMiddleware exited (OPTIONS handled)") // Лог виходу
-			return                                                     // !!! Зупиняємо виконання для OPTIONS !!!
-		}
+// RequireRole пропускає лише користувачів з однією з ролей (після JWTAuthMiddleware).
+// Персональний токен додатково має мати область admin.
+func RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if scope, ok := r.Context().Value("apiTokenScope").(string); ok && !apiScopeAllows(scope, APIScopeAdmin) {
+				log.Printf("API token with scope %q denied for %s %s", scope, r.Method, r.URL.Path)
+				http.Error(w, "Token scope does not allow this operation", http.StatusForbidden)
+				return
+			}
+			role, _ := r.Context().Value("userRole").(string)
+			for _, allowed := range roles {
+				if role == allowed {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+			log.Printf("Access denied for role %q to %s %s", role, r.Method, r.URL.Path)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+		})
+	}
+}
 
-		// Передаємо запит далі для інших методів
-		log.Println("    CORS: Not OPTIONS, calling next handler.")
-		next.ServeHTTP(w, r)
-		log.Println("<-- CORSMiddleware finished processing") // Лог нормального виходу
+// statusRecorder запам'ятовує код відповіді, який записав обробник
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(code int) {
+	rec.status = code
+	rec.ResponseWriter.WriteHeader(code)
+}
+
+// MetricsMiddleware рахує запити та їх тривалість за шаблоном маршруту mux
+func MetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		next.ServeHTTP(rec, r)
+
+		// Використовуємо шаблон маршруту, а не сирий шлях, щоб не роздувати кількість серій
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if tpl, err := current.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		metrics.HTTPRequestsTotal.Inc(r.Method, route, strconv.Itoa(rec.status))
+		metrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), r.Method, route)
 	})
 }
+
+// RateLimitMiddleware обмежує кількість запитів з одного IP і відповідає 429 з Retry-After
+func RateLimitMiddleware(limiter *ratelimit.Limiter, scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ip := ratelimit.ClientIP(r)
+			retryAfter, err := limiter.Allow(r.Context(), scope+":ip:"+ip)
+			if err != nil {
+				// Помилка сховища не повинна блокувати вхід - пропускаємо запит
+				log.Printf("Rate limit store error for %s (%s): %v", scope, ip, err)
+				metrics.DBErrorsTotal.Inc("rate_limit_" + scope)
+			} else if retryAfter > 0 {
+				log.Printf("Rate limit exceeded for %s from %s, retry after %s", scope, ip, retryAfter)
+				metrics.RateLimitedTotal.Inc(scope, "ip")
+				w.Header().Set("Retry-After", ratelimit.RetryAfterSeconds(retryAfter))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
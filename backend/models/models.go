@@ -4,15 +4,132 @@ import (
 	"time"
 )
 
+// Ролі користувачів
+const (
+	RoleAdmin   = "admin"
+	RoleTeacher = "teacher"
+	// RoleSuperadmin керує установами платформи; призначається лише через BOOTSTRAP_SUPERADMIN
+	RoleSuperadmin = "superadmin"
+)
+
+// Roles - ролі, які адміністратор установи може призначати
+var Roles = []string{RoleAdmin, RoleTeacher}
+
 type User struct {
-	ID       int    `json:"id"`
-	Username string `json:"username"`
-	Password string `json:"password,omitempty"` // Не повертаємо пароль
+	ID                 int        `json:"id"`
+	Username           string     `json:"username"`
+	Password           string     `json:"password,omitempty"` // Не повертаємо пароль
+	Email              string     `json:"email,omitempty"`
+	Role               string     `json:"role,omitempty"`
+	TOTPEnabled        bool       `json:"totp_enabled"`
+	Disabled           bool       `json:"disabled"`
+	MustChangePassword bool       `json:"must_change_password"`
+	DepartmentID       *int       `json:"department_id,omitempty"`
+	InstitutionID      int        `json:"institution_id,omitempty"`
+	CreatedAt          *time.Time `json:"created_at,omitempty"`
+	// AuthSource - звідки користувач входить: local (пароль у базі) або ldap (каталог)
+	AuthSource string `json:"auth_source,omitempty"`
+	// TokenVersion збільшується при зміні пароля, що відкликає всі видані токени
+	TokenVersion int `json:"-"`
 }
 
 type RegisterRequest struct {
 	Username string `json:"username"`
 	Password string `json:"password"`
+	Email    string `json:"email,omitempty"`
+	// InviteCode обов'язковий у режимі реєстрації за запрошеннями
+	InviteCode string `json:"invite_code,omitempty"`
+	// Institution - slug установи для відкритої реєстрації (порожній - установа за замовчуванням).
+	// При реєстрації за запрошенням установа береться з запрошення.
+	Institution string `json:"institution,omitempty"`
+}
+
+// Режими реєстрації: REGISTRATION_MODE для всієї платформи і налаштування кожної установи
+const (
+	RegistrationOpen   = "open"
+	RegistrationClosed = "closed"
+	RegistrationInvite = "invite"
+)
+
+type RegistrationModeResponse struct {
+	Mode string `json:"mode"`
+}
+
+// RegistrationPolicy - режим самостійної реєстрації в установі. Відкрита реєстрація
+// за slug можлива лише в установах з режимом open.
+type RegistrationPolicy struct {
+	Mode string `json:"mode"`
+}
+
+// Ієрархія установи: факультет -> підрозділ (кафедра) -> академічна група.
+// Викладачі належать до підрозділу через User.DepartmentID.
+type Faculty struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type Department struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	FacultyID *int   `json:"faculty_id,omitempty"`
+}
+
+type UpdateDepartmentRequest struct {
+	Name *string `json:"name"`
+	// FacultyID = 0 відв'язує підрозділ від факультету
+	FacultyID *int `json:"faculty_id"`
+}
+
+// StudyGroup - академічна група; оцінки пов'язуються з нею за назвою (Grade.Group)
+type StudyGroup struct {
+	ID           int    `json:"id"`
+	Name         string `json:"name"`
+	DepartmentID *int   `json:"department_id,omitempty"`
+}
+
+type UpdateStudyGroupRequest struct {
+	// DepartmentID = 0 відв'язує групу від підрозділу
+	DepartmentID *int `json:"department_id"`
+}
+
+type Invitation struct {
+	ID           int        `json:"id"`
+	Role         string     `json:"role"`
+	DepartmentID *int       `json:"department_id,omitempty"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	CreatedBy    *int       `json:"created_by,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UsedBy       *int       `json:"used_by,omitempty"`
+	UsedAt       *time.Time `json:"used_at,omitempty"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateInvitationRequest struct {
+	Role         string `json:"role"`
+	DepartmentID *int   `json:"department_id"`
+	// ExpiresInHours - термін дії (за замовчуванням 72 години)
+	ExpiresInHours int `json:"expires_in_hours"`
+}
+
+type CreateInvitationResponse struct {
+	Invitation Invitation `json:"invitation"`
+	// Code показується лише один раз; у базі зберігається його хеш
+	Code string `json:"code"`
+}
+
+type ChangePasswordRequest struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+type ForgotPasswordRequest struct {
+	// Username або email облікового запису
+	Login string `json:"login"`
+}
+
+type ResetPasswordRequest struct {
+	Token       string `json:"token"`
+	NewPassword string `json:"new_password"`
 }
 
 type Grade struct {
@@ -31,9 +148,462 @@ type Grade struct {
 	SuccessRate   float64   `json:"success_rate"`
 	QualityRate   float64   `json:"quality_rate"`
 	UserID        int       `json:"user_id"`
+	// AcademicYear визначається сервером за датою, наприклад 2025/2026; якщо передано - має збігатися
+	AcademicYear string `json:"academic_year,omitempty"`
+	// ExamID - запланований іспит, результатом якого є запис (предмет і група мають збігатися)
+	ExamID *int `json:"exam_id,omitempty"`
+	// Version збільшується з кожною зміною запису і повертається як ETag
+	Version int `json:"version"`
+	// Anomalies - причини, з яких запис позначено для перевірки (лише у відповіді на створення, зміну чи відновлення)
+	Anomalies []AnomalyReason `json:"anomalies,omitempty"`
+	// BelowTarget - показники нижче цільових порогів (лише у відповіді на створення, зміну чи відновлення)
+	BelowTarget []ThresholdBreach `json:"below_target,omitempty"`
 }
 
 type LoginResponse struct {
 	Token string `json:"token"`
 	User  User   `json:"user"`
+	// MFARequired - пароль правильний, але потрібен код 2FA: MFAToken слід
+	// надіслати разом з кодом на /api/login/2fa
+	MFARequired bool   `json:"mfa_required,omitempty"`
+	MFAToken    string `json:"mfa_token,omitempty"`
+	// MFAEnrollmentRequired - роль користувача вимагає 2FA; Token дозволяє лише налаштувати її
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
+
+type LoginTwoFactorRequest struct {
+	MFAToken     string `json:"mfa_token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// LoginMethodsResponse - доступні способи входу
+type LoginMethodsResponse struct {
+	Password bool   `json:"password"`
+	OIDC     bool   `json:"oidc"`
+	OIDCName string `json:"oidc_name,omitempty"`
+}
+
+// SSOTokenRequest - токен, отриманий фронтендом після входу через OIDC
+type SSOTokenRequest struct {
+	SSOToken string `json:"sso_token"`
+}
+
+type TwoFactorEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	QRCodePNG  []byte `json:"qr_png"` // base64 у JSON
+}
+
+type TwoFactorCodeRequest struct {
+	Code     string `json:"code"`
+	Password string `json:"password,omitempty"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+	// Token - повний токен, якщо 2FA налаштовувалась під час входу з обов'язковою 2FA
+	Token string `json:"token,omitempty"`
+}
+
+type UserList struct {
+	Users []User `json:"users"`
+	Total int    `json:"total"`
+}
+
+type CreateUserRequest struct {
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Role     string `json:"role"`
+}
+
+type CreateUserResponse struct {
+	User              User   `json:"user"`
+	TemporaryPassword string `json:"temporary_password"`
+}
+
+type UpdateUserRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+	// DepartmentID = 0 відв'язує користувача від підрозділу
+	DepartmentID *int `json:"department_id"`
+}
+
+type UpdateRoleRequest struct {
+	Role string `json:"role"`
+}
+
+type DeleteUserResponse struct {
+	DeletedUserID    int  `json:"deleted_user_id"`
+	ReassignedTo     *int `json:"reassigned_to,omitempty"`
+	GradesReassigned int  `json:"grades_reassigned"`
+	GradesPreserved  int  `json:"grades_preserved"`
+}
+
+// AssignGradesRequest передає записи без власника користувачу; порожній GradeIDs - усі такі записи
+type AssignGradesRequest struct {
+	UserID   int   `json:"user_id"`
+	GradeIDs []int `json:"grade_ids,omitempty"`
+}
+
+type AssignGradesResponse struct {
+	UserID   int `json:"user_id"`
+	Assigned int `json:"assigned"`
+}
+
+type TwoFactorPolicy struct {
+	RequiredRoles []string `json:"required_roles"`
+}
+
+// APIToken - персональний токен доступу (сам токен показується лише при створенні)
+type APIToken struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+type CreateAPITokenRequest struct {
+	Name  string `json:"name"`
+	Scope string `json:"scope"`
+	// ExpiresInDays - 0 або відсутнє значення означає безстроковий токен
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+type CreateAPITokenResponse struct {
+	APIToken
+	Token string `json:"token"`
+}
+
+// Institution - установа (коледж, університет), дані якої ізольовані від інших
+type Institution struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Slug      string    `json:"slug"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateInstitutionRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+	// RegistrationMode - режим реєстрації нової установи (за замовчуванням closed)
+	RegistrationMode string `json:"registration_mode,omitempty"`
+	// Admin - перший адміністратор установи (необов'язково)
+	Admin *CreateUserRequest `json:"admin,omitempty"`
+}
+
+type CreateInstitutionResponse struct {
+	Institution Institution         `json:"institution"`
+	Admin       *CreateUserResponse `json:"admin,omitempty"`
+}
+
+// Рівні ієрархії для статистики
+const (
+	StatsLevelInstitution = "institution"
+	StatsLevelFaculty     = "faculty"
+	StatsLevelDepartment  = "department"
+	StatsLevelGroup       = "group"
+	StatsLevelSubject     = "subject"
+)
+
+// StatsNode - зведені показники вузла ієрархії; показники розраховуються із сумарних
+// кількостей оцінок, тобто зважені за кількістю студентів, а не середні від середніх
+type StatsNode struct {
+	Level string `json:"level"`
+	// ID - ідентифікатор факультету, підрозділу чи зареєстрованої групи;
+	// nil для предмета та для записів, не прив'язаних до ієрархії
+	ID            *int        `json:"id"`
+	Name          string      `json:"name"`
+	Records       int         `json:"records"`
+	TotalStudents int         `json:"total_students"`
+	Grade5        int         `json:"grade_5"`
+	Grade4        int         `json:"grade_4"`
+	Grade3        int         `json:"grade_3"`
+	Grade2        int         `json:"grade_2"`
+	NotPassed     int         `json:"not_passed"`
+	AverageScore  float64     `json:"average_score"`
+	SuccessRate   float64     `json:"success_rate"`
+	QualityRate   float64     `json:"quality_rate"`
+	Children      []StatsNode `json:"children,omitempty"`
+}
+
+// TrendPoint - показники серії за один семестр; дельти - зміна відносно попередньої точки
+type TrendPoint struct {
+	AcademicYear      string   `json:"academic_year"`
+	Semester          int      `json:"semester"`
+	Records           int      `json:"records"`
+	TotalStudents     int      `json:"total_students"`
+	AverageScore      float64  `json:"average_score"`
+	SuccessRate       float64  `json:"success_rate"`
+	QualityRate       float64  `json:"quality_rate"`
+	AverageScoreDelta *float64 `json:"average_score_delta,omitempty"`
+	SuccessRateDelta  *float64 `json:"success_rate_delta,omitempty"`
+	QualityRateDelta  *float64 `json:"quality_rate_delta,omitempty"`
+}
+
+// TrendSeries - часовий ряд групи чи предмета в порядку навчальних років і семестрів.
+// Нахили - зміна показника за семестр за лінійною регресією (nil, якщо точок менше двох).
+type TrendSeries struct {
+	Name              string       `json:"name"`
+	Points            []TrendPoint `json:"points"`
+	AverageScoreSlope *float64     `json:"average_score_slope"`
+	SuccessRateSlope  *float64     `json:"success_rate_slope"`
+	QualityRateSlope  *float64     `json:"quality_rate_slope"`
+}
+
+type TrendsResponse struct {
+	// By - group або subject
+	By     string        `json:"by"`
+	Series []TrendSeries `json:"series"`
+}
+
+// RankedMetric - значення показника групи та її місце серед порівнюваних груп
+type RankedMetric struct {
+	Value float64 `json:"value"`
+	// Rank - 1 для найкращого значення; однакові значення мають однакове місце
+	Rank       int     `json:"rank"`
+	Percentile float64 `json:"percentile"`
+	// DepartmentDiff - різниця із середнім по підрозділу групи (nil, якщо група поза ієрархією)
+	DepartmentDiff *float64 `json:"department_diff,omitempty"`
+}
+
+// ChiSquareTest - критерій хі-квадрат для розподілу оцінок 5/4/3/2/не склали
+type ChiSquareTest struct {
+	ChiSquare   float64 `json:"chi_square"`
+	DF          int     `json:"df"`
+	PValue      float64 `json:"p_value"`
+	Significant bool    `json:"significant"`
+	// LowExpected - деякі очікувані частоти менші за 5, результат слід трактувати обережно
+	LowExpected bool `json:"low_expected"`
+}
+
+type GroupComparison struct {
+	Group          string       `json:"group"`
+	DepartmentID   *int         `json:"department_id,omitempty"`
+	DepartmentName string       `json:"department_name,omitempty"`
+	Records        int          `json:"records"`
+	TotalStudents  int          `json:"total_students"`
+	Grade5         int          `json:"grade_5"`
+	Grade4         int          `json:"grade_4"`
+	Grade3         int          `json:"grade_3"`
+	Grade2         int          `json:"grade_2"`
+	NotPassed      int          `json:"not_passed"`
+	AverageScore   RankedMetric `json:"average_score"`
+	SuccessRate    RankedMetric `json:"success_rate"`
+	QualityRate    RankedMetric `json:"quality_rate"`
+	// VersusRest - чи відрізняється розподіл оцінок групи від решти груп
+	VersusRest *ChiSquareTest `json:"versus_rest,omitempty"`
+}
+
+type GroupComparisonResponse struct {
+	Subject      string            `json:"subject"`
+	Semester     int               `json:"semester"`
+	AcademicYear string            `json:"academic_year,omitempty"`
+	Alpha        float64           `json:"alpha"`
+	Groups       []GroupComparison `json:"groups"`
+	// ChiSquare - чи однакові розподіли оцінок усіх груп (nil, якщо груп менше двох)
+	ChiSquare *ChiSquareTest `json:"chi_square,omitempty"`
+}
+
+// Перевірки, що позначають запис оцінок як підозрілий
+const (
+	AnomalyCheckZScore       = "zscore"         // показник далекий від історії групи з предмета
+	AnomalyCheckAllTopGrades = "all_top_grades" // усі студенти отримали "5"
+)
+
+// Стани перевірки підозрілого запису
+const (
+	AnomalyPending   = "pending"
+	AnomalyConfirmed = "confirmed" // помилка підтверджена
+	AnomalyDismissed = "dismissed" // запис коректний
+)
+
+type AnomalyReason struct {
+	Check  string  `json:"check"`
+	Metric string  `json:"metric,omitempty"`
+	Value  float64 `json:"value"`
+	// Mean і StdDev - історичні значення показника; ZScore - відхилення від них
+	Mean    float64 `json:"mean,omitempty"`
+	StdDev  float64 `json:"std_dev,omitempty"`
+	ZScore  float64 `json:"z_score,omitempty"`
+	History int     `json:"history,omitempty"`
+}
+
+type GradeAnomaly struct {
+	ID         int             `json:"id"`
+	Grade      Grade           `json:"grade"`
+	Reasons    []AnomalyReason `json:"reasons"`
+	Status     string          `json:"status"`
+	Note       string          `json:"note,omitempty"`
+	CreatedAt  time.Time       `json:"created_at"`
+	ReviewedBy *int            `json:"reviewed_by,omitempty"`
+	ReviewedAt *time.Time      `json:"reviewed_at,omitempty"`
+}
+
+type ReviewAnomalyRequest struct {
+	// Status - confirmed або dismissed
+	Status string `json:"status"`
+	Note   string `json:"note"`
+}
+
+// Показники, для яких задаються цільові пороги
+const (
+	MetricAverageScore = "average_score"
+	MetricSuccessRate  = "success_rate"
+	MetricQualityRate  = "quality_rate"
+)
+
+// Threshold - мінімальне цільове значення показника. Без підрозділу і предмета діє на всю установу;
+// для запису застосовується найконкретніший поріг (підрозділ і предмет > предмет > підрозділ > установа).
+type Threshold struct {
+	ID           int       `json:"id"`
+	DepartmentID *int      `json:"department_id,omitempty"`
+	Subject      string    `json:"subject,omitempty"`
+	Metric       string    `json:"metric"`
+	MinValue     float64   `json:"min_value"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// ThresholdBreach - показник запису нижче цільового значення
+type ThresholdBreach struct {
+	ThresholdID *int    `json:"threshold_id,omitempty"`
+	Metric      string  `json:"metric"`
+	Target      float64 `json:"target"`
+	Value       float64 `json:"value"`
+}
+
+type GradeAlert struct {
+	ID int `json:"id"`
+	ThresholdBreach
+	Grade          Grade      `json:"grade"`
+	CreatedAt      time.Time  `json:"created_at"`
+	AcknowledgedBy *int       `json:"acknowledged_by,omitempty"`
+	AcknowledgedAt *time.Time `json:"acknowledged_at,omitempty"`
+}
+
+// AcademicYear - навчальний рік установи з явно заданими датами (формат дат 2006-01-02).
+// Для дат поза заданими роками рік визначається за ACADEMIC_YEAR_START.
+type AcademicYear struct {
+	ID        int    `json:"id"`
+	Name      string `json:"name"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+type CreateAcademicYearResponse struct {
+	AcademicYear
+	// GradesUpdated - скільки існуючих записів перенесено до цього навчального року
+	GradesUpdated int64 `json:"grades_updated"`
+}
+
+// Exam - запланований іспит; після внесення результатів GradeID посилається на запис оцінок
+type Exam struct {
+	ID              int       `json:"id"`
+	Subject         string    `json:"subject"`
+	Group           string    `json:"group"`
+	StartsAt        time.Time `json:"starts_at"`
+	DurationMinutes int       `json:"duration_minutes"`
+	Room            string    `json:"room,omitempty"`
+	ExaminerID      *int      `json:"examiner_id,omitempty"`
+	ExaminerName    string    `json:"examiner_name,omitempty"`
+	GradeID         *int      `json:"grade_id,omitempty"`
+}
+
+// UpdateExamRequest - перенесення іспиту; змінюються лише передані поля (examiner_id = 0 знімає екзаменатора)
+type UpdateExamRequest struct {
+	StartsAt        *time.Time `json:"starts_at"`
+	DurationMinutes *int       `json:"duration_minutes"`
+	Room            *string    `json:"room"`
+	ExaminerID      *int       `json:"examiner_id"`
+}
+
+// Поля природного ключа запису оцінок, за якими виявляються дублікати
+const (
+	GradeKeySubject      = "subject"
+	GradeKeyGroup        = "group"
+	GradeKeySemester     = "semester"
+	GradeKeyDate         = "date"
+	GradeKeyAcademicYear = "academic_year"
+	GradeKeyUser         = "user"
+)
+
+var GradeKeyFields = []string{GradeKeySubject, GradeKeyGroup, GradeKeySemester, GradeKeyDate, GradeKeyAcademicYear, GradeKeyUser}
+
+// AlertRecipients - куди установа отримує сповіщення про показники нижче цільових
+type AlertRecipients struct {
+	Emails     []string `json:"emails"`
+	WebhookURL string   `json:"webhook_url"`
+}
+
+// DuplicatePolicy - поля, які разом утворюють унікальний ключ запису; порожній список вимикає перевірку
+type DuplicatePolicy struct {
+	KeyFields []string `json:"key_fields"`
+}
+
+// DuplicateGradeError - відповідь 409 на спробу створити дублікат
+type DuplicateGradeError struct {
+	Error      string   `json:"error"`
+	ExistingID int      `json:"existing_id"`
+	KeyFields  []string `json:"key_fields"`
+}
+
+// DuplicateSet - записи з однаковим природним ключем
+type DuplicateSet struct {
+	Key    map[string]string `json:"key"`
+	Grades []Grade           `json:"grades"`
+}
+
+// Стратегії злиття дублікатів
+const (
+	MergeKeep = "keep" // залишити запис keep_id, решту видалити
+	MergeSum  = "sum"  // додати кількості решти записів до keep_id і перерахувати показники
+)
+
+type MergeDuplicatesRequest struct {
+	KeepID   int    `json:"keep_id"`
+	MergeIDs []int  `json:"merge_ids"`
+	Strategy string `json:"strategy"`
+}
+
+type MergeDuplicatesResponse struct {
+	Grade  Grade `json:"grade"`
+	Merged []int `json:"merged"`
+}
+
+// Дії, якими створено ревізію запису оцінок
+const (
+	RevisionBaseline = "baseline" // стан запису до ведення історії
+	RevisionCreate   = "create"
+	RevisionUpdate   = "update"
+	RevisionMerge    = "merge"
+	RevisionRestore  = "restore"
+	RevisionReassign = "reassign" // зміна власника запису
+	RevisionDelete   = "delete"   // знімок видаленого запису; відновлення повертає його
+)
+
+// GradeRevision - знімок запису оцінок після зміни; Changes - відмінності від попередньої ревізії
+type GradeRevision struct {
+	ID            int           `json:"id"`
+	Version       int           `json:"version"`
+	Action        string        `json:"action"`
+	RestoredFrom  *int          `json:"restored_from,omitempty"`
+	ChangedBy     *int          `json:"changed_by,omitempty"`
+	ChangedByName string        `json:"changed_by_name,omitempty"`
+	CreatedAt     time.Time     `json:"created_at"`
+	Grade         Grade         `json:"grade"`
+	Changes       []FieldChange `json:"changes"`
+}
+
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   any    `json:"old"`
+	New   any    `json:"new"`
+}
+
+type RestoreGradeRequest struct {
+	Version int `json:"version"`
 }
@@ -0,0 +1,151 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"study_grade/config"
+	"sync"
+	"time"
+)
+
+// Message - повідомлення для користувача
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier доставляє повідомлення користувачам
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Default - нотифікатор, налаштований InitFromEnv (за замовчуванням пише в лог)
+var Default Notifier = LogNotifier{}
+
+// InitFromEnv обирає нотифікатор за NOTIFIER: smtp, file або log
+func InitFromEnv() {
+	switch kind := config.String("NOTIFIER", "log"); kind {
+	case "smtp":
+		Default = &SMTPNotifier{
+			Host:     config.String("SMTP_HOST", "localhost"),
+			Port:     config.Int("SMTP_PORT", 587),
+			Username: config.String("SMTP_USERNAME", ""),
+			Password: config.String("SMTP_PASSWORD", ""),
+			From:     config.String("SMTP_FROM", "no-reply@localhost"),
+		}
+	case "file":
+		Default = &FileNotifier{Path: config.String("NOTIFIER_FILE", "notifications.log")}
+	case "log":
+		Default = LogNotifier{ShowBody: config.Bool("NOTIFIER_LOG_BODY", false)}
+	default:
+		log.Printf("Unknown NOTIFIER %q, falling back to log", kind)
+		Default = LogNotifier{}
+	}
+	if _, ok := Default.(LogNotifier); ok {
+		log.Println("WARNING: NOTIFIER=log does not deliver notifications - users receive no emails (password reset links, alerts). Set NOTIFIER=smtp outside development.")
+	}
+	log.Printf("Notifications are delivered with %T", Default)
+}
+
+// LogNotifier пише повідомлення в лог сервера (для розробки). Текст може містити одноразові
+// посилання (скидання пароля), тому без ShowBody (NOTIFIER_LOG_BODY) у лог потрапляють лише адресат і тема.
+type LogNotifier struct {
+	ShowBody bool
+}
+
+func (n LogNotifier) Notify(ctx context.Context, msg Message) error {
+	if !n.ShowBody {
+		log.Printf("Notification to %s: %s (body omitted, set NOTIFIER_LOG_BODY=true to log it)", msg.To, msg.Subject)
+		return nil
+	}
+	log.Printf("Notification to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// FileNotifier дописує повідомлення у файл (для розробки і тестових стендів)
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (n *FileNotifier) Notify(ctx context.Context, msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	f, err := os.OpenFile(n.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "--- %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.Body)
+	return err
+}
+
+// SMTPNotifier надсилає листи через SMTP (STARTTLS, якщо сервер його підтримує)
+type SMTPNotifier struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+}
+
+func (n *SMTPNotifier) Notify(ctx context.Context, msg Message) error {
+	if strings.ContainsAny(msg.To, "\r\n") || msg.To == "" {
+		return fmt.Errorf("invalid recipient address %q", msg.To)
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		auth = smtp.PlainAuth("", n.Username, n.Password, n.Host)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+
+	// net/smtp не приймає контекст, тож лише не починаємо відправку після скасування
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return smtp.SendMail(fmt.Sprintf("%s:%d", n.Host, n.Port), auth, n.From, []string{msg.To}, []byte(b.String()))
+}
+
+// webhookClient - клієнт для вебхуків; таймаут захищає від завислих отримувачів
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// Webhook надсилає payload як JSON методом POST; відповідь не 2xx вважається помилкою
+func Webhook(ctx context.Context, url string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s responded with status %d", url, resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package ratelimit
+
+import (
+	"database/sql"
+	"log"
+	"study_grade/config"
+	"time"
+)
+
+// Обмежувачі, що використовують маршрути входу та реєстрації
+var (
+	// LoginIP - запити до /api/login з одного IP та backoff після невдалих спроб з нього
+	LoginIP *Limiter
+	// LoginUsername - backoff і тимчасове блокування облікового запису після невдалих спроб
+	LoginUsername *Limiter
+	// RegisterIP - кількість реєстрацій з одного IP
+	RegisterIP *Limiter
+	// PasswordResetIP - запити на скидання пароля з одного IP
+	PasswordResetIP *Limiter
+)
+
+// InitFromEnv створює обмежувачі з налаштувань оточення.
+// RATE_LIMIT_STORE=db зберігає стан у базі (для кількох інстансів), інакше - у пам'яті.
+func InitFromEnv(db *sql.DB) {
+	TrustProxyHeaders = config.Bool("RATE_LIMIT_TRUST_PROXY", false)
+
+	ttl := 24 * time.Hour
+	var store Store
+	switch backend := config.String("RATE_LIMIT_STORE", "memory"); backend {
+	case "db":
+		store = NewSQLStore(db, ttl)
+	case "memory":
+		store = NewMemoryStore(ttl)
+	default:
+		log.Printf("Unknown RATE_LIMIT_STORE %q, falling back to memory", backend)
+		store = NewMemoryStore(ttl)
+	}
+
+	backoffAfter := config.Int("LOGIN_BACKOFF_AFTER", 3)
+	baseDelay := config.Duration("LOGIN_BACKOFF_BASE", time.Second)
+	maxDelay := config.Duration("LOGIN_BACKOFF_MAX", time.Minute)
+	lockoutDuration := config.Duration("LOGIN_LOCKOUT_DURATION", 15*time.Minute)
+
+	LoginIP = NewLimiter(store, Policy{
+		Limit:           config.Int("RATE_LIMIT_LOGIN_REQUESTS", 30),
+		Window:          config.Duration("RATE_LIMIT_LOGIN_WINDOW", time.Minute),
+		BackoffAfter:    backoffAfter,
+		BaseDelay:       baseDelay,
+		MaxDelay:        maxDelay,
+		LockoutAfter:    config.Int("LOGIN_IP_LOCKOUT_AFTER", 50),
+		LockoutDuration: lockoutDuration,
+	})
+	LoginUsername = NewLimiter(store, Policy{
+		BackoffAfter:    backoffAfter,
+		BaseDelay:       baseDelay,
+		MaxDelay:        maxDelay,
+		LockoutAfter:    config.Int("LOGIN_LOCKOUT_AFTER", 10),
+		LockoutDuration: lockoutDuration,
+	})
+	RegisterIP = NewLimiter(store, Policy{
+		Limit:  config.Int("RATE_LIMIT_REGISTER_REQUESTS", 5),
+		Window: config.Duration("RATE_LIMIT_REGISTER_WINDOW", time.Hour),
+	})
+	PasswordResetIP = NewLimiter(store, Policy{
+		Limit:  config.Int("RATE_LIMIT_PASSWORD_RESET_REQUESTS", 10),
+		Window: config.Duration("RATE_LIMIT_PASSWORD_RESET_WINDOW", time.Hour),
+	})
+	log.Printf("Rate limiting configured with %T", store)
+}
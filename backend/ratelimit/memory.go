@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemoryStore зберігає стани в пам'яті процесу (підходить для одного інстансу)
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*memoryEntry
+	ttl       time.Duration
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	Entry
+	updatedAt time.Time
+}
+
+// NewMemoryStore створює сховище; записи, що не змінювались довше за ttl, видаляються
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry), ttl: ttl, lastSweep: time.Now()}
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e, ok := s.entries[key]; ok {
+		return e.Entry, nil
+	}
+	return Entry{}, nil
+}
+
+func (s *MemoryStore) Update(ctx context.Context, key string, fn func(e *Entry)) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > time.Minute {
+		s.sweep(now)
+	}
+
+	e, ok := s.entries[key]
+	if !ok {
+		e = &memoryEntry{}
+		s.entries[key] = e
+	}
+	fn(&e.Entry)
+	e.updatedAt = now
+	return e.Entry, nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
+
+// sweep видаляє застарілі записи; викликається під блокуванням
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, e := range s.entries {
+		if now.Sub(e.updatedAt) > s.ttl && !e.BlockedUntil.After(now) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
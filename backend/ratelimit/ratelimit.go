@@ -0,0 +1,167 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Entry - стан одного ключа (IP або користувача)
+type Entry struct {
+	Hits         int       // кількість запитів у поточному вікні
+	WindowStart  time.Time // початок поточного вікна
+	Failures     int       // послідовні невдалі спроби входу
+	LastFailure  time.Time
+	BlockedUntil time.Time
+}
+
+// Store зберігає стани ключів. Update має змінювати запис атомарно,
+// щоб паралельні запити (або інші інстанси) не губили лічильники.
+type Store interface {
+	Get(ctx context.Context, key string) (Entry, error)
+	Update(ctx context.Context, key string, fn func(e *Entry)) (Entry, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Policy - правила обмеження для одного типу ключів
+type Policy struct {
+	// Limit запитів за Window (0 - без обмеження кількості запитів)
+	Limit  int
+	Window time.Duration
+
+	// Після BackoffAfter невдалих спроб кожна наступна блокує ключ на
+	// BaseDelay * 2^(n - BackoffAfter), але не довше за MaxDelay (0 - без backoff)
+	BackoffAfter int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+
+	// Після LockoutAfter невдалих спроб ключ блокується на LockoutDuration (0 - без блокування).
+	// Через LockoutDuration після останньої невдачі лічильник невдач скидається.
+	LockoutAfter    int
+	LockoutDuration time.Duration
+}
+
+// Limiter застосовує Policy до ключів у Store
+type Limiter struct {
+	Store  Store
+	Policy Policy
+	now    func() time.Time
+}
+
+// NewLimiter створює обмежувач
+func NewLimiter(store Store, policy Policy) *Limiter {
+	return &Limiter{Store: store, Policy: policy, now: time.Now}
+}
+
+// Allow рахує запит і повертає, скільки чекати, якщо ключ заблоковано
+// або вичерпано ліміт запитів (0 - запит дозволено)
+func (l *Limiter) Allow(ctx context.Context, key string) (time.Duration, error) {
+	now := l.now()
+	var retryAfter time.Duration
+	_, err := l.Store.Update(ctx, key, func(e *Entry) {
+		if e.BlockedUntil.After(now) {
+			retryAfter = e.BlockedUntil.Sub(now)
+			return
+		}
+		if l.Policy.Limit <= 0 {
+			return
+		}
+		if e.WindowStart.IsZero() || now.Sub(e.WindowStart) >= l.Policy.Window {
+			e.WindowStart = now
+			e.Hits = 0
+		}
+		if e.Hits >= l.Policy.Limit {
+			retryAfter = e.WindowStart.Add(l.Policy.Window).Sub(now)
+			return
+		}
+		e.Hits++
+	})
+	return retryAfter, err
+}
+
+// Blocked повертає час до розблокування ключа, не рахуючи запит
+func (l *Limiter) Blocked(ctx context.Context, key string) (time.Duration, error) {
+	now := l.now()
+	e, err := l.Store.Get(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	if e.BlockedUntil.After(now) {
+		return e.BlockedUntil.Sub(now), nil
+	}
+	return 0, nil
+}
+
+// Failure фіксує невдалу спробу. Повертає час блокування (0 - без блокування)
+// і чи це повне блокування після LockoutAfter невдач.
+func (l *Limiter) Failure(ctx context.Context, key string) (time.Duration, bool, error) {
+	now := l.now()
+	var blockedFor time.Duration
+	var locked bool
+	_, err := l.Store.Update(ctx, key, func(e *Entry) {
+		if l.Policy.LockoutDuration > 0 && !e.LastFailure.IsZero() && now.Sub(e.LastFailure) >= l.Policy.LockoutDuration {
+			e.Failures = 0
+		}
+		e.Failures++
+		e.LastFailure = now
+
+		switch {
+		case l.Policy.LockoutAfter > 0 && e.Failures >= l.Policy.LockoutAfter:
+			blockedFor = l.Policy.LockoutDuration
+			locked = true
+		case l.Policy.BackoffAfter > 0 && e.Failures >= l.Policy.BackoffAfter:
+			blockedFor = backoff(l.Policy.BaseDelay, l.Policy.MaxDelay, e.Failures-l.Policy.BackoffAfter)
+		}
+		if blockedFor > 0 {
+			e.BlockedUntil = now.Add(blockedFor)
+		}
+	})
+	return blockedFor, locked, err
+}
+
+// Success скидає лічильник невдач після успішного входу
+func (l *Limiter) Success(ctx context.Context, key string) error {
+	_, err := l.Store.Update(ctx, key, func(e *Entry) {
+		e.Failures = 0
+		e.LastFailure = time.Time{}
+		e.BlockedUntil = time.Time{}
+	})
+	return err
+}
+
+func backoff(base, max time.Duration, exp int) time.Duration {
+	d := time.Duration(float64(base) * math.Pow(2, float64(exp)))
+	if d <= 0 || (max > 0 && d > max) {
+		return max
+	}
+	return d
+}
+
+// RetryAfterSeconds форматує тривалість для заголовка Retry-After (округлення вгору)
+func RetryAfterSeconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
+
+// TrustProxyHeaders вмикає використання X-Forwarded-For (лише за довіреним reverse proxy)
+var TrustProxyHeaders bool
+
+// ClientIP повертає IP клієнта для ключів обмеження
+func ClientIP(r *http.Request) string {
+	if TrustProxyHeaders {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			first, _, _ := strings.Cut(fwd, ",")
+			if ip := strings.TrimSpace(first); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,191 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// clock - керований час для обмежувача
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time          { return c.t }
+func (c *clock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newTestLimiter(policy Policy) (*Limiter, *clock) {
+	c := &clock{t: time.Date(2025, 1, 20, 9, 0, 0, 0, time.UTC)}
+	l := NewLimiter(NewMemoryStore(time.Hour), policy)
+	l.now = c.now
+	return l, c
+}
+
+var loginPolicy = Policy{
+	BackoffAfter:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        10 * time.Second,
+	LockoutAfter:    8,
+	LockoutDuration: 15 * time.Minute,
+}
+
+func TestLimiterFailureBackoff(t *testing.T) {
+	tests := []struct {
+		failure    int
+		wantDelay  time.Duration
+		wantLocked bool
+	}{
+		{failure: 1},
+		{failure: 2},
+		{failure: 3, wantDelay: time.Second},
+		{failure: 4, wantDelay: 2 * time.Second},
+		{failure: 5, wantDelay: 4 * time.Second},
+		{failure: 6, wantDelay: 8 * time.Second},
+		{failure: 7, wantDelay: 10 * time.Second}, // обмежено MaxDelay
+		{failure: 8, wantDelay: 15 * time.Minute, wantLocked: true},
+	}
+	l, c := newTestLimiter(loginPolicy)
+	ctx := context.Background()
+	for _, tt := range tests {
+		delay, locked, err := l.Failure(ctx, "user:olena")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if delay != tt.wantDelay || locked != tt.wantLocked {
+			t.Errorf("failure %d: got (%s, %v), want (%s, %v)", tt.failure, delay, locked, tt.wantDelay, tt.wantLocked)
+		}
+		blocked, _ := l.Blocked(ctx, "user:olena")
+		if blocked != tt.wantDelay {
+			t.Errorf("failure %d: Blocked = %s, want %s", tt.failure, blocked, tt.wantDelay)
+		}
+		// Кожна наступна спроба - після розблокування
+		c.advance(tt.wantDelay + time.Second)
+	}
+}
+
+func TestLimiterLockoutExpires(t *testing.T) {
+	l, c := newTestLimiter(Policy{LockoutAfter: 2, LockoutDuration: 15 * time.Minute})
+	ctx := context.Background()
+	l.Failure(ctx, "user:olena")
+	if _, locked, _ := l.Failure(ctx, "user:olena"); !locked {
+		t.Fatal("second failure should lock the key")
+	}
+
+	c.advance(14 * time.Minute)
+	if blocked, _ := l.Blocked(ctx, "user:olena"); blocked != time.Minute {
+		t.Errorf("Blocked during lockout = %s, want 1m", blocked)
+	}
+	if retry, _ := l.Allow(ctx, "user:olena"); retry != time.Minute {
+		t.Errorf("Allow during lockout = %s, want 1m", retry)
+	}
+
+	// Після LockoutDuration ключ розблоковано, а лічильник невдач починається заново
+	c.advance(time.Minute)
+	if blocked, _ := l.Blocked(ctx, "user:olena"); blocked != 0 {
+		t.Errorf("Blocked after lockout = %s, want 0", blocked)
+	}
+	if delay, locked, _ := l.Failure(ctx, "user:olena"); delay != 0 || locked {
+		t.Errorf("first failure after lockout: got (%s, %v), want no block", delay, locked)
+	}
+}
+
+func TestLimiterSuccessResetsFailures(t *testing.T) {
+	l, c := newTestLimiter(loginPolicy)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		l.Failure(ctx, "user:olena")
+	}
+	if err := l.Success(ctx, "user:olena"); err != nil {
+		t.Fatal(err)
+	}
+	if blocked, _ := l.Blocked(ctx, "user:olena"); blocked != 0 {
+		t.Errorf("Blocked after success = %s, want 0", blocked)
+	}
+	c.advance(time.Second)
+	if delay, _, _ := l.Failure(ctx, "user:olena"); delay != 0 {
+		t.Errorf("failure after success blocked for %s, want counting from 1", delay)
+	}
+}
+
+func TestLimiterAllowWindow(t *testing.T) {
+	l, c := newTestLimiter(Policy{Limit: 2, Window: time.Minute})
+	ctx := context.Background()
+	tests := []struct {
+		after     time.Duration
+		wantRetry time.Duration
+	}{
+		{after: 0},
+		{after: 10 * time.Second},
+		{after: 20 * time.Second, wantRetry: 30 * time.Second},
+		{after: 29 * time.Second, wantRetry: time.Second},
+		{after: time.Second}, // нове вікно
+		{after: 0},
+		{after: 0, wantRetry: time.Minute},
+	}
+	for i, tt := range tests {
+		c.advance(tt.after)
+		retry, err := l.Allow(ctx, "ip:192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if retry != tt.wantRetry {
+			t.Errorf("request %d: retry after %s, want %s", i+1, retry, tt.wantRetry)
+		}
+	}
+	// Інші ключі мають власні лічильники
+	if retry, _ := l.Allow(ctx, "ip:192.0.2.2"); retry != 0 {
+		t.Errorf("other key limited for %s", retry)
+	}
+}
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{time.Second, "1"},
+		{1500 * time.Millisecond, "2"},
+		{time.Millisecond, "1"},
+		{15 * time.Minute, "900"},
+	}
+	for _, tt := range tests {
+		if got := RetryAfterSeconds(tt.d); got != tt.want {
+			t.Errorf("RetryAfterSeconds(%s) = %s, want %s", tt.d, got, tt.want)
+		}
+	}
+}
+
+func TestSQLStoreUpdateLocksRow(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	blockedUntil := time.Date(2025, 1, 20, 9, 5, 0, 0, time.UTC)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT IGNORE INTO rate_limits \(bucket_key\) VALUES \(\?\)`).WithArgs("user:olena").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT hits, window_start, failures, last_failure, blocked_until FROM rate_limits WHERE bucket_key = \? FOR UPDATE`).
+		WithArgs("user:olena").
+		WillReturnRows(sqlmock.NewRows([]string{"hits", "window_start", "failures", "last_failure", "blocked_until"}).AddRow(0, nil, 2, nil, nil))
+	mock.ExpectExec(`UPDATE rate_limits SET hits = \?, window_start = \?, failures = \?, last_failure = \?, blocked_until = \?, updated_at = \? WHERE bucket_key = \?`).
+		WithArgs(0, nil, 3, nil, blockedUntil, sqlmock.AnyArg(), "user:olena").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	s := NewSQLStore(db, time.Hour)
+	e, err := s.Update(context.Background(), "user:olena", func(e *Entry) {
+		e.Failures++
+		e.BlockedUntil = blockedUntil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Failures != 3 {
+		t.Errorf("got %d failures, want 3", e.Failures)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
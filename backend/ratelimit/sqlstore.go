@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// SQLStore зберігає стани в таблиці rate_limits, щоб ліміти були спільними
+// для всіх інстансів бекенду
+type SQLStore struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewSQLStore створює сховище поверх таблиці rate_limits (створюється в db.InitDB)
+func NewSQLStore(db *sql.DB, ttl time.Duration) *SQLStore {
+	return &SQLStore{db: db, ttl: ttl, lastSweep: time.Now()}
+}
+
+func (s *SQLStore) Get(ctx context.Context, key string) (Entry, error) {
+	e, err := scanEntry(s.db.QueryRowContext(ctx,
+		"SELECT hits, window_start, failures, last_failure, blocked_until FROM rate_limits WHERE bucket_key = ?", key))
+	if err == sql.ErrNoRows {
+		return Entry{}, nil
+	}
+	return e, err
+}
+
+func (s *SQLStore) Update(ctx context.Context, key string, fn func(e *Entry)) (Entry, error) {
+	s.maybeSweep(ctx)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Entry{}, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "INSERT IGNORE INTO rate_limits (bucket_key) VALUES (?)", key); err != nil {
+		return Entry{}, err
+	}
+	e, err := scanEntry(tx.QueryRowContext(ctx,
+		"SELECT hits, window_start, failures, last_failure, blocked_until FROM rate_limits WHERE bucket_key = ? FOR UPDATE", key))
+	if err != nil {
+		return Entry{}, err
+	}
+
+	fn(&e)
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE rate_limits SET hits = ?, window_start = ?, failures = ?, last_failure = ?, blocked_until = ?, updated_at = ? WHERE bucket_key = ?",
+		e.Hits, nullTime(e.WindowStart), e.Failures, nullTime(e.LastFailure), nullTime(e.BlockedUntil), time.Now().UTC(), key)
+	if err != nil {
+		return Entry{}, err
+	}
+	return e, tx.Commit()
+}
+
+func (s *SQLStore) Delete(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM rate_limits WHERE bucket_key = ?", key)
+	return err
+}
+
+// maybeSweep не частіше ніж раз на 10 хвилин видаляє застарілі записи
+func (s *SQLStore) maybeSweep(ctx context.Context) {
+	s.mu.Lock()
+	if time.Since(s.lastSweep) < 10*time.Minute {
+		s.mu.Unlock()
+		return
+	}
+	s.lastSweep = time.Now()
+	s.mu.Unlock()
+
+	now := time.Now().UTC()
+	s.db.ExecContext(ctx,
+		"DELETE FROM rate_limits WHERE updated_at < ? AND (blocked_until IS NULL OR blocked_until < ?)",
+		now.Add(-s.ttl), now)
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanEntry(row rowScanner) (Entry, error) {
+	var e Entry
+	var windowStart, lastFailure, blockedUntil sql.NullTime
+	if err := row.Scan(&e.Hits, &windowStart, &e.Failures, &lastFailure, &blockedUntil); err != nil {
+		return Entry{}, err
+	}
+	e.WindowStart = windowStart.Time
+	e.LastFailure = lastFailure.Time
+	e.BlockedUntil = blockedUntil.Time
+	return e, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	if t.IsZero() {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
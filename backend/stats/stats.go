@@ -0,0 +1,209 @@
+package stats
+
+import "math"
+
+// Статистичні функції для аналітики оцінок. Без зовнішніх залежностей:
+// обсяги даних невеликі, а потрібні лише кілька класичних формул.
+
+// Mean повертає середнє арифметичне (0 для порожнього набору)
+func Mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// StdDev повертає вибіркове стандартне відхилення (0, якщо значень менше двох)
+func StdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := Mean(values)
+	var sum float64
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
+
+// ZScore повертає, на скільки стандартних відхилень v віддалене від mean.
+// ok = false при нульовому відхиленні.
+func ZScore(v, mean, stdDev float64) (z float64, ok bool) {
+	if stdDev == 0 {
+		return 0, false
+	}
+	return (v - mean) / stdDev, true
+}
+
+// Slope повертає нахил лінійної регресії (метод найменших квадратів) значень
+// відносно їхніх порядкових номерів 0, 1, 2... - тобто зміну за один період.
+// ok = false, якщо точок менше двох.
+func Slope(values []float64) (slope float64, ok bool) {
+	n := len(values)
+	if n < 2 {
+		return 0, false
+	}
+	meanX := float64(n-1) / 2
+	meanY := Mean(values)
+	var num, den float64
+	for i, y := range values {
+		dx := float64(i) - meanX
+		num += dx * (y - meanY)
+		den += dx * dx
+	}
+	return num / den, true
+}
+
+// PercentileRank повертає відсоток значень набору, нижчих за v (рівні рахуються наполовину)
+func PercentileRank(values []float64, v float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	var below, equal float64
+	for _, x := range values {
+		switch {
+		case x < v:
+			below++
+		case x == v:
+			equal++
+		}
+	}
+	return (below + equal/2) / float64(len(values)) * 100
+}
+
+// ChiSquareResult - результат критерію хі-квадрат для таблиці спряженості
+type ChiSquareResult struct {
+	Statistic float64
+	DF        int
+	PValue    float64
+	// LowExpected - частина очікуваних частот менша за 5, наближення хі-квадрат ненадійне
+	LowExpected bool
+}
+
+// ChiSquare перевіряє однорідність розподілів рядків таблиці спряженості
+// (рядки - групи, стовпці - категорії). Порожні рядки і стовпці не враховуються.
+// ok = false, якщо після цього лишається менше двох рядків чи стовпців.
+func ChiSquare(table [][]float64) (result ChiSquareResult, ok bool) {
+	var rowTotals []float64
+	var rows [][]float64
+	for _, row := range table {
+		var sum float64
+		for _, v := range row {
+			sum += v
+		}
+		if sum > 0 {
+			rows = append(rows, row)
+			rowTotals = append(rowTotals, sum)
+		}
+	}
+	if len(rows) < 2 {
+		return result, false
+	}
+	colTotals := make([]float64, len(rows[0]))
+	var total float64
+	for _, row := range rows {
+		for j, v := range row {
+			colTotals[j] += v
+			total += v
+		}
+	}
+	cols := 0
+	for _, c := range colTotals {
+		if c > 0 {
+			cols++
+		}
+	}
+	if cols < 2 {
+		return result, false
+	}
+
+	for i, row := range rows {
+		for j, observed := range row {
+			if colTotals[j] == 0 {
+				continue
+			}
+			expected := rowTotals[i] * colTotals[j] / total
+			if expected < 5 {
+				result.LowExpected = true
+			}
+			d := observed - expected
+			result.Statistic += d * d / expected
+		}
+	}
+	result.DF = (len(rows) - 1) * (cols - 1)
+	result.PValue = ChiSquarePValue(result.Statistic, result.DF)
+	return result, true
+}
+
+// ChiSquarePValue - імовірність отримати статистику, не меншу за x, при df ступенях свободи
+func ChiSquarePValue(x float64, df int) float64 {
+	if df <= 0 {
+		return math.NaN()
+	}
+	if x <= 0 {
+		return 1
+	}
+	return RegularizedGammaQ(float64(df)/2, x/2)
+}
+
+const (
+	gammaMaxIterations = 500
+	gammaEpsilon       = 1e-14
+)
+
+// RegularizedGammaQ - верхня регуляризована неповна гамма-функція Q(a, x) = 1 - P(a, x).
+// Для x < a+1 рахується ряд для P, інакше - ланцюговий дріб для Q (Numerical Recipes, 6.2).
+func RegularizedGammaQ(a, x float64) float64 {
+	if x < 0 || a <= 0 {
+		return math.NaN()
+	}
+	if x == 0 {
+		return 1
+	}
+	lgamma, _ := math.Lgamma(a)
+	prefix := math.Exp(-x + a*math.Log(x) - lgamma)
+
+	if x < a+1 {
+		// P(a, x) = prefix * sum x^n / (a (a+1) ... (a+n))
+		term := 1 / a
+		sum := term
+		for n := 1; n < gammaMaxIterations; n++ {
+			term *= x / (a + float64(n))
+			sum += term
+			if math.Abs(term) < math.Abs(sum)*gammaEpsilon {
+				break
+			}
+		}
+		return 1 - prefix*sum
+	}
+
+	// Ланцюговий дріб, модифікований метод Ленца
+	const tiny = 1e-300
+	b := x + 1 - a
+	c := 1 / tiny
+	d := 1 / b
+	h := d
+	for i := 1; i < gammaMaxIterations; i++ {
+		an := -float64(i) * (float64(i) - a)
+		b += 2
+		d = an*d + b
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = b + an/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		h *= delta
+		if math.Abs(delta-1) < gammaEpsilon {
+			break
+		}
+	}
+	return prefix * h
+}
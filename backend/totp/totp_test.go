@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+)
+
+// rfcSecret - ключ тестових векторів RFC 6238 (додаток B) для HMAC-SHA1
+var rfcSecret = base32.StdEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeRFC6238Vectors(t *testing.T) {
+	// Коди RFC мають 8 цифр; 6-значний код - їхні останні 6 цифр (та сама dynamic truncation за модулем 10^6)
+	tests := []struct {
+		unix int64
+		rfc  string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := tt.rfc[len(tt.rfc)-Digits:]; got != want {
+			t.Errorf("T=%d: got %s, want %s", tt.unix, got, want)
+		}
+	}
+}
+
+func TestValidateSkewWindow(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	tests := []struct {
+		name  string
+		delta int64
+		ok    bool
+	}{
+		{"previous step", -1, true},
+		{"current step", 0, true},
+		{"next step", 1, true},
+		{"two steps ago", -2, false},
+		{"two steps ahead", 2, false},
+	}
+	for _, tt := range tests {
+		code, _ := Code(rfcSecret, step+tt.delta)
+		got, ok := Validate(rfcSecret, code, now)
+		if ok != tt.ok || (ok && got != step+tt.delta) {
+			t.Errorf("%s: got (%d, %v), want (%d, %v)", tt.name, got, ok, step+tt.delta, tt.ok)
+		}
+	}
+	if _, ok := Validate(rfcSecret, "12345", now); ok {
+		t.Error("short code accepted")
+	}
+}
+
+// Validate повертає крок коду: той самий код у межах вікна завжди дає той самий крок,
+// тож викликач, що зберігає останній використаний крок, відхиляє повтор
+func TestValidateReplayReportsSameStep(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	code, _ := Code(rfcSecret, Step(now))
+	first, ok := Validate(rfcSecret, code, now)
+	if !ok {
+		t.Fatal("valid code rejected")
+	}
+	second, ok := Validate(rfcSecret, code, now.Add(Period))
+	if !ok || second != first {
+		t.Errorf("replayed code: got step %d (%v), want %d", second, ok, first)
+	}
+}
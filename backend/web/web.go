@@ -0,0 +1,142 @@
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Зібраний фронтенд (frontend/build) копіюється в dist командою `go generate ./web`
+// перед `go build`, після чого бінарник віддає весь застосунок.
+//
+//go:generate sh -c "rm -rf dist && cp -r ../../frontend/build dist && touch dist/.gitkeep"
+
+//go:embed all:dist
+var embedded embed.FS
+
+// asset - підготовлений до віддачі файл (оригінал і, за потреби, gzip-версія)
+type asset struct {
+	name    string
+	content []byte
+	gzipped []byte
+	etag    string
+}
+
+// Handler віддає вбудований фронтенд із SPA-фолбеком на index.html.
+// Запити до /api/ та не-GET запити передаються в notFound.
+func Handler(notFound http.Handler) http.Handler {
+	dist, err := fs.Sub(embedded, "dist")
+	if err != nil {
+		log.Fatal("Failed to open embedded frontend:", err)
+	}
+	if _, err := fs.Stat(dist, "index.html"); err != nil {
+		log.Println("Embedded frontend has no index.html, run `go generate ./web` before building. SPA routes will return 404.")
+	}
+
+	var cache sync.Map // шлях -> *asset
+
+	load := func(name string) (*asset, bool) {
+		if a, ok := cache.Load(name); ok {
+			return a.(*asset), true
+		}
+		content, err := fs.ReadFile(dist, name)
+		if err != nil {
+			return nil, false
+		}
+		sum := sha256.Sum256(content)
+		a := &asset{name: name, content: content, etag: `"` + hex.EncodeToString(sum[:8]) + `"`}
+		if compressible(name) {
+			var buf bytes.Buffer
+			zw, _ := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+			zw.Write(content)
+			zw.Close()
+			if buf.Len() < len(content) {
+				a.gzipped = buf.Bytes()
+			}
+		}
+		actual, _ := cache.LoadOrStore(name, a)
+		return actual.(*asset), true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || r.URL.Path == "/api" || strings.HasPrefix(r.URL.Path, "/api/") {
+			notFound.ServeHTTP(w, r)
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+		if name == "" {
+			name = "index.html"
+		}
+
+		a, ok := load(name)
+		if !ok {
+			// Шлях із розширенням - це відсутній файл, а не маршрут клієнтського роутера
+			if path.Ext(name) != "" {
+				notFound.ServeHTTP(w, r)
+				return
+			}
+			if a, ok = load("index.html"); !ok {
+				notFound.ServeHTTP(w, r)
+				return
+			}
+		}
+		serveAsset(w, r, a)
+	})
+}
+
+func serveAsset(w http.ResponseWriter, r *http.Request, a *asset) {
+	h := w.Header()
+	if ctype := mime.TypeByExtension(path.Ext(a.name)); ctype != "" {
+		h.Set("Content-Type", ctype)
+	}
+	// Файли у static/ мають хеш у назві, тож їх можна кешувати назавжди;
+	// index.html та інші файли щоразу перевіряються по ETag
+	if strings.HasPrefix(a.name, "static/") {
+		h.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		h.Set("Cache-Control", "no-cache")
+	}
+
+	content := a.content
+	etag := a.etag
+	if a.gzipped != nil {
+		h.Add("Vary", "Accept-Encoding")
+		if acceptsGzip(r) {
+			h.Set("Content-Encoding", "gzip")
+			content = a.gzipped
+			etag = strings.TrimSuffix(etag, `"`) + `-gzip"`
+		}
+	}
+	h.Set("ETag", etag)
+
+	http.ServeContent(w, r, a.name, time.Time{}, bytes.NewReader(content))
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.TrimSpace(enc) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+func compressible(name string) bool {
+	switch path.Ext(name) {
+	case ".html", ".css", ".js", ".json", ".map", ".svg", ".txt", ".ico", ".xml":
+		return true
+	}
+	return false
+}
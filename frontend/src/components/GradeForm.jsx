@@ -1,4 +1,4 @@
-import React, { useState } from 'react';
+import React, { useRef, useState } from 'react';
 import { API_BASE_URL } from '../config';
 
 function GradeForm({ userId, token }) {
@@ -15,10 +15,17 @@ function GradeForm({ userId, token }) {
     notPassed: '',
   });
   const [error, setError] = useState('');
+  // Ключ ідемпотентності однієї спроби збереження: повторне надсилання тих самих даних
+  // (наприклад, після обриву з'єднання) не створить другий запис
+  const idempotencyKey = useRef(null);
 
   console.log('GradeForm rendering', { userId, formData, error });
 
+  const newIdempotencyKey = () =>
+    window.crypto?.randomUUID?.() ?? `${Date.now()}-${Math.random().toString(36).slice(2)}`;
+
   const handleChange = (e) => {
+    idempotencyKey.current = null;
     setFormData({ ...formData, [e.target.name]: e.target.value });
   };
 
@@ -53,12 +60,17 @@ function GradeForm({ userId, token }) {
       return;
     }
 
+    if (!idempotencyKey.current) {
+      idempotencyKey.current = newIdempotencyKey();
+    }
+
     try {
       const response = await fetch(`${API_BASE_URL}/api/grades`, {
         method: 'POST',
         headers: {
           'Content-Type': 'application/json',
           'Authorization': `Bearer ${token}`,
+          'Idempotency-Key': idempotencyKey.current,
         },
         body: JSON.stringify({
           date: formData.date,
@@ -76,6 +88,7 @@ function GradeForm({ userId, token }) {
       });
 
       if (response.ok) {
+        idempotencyKey.current = null;
         alert('Дані збережено');
         setFormData({
           date: '',
@@ -89,6 +102,9 @@ function GradeForm({ userId, token }) {
           grade2: '',
           notPassed: '',
         });
+      } else if (response.status === 409 && response.headers.get('Content-Type')?.includes('application/json')) {
+        const duplicate = await response.json();
+        setError(`Такий запис уже існує (№${duplicate.existing_id})`);
       } else {
         const errorText = await response.text();
         setError(errorText || 'Помилка збереження');
@@ -1,13 +1,75 @@
-import React, { useState } from 'react';
+import React, { useState, useEffect } from 'react';
 import { API_BASE_URL } from '../config';
+import TwoFactorEnroll from './TwoFactorEnroll';
+import PasswordReset from './PasswordReset';
 
 function Login({ setUser, setToken, setShowRegister }) {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
+  const [mfaToken, setMfaToken] = useState('');
+  const [code, setCode] = useState('');
+  const [sso, setSso] = useState(null);
+  // Роль вимагає 2FA: токен налаштування не дає доступу до даних, доки 2FA не підтверджено
+  const [enrollment, setEnrollment] = useState(null);
+  // null - форма входу; { token: '' } - запит посилання; { token } - новий пароль за посиланням
+  const [passwordReset, setPasswordReset] = useState(null);
 
   console.log('Login rendering', { username, password, error });
 
+  useEffect(() => {
+    fetch(`${API_BASE_URL}/api/login/methods`)
+      .then((response) => (response.ok ? response.json() : null))
+      .then((methods) => methods?.oidc && setSso(methods.oidc_name || 'SSO'))
+      .catch(() => {});
+
+    // Після входу через провайдера бекенд повертає результат у фрагменті адреси
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    // Посилання зі скидання пароля передає токен у фрагменті, щоб він не потрапляв у логи сервера
+    const resetToken = params.get('reset_token');
+    if (resetToken) {
+      window.history.replaceState(null, '', window.location.pathname + window.location.search);
+      setPasswordReset({ token: resetToken });
+      return;
+    }
+    const ssoToken = params.get('sso_token');
+    const ssoError = params.get('sso_error');
+    if (!ssoToken && !ssoError) {
+      return;
+    }
+    window.history.replaceState(null, '', window.location.pathname + window.location.search);
+    if (ssoError) {
+      setError(`Помилка входу через SSO: ${ssoError}`);
+      return;
+    }
+    fetch(`${API_BASE_URL}/api/oidc/token`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ sso_token: ssoToken }),
+    })
+      .then(async (response) => {
+        if (!response.ok) {
+          setError((await response.text()) || 'Помилка входу через SSO');
+          return;
+        }
+        const { user, token, mfa_required, mfa_token, mfa_enrollment_required } = await response.json();
+        if (mfa_required) {
+          setMfaToken(mfa_token);
+          return;
+        }
+        if (mfa_enrollment_required) {
+          setEnrollment({ user, token });
+          return;
+        }
+        setUser(user);
+        setToken(token);
+      })
+      .catch((err) => {
+        console.error('SSO login error:', err);
+        setError('Не вдалося підключитися до сервера');
+      });
+  }, [setUser, setToken]);
+
   const handleLogin = async (e) => {
     e.preventDefault();
     setError('');
@@ -24,7 +86,15 @@ function Login({ setUser, setToken, setShowRegister }) {
       });
 
       if (response.ok) {
-        const { user, token } = await response.json();
+        const { user, token, mfa_required, mfa_token, mfa_enrollment_required } = await response.json();
+        if (mfa_required) {
+          setMfaToken(mfa_token);
+          return;
+        }
+        if (mfa_enrollment_required) {
+          setEnrollment({ user, token });
+          return;
+        }
         setUser(user);
         setToken(token);
       } else {
@@ -37,6 +107,80 @@ function Login({ setUser, setToken, setShowRegister }) {
     }
   };
 
+  const handleTwoFactor = async (e) => {
+    e.preventDefault();
+    setError('');
+    // Код з автентифікатора має 6 цифр, усе інше вважаємо кодом відновлення
+    const body = /^\d{6}$/.test(code.trim())
+      ? { mfa_token: mfaToken, code: code.trim() }
+      : { mfa_token: mfaToken, recovery_code: code.trim() };
+
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/login/2fa`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify(body),
+      });
+
+      if (response.ok) {
+        const { user, token } = await response.json();
+        setUser(user);
+        setToken(token);
+      } else {
+        const errorText = await response.text();
+        setError(errorText || 'Невірний код');
+      }
+    } catch (err) {
+      console.error('2FA login error:', err);
+      setError('Не вдалося підключитися до сервера');
+    }
+  };
+
+  if (passwordReset) {
+    return <PasswordReset resetToken={passwordReset.token} onBack={() => setPasswordReset(null)} />;
+  }
+
+  if (enrollment) {
+    return (
+      <TwoFactorEnroll
+        enrollToken={enrollment.token}
+        onComplete={(token) => {
+          setUser({ ...enrollment.user, totp_enabled: true });
+          setToken(token);
+        }}
+      />
+    );
+  }
+
+  if (mfaToken) {
+    return (
+      <div className="flex items-center justify-center min-h-screen">
+        <div className="bg-white p-6 rounded shadow-md w-96">
+          <h2 className="text-2xl mb-4">Двофакторна автентифікація</h2>
+          {error && <p className="text-red-500 mb-4">{error}</p>}
+          <form onSubmit={handleTwoFactor}>
+            <input
+              type="text"
+              inputMode="numeric"
+              autoComplete="one-time-code"
+              placeholder="Код з застосунку або код відновлення"
+              value={code}
+              onChange={(e) => setCode(e.target.value)}
+              className="w-full p-2 mb-4 border rounded"
+              required
+            />
+            <button
+              type="submit"
+              className="w-full bg-blue-500 text-white p-2 rounded"
+            >
+              Підтвердити
+            </button>
+          </form>
+        </div>
+      </div>
+    );
+  }
+
   return (
     <div className="flex items-center justify-center min-h-screen">
       <div className="bg-white p-6 rounded shadow-md w-96">
@@ -65,6 +209,22 @@ function Login({ setUser, setToken, setShowRegister }) {
           >
             Увійти
           </button>
+          {sso && (
+            <button
+              type="button"
+              onClick={() => { window.location.href = `${API_BASE_URL}/api/oidc/login`; }}
+              className="w-full bg-green-600 text-white p-2 rounded mb-2"
+            >
+              Увійти через {sso}
+            </button>
+          )}
+          <button
+            type="button"
+            onClick={() => setPasswordReset({ token: '' })}
+            className="w-full text-blue-500 p-2 mb-2"
+          >
+            Забули пароль?
+          </button>
           <button
             type="button"
             onClick={() => setShowRegister(true)}
@@ -0,0 +1,123 @@
+import React, { useState } from 'react';
+import { API_BASE_URL } from '../config';
+
+// Скидання пароля: без resetToken - запит посилання на пошту,
+// з resetToken (з посилання в листі) - встановлення нового пароля
+function PasswordReset({ resetToken, onBack }) {
+  const [login, setLogin] = useState('');
+  const [password, setPassword] = useState('');
+  const [confirm, setConfirm] = useState('');
+  const [error, setError] = useState('');
+  const [done, setDone] = useState('');
+
+  const handleForgot = async (e) => {
+    e.preventDefault();
+    setError('');
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/password/forgot`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ login: login.trim() }),
+      });
+      if (response.ok) {
+        setDone('Якщо такий обліковий запис існує, на його пошту надіслано посилання для скидання пароля.');
+      } else {
+        const errorText = await response.text();
+        setError(errorText || 'Не вдалося надіслати посилання');
+      }
+    } catch (err) {
+      console.error('Password forgot error:', err);
+      setError('Не вдалося підключитися до сервера');
+    }
+  };
+
+  const handleReset = async (e) => {
+    e.preventDefault();
+    setError('');
+    if (password !== confirm) {
+      setError('Паролі не збігаються');
+      return;
+    }
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/password/reset`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ token: resetToken, new_password: password }),
+      });
+      if (response.ok) {
+        setDone('Пароль змінено. Увійдіть з новим паролем.');
+      } else {
+        const errorText = await response.text();
+        setError(errorText || 'Не вдалося змінити пароль');
+      }
+    } catch (err) {
+      console.error('Password reset error:', err);
+      setError('Не вдалося підключитися до сервера');
+    }
+  };
+
+  return (
+    <div className="flex items-center justify-center min-h-screen">
+      <div className="bg-white p-6 rounded shadow-md w-96">
+        <h2 className="text-2xl mb-4">{resetToken ? 'Новий пароль' : 'Скидання пароля'}</h2>
+        {error && <p className="text-red-500 mb-4">{error}</p>}
+        {done ? (
+          <p className="mb-4">{done}</p>
+        ) : resetToken ? (
+          <form onSubmit={handleReset}>
+            <input
+              type="password"
+              autoComplete="new-password"
+              placeholder="Новий пароль"
+              value={password}
+              onChange={(e) => setPassword(e.target.value)}
+              className="w-full p-2 mb-4 border rounded"
+              required
+            />
+            <input
+              type="password"
+              autoComplete="new-password"
+              placeholder="Повторіть пароль"
+              value={confirm}
+              onChange={(e) => setConfirm(e.target.value)}
+              className="w-full p-2 mb-4 border rounded"
+              required
+            />
+            <button
+              type="submit"
+              className="w-full bg-blue-500 text-white p-2 rounded mb-2"
+            >
+              Змінити пароль
+            </button>
+          </form>
+        ) : (
+          <form onSubmit={handleForgot}>
+            <input
+              type="text"
+              placeholder="Ім'я користувача або email"
+              value={login}
+              onChange={(e) => setLogin(e.target.value)}
+              className="w-full p-2 mb-4 border rounded"
+              required
+            />
+            <button
+              type="submit"
+              className="w-full bg-blue-500 text-white p-2 rounded mb-2"
+            >
+              Надіслати посилання
+            </button>
+          </form>
+        )}
+        <button
+          type="button"
+          onClick={onBack}
+          className="w-full bg-gray-500 text-white p-2 rounded"
+        >
+          До входу
+        </button>
+      </div>
+    </div>
+  );
+}
+
+export default PasswordReset;
@@ -1,10 +1,21 @@
-import React, { useState } from 'react';
+import React, { useEffect, useState } from 'react';
 import { API_BASE_URL } from '../config';
+import TwoFactorEnroll from './TwoFactorEnroll';
 
 function Register({ setUser, setToken, setShowRegister }) {
   const [username, setUsername] = useState('');
   const [password, setPassword] = useState('');
   const [error, setError] = useState('');
+  const [inviteCode, setInviteCode] = useState('');
+  const [mode, setMode] = useState('open');
+  const [enrollment, setEnrollment] = useState(null);
+
+  useEffect(() => {
+    fetch(`${API_BASE_URL}/api/register/mode`)
+      .then((response) => (response.ok ? response.json() : null))
+      .then((data) => data && setMode(data.mode))
+      .catch((err) => console.error('Failed to load registration mode:', err));
+  }, []);
 
   console.log('Register rendering', { username, password, error, API_BASE_URL });
 
@@ -30,7 +41,7 @@ function Register({ setUser, setToken, setShowRegister }) {
       const response = await fetch(url, {
         method: 'POST',
         headers: { 'Content-Type': 'application/json' },
-        body: JSON.stringify({ username, password }),
+        body: JSON.stringify({ username, password, invite_code: inviteCode }),
       });
       console.log('Register response status:', response.status, 'for URL:', url);
 
@@ -42,7 +53,11 @@ function Register({ setUser, setToken, setShowRegister }) {
           body: JSON.stringify({ username, password }),
         });
         if (loginResponse.ok) {
-          const { user: newUser, token } = await loginResponse.json();
+          const { user: newUser, token, mfa_enrollment_required } = await loginResponse.json();
+          if (mfa_enrollment_required) {
+            setEnrollment({ user: newUser, token });
+            return;
+          }
           setUser(newUser);
           setToken(token);
         } else {
@@ -58,11 +73,24 @@ function Register({ setUser, setToken, setShowRegister }) {
     }
   };
 
+  if (enrollment) {
+    return (
+      <TwoFactorEnroll
+        enrollToken={enrollment.token}
+        onComplete={(token) => {
+          setUser({ ...enrollment.user, totp_enabled: true });
+          setToken(token);
+        }}
+      />
+    );
+  }
+
   return (
     <div className="flex items-center justify-center min-h-screen">
       <div className="bg-white p-6 rounded shadow-md w-96">
         <h2 className="text-2xl mb-4">Реєстрація</h2>
         {error && <p className="text-red-500 mb-4">{error}</p>}
+        {mode === 'closed' && <p className="text-gray-700 mb-4">Реєстрацію закрито. Зверніться до адміністратора.</p>}
         <form onSubmit={handleRegister}>
           <input
             type="text"
@@ -80,10 +108,20 @@ function Register({ setUser, setToken, setShowRegister }) {
             className="w-full p-2 mb-4 border rounded"
             required
           />
+          {mode === 'invite' && (
+            <input
+              type="text"
+              placeholder="Код запрошення"
+              value={inviteCode}
+              onChange={(e) => setInviteCode(e.target.value)}
+              className="w-full p-2 mb-4 border rounded"
+              required
+            />
+          )}
           <button
             type="submit"
             className="w-full bg-blue-500 text-white p-2 rounded mb-2"
-            disabled={!username || !password}
+            disabled={!username || !password || mode === 'closed' || (mode === 'invite' && !inviteCode)}
           >
             Зареєструватися
           </button>
@@ -0,0 +1,121 @@
+import React, { useState, useEffect } from 'react';
+import { API_BASE_URL } from '../config';
+
+// Обов'язкове налаштування 2FA під час входу: enrollToken дозволяє лише /api/2fa/*,
+// повний токен видає /api/2fa/confirm і передається в onComplete
+function TwoFactorEnroll({ enrollToken, onComplete }) {
+  const [secret, setSecret] = useState('');
+  const [qrUrl, setQrUrl] = useState('');
+  const [code, setCode] = useState('');
+  const [recoveryCodes, setRecoveryCodes] = useState(null);
+  const [fullToken, setFullToken] = useState('');
+  const [error, setError] = useState('');
+
+  useEffect(() => {
+    const headers = { 'Authorization': `Bearer ${enrollToken}` };
+    let objectUrl = '';
+    fetch(`${API_BASE_URL}/api/2fa/enroll`, { method: 'POST', headers })
+      .then(async (response) => {
+        if (!response.ok) {
+          throw new Error((await response.text()) || 'Не вдалося почати налаштування 2FA');
+        }
+        const enrollment = await response.json();
+        setSecret(enrollment.secret);
+        const qr = await fetch(`${API_BASE_URL}/api/2fa/qr.png`, { headers });
+        if (qr.ok) {
+          objectUrl = URL.createObjectURL(await qr.blob());
+          setQrUrl(objectUrl);
+        }
+      })
+      .catch((err) => {
+        console.error('2FA enrollment error:', err);
+        setError(err.message || 'Не вдалося підключитися до сервера');
+      });
+    return () => objectUrl && URL.revokeObjectURL(objectUrl);
+  }, [enrollToken]);
+
+  const handleConfirm = async (e) => {
+    e.preventDefault();
+    setError('');
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/2fa/confirm`, {
+        method: 'POST',
+        headers: {
+          'Content-Type': 'application/json',
+          'Authorization': `Bearer ${enrollToken}`,
+        },
+        body: JSON.stringify({ code: code.trim() }),
+      });
+      if (response.ok) {
+        const { recovery_codes, token } = await response.json();
+        setRecoveryCodes(recovery_codes);
+        setFullToken(token);
+      } else {
+        const errorText = await response.text();
+        setError(errorText || 'Невірний код');
+      }
+    } catch (err) {
+      console.error('2FA confirm error:', err);
+      setError('Не вдалося підключитися до сервера');
+    }
+  };
+
+  if (recoveryCodes) {
+    return (
+      <div className="flex items-center justify-center min-h-screen">
+        <div className="bg-white p-6 rounded shadow-md w-96">
+          <h2 className="text-2xl mb-4">Коди відновлення</h2>
+          <p className="mb-4">
+            Збережіть ці коди в надійному місці. Кожен код можна використати один раз,
+            якщо застосунок-автентифікатор недоступний.
+          </p>
+          <ul className="font-mono mb-4 grid grid-cols-2 gap-1">
+            {recoveryCodes.map((c) => <li key={c}>{c}</li>)}
+          </ul>
+          <button
+            type="button"
+            onClick={() => onComplete(fullToken)}
+            className="w-full bg-blue-500 text-white p-2 rounded"
+          >
+            Продовжити
+          </button>
+        </div>
+      </div>
+    );
+  }
+
+  return (
+    <div className="flex items-center justify-center min-h-screen">
+      <div className="bg-white p-6 rounded shadow-md w-96">
+        <h2 className="text-2xl mb-4">Налаштування двофакторної автентифікації</h2>
+        <p className="mb-4">
+          Для вашої ролі 2FA обов'язкова. Відскануйте QR-код у застосунку-автентифікаторі
+          та введіть код, який він покаже.
+        </p>
+        {error && <p className="text-red-500 mb-4">{error}</p>}
+        {qrUrl && <img src={qrUrl} alt="QR-код для застосунку-автентифікатора" className="mx-auto mb-4" />}
+        {secret && <p className="font-mono text-sm break-all mb-4">Ключ для ручного введення: {secret}</p>}
+        <form onSubmit={handleConfirm}>
+          <input
+            type="text"
+            inputMode="numeric"
+            autoComplete="one-time-code"
+            placeholder="Код з застосунку"
+            value={code}
+            onChange={(e) => setCode(e.target.value)}
+            className="w-full p-2 mb-4 border rounded"
+            required
+          />
+          <button
+            type="submit"
+            className="w-full bg-blue-500 text-white p-2 rounded"
+          >
+            Підтвердити
+          </button>
+        </form>
+      </div>
+    </div>
+  );
+}
+
+export default TwoFactorEnroll;
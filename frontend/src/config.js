@@ -1 +1,2 @@
-export const API_BASE_URL = process.env.REACT_APP_API_URL?.replace(/\/+$/, '') || 'http://localhost:8080';
\ No newline at end of file
+// Порожній REACT_APP_API_URL означає той самий origin (фронтенд вбудований у Go-бекенд)
+export const API_BASE_URL = process.env.REACT_APP_API_URL?.replace(/\/+$/, '') ?? 'http://localhost:8080';